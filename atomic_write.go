@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// writeAtomic chama write com um arquivo temporário no mesmo diretório de path e,
+// só depois de gravar, dar fsync e fechar sem erro, renomeia para path. Em caso de
+// erro o temporário é removido e path não é tocado.
+func writeAtomic(path string, perm os.FileMode, write func(w io.Writer) error) (err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeFileAtomic grava o conteúdo de r em path de forma atômica
+func writeFileAtomic(path string, r io.Reader, perm os.FileMode) error {
+	return writeAtomic(path, perm, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+// errosConcorrentes junta os erros das goroutines de padronização
+type errosConcorrentes struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (e *errosConcorrentes) add(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errs = append(e.errs, err)
+}
+
+// err devolve todos os erros juntos (nil se não houve nenhum)
+func (e *errosConcorrentes) err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return errors.Join(e.errs...)
+}
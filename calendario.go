@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// feriadosCache guarda os feriados já calculados, por ano
+var feriadosCache sync.Map // int -> map[string]bool
+
+// feriadosNacionais devolve os feriados nacionais do ano (calendário ANBIMA),
+// como AAAA-MM-DD: fixos, Carnaval, Sexta-feira Santa e Corpus Christi
+func feriadosNacionais(ano int) map[string]bool {
+	if f, ok := feriadosCache.Load(ano); ok {
+		return f.(map[string]bool)
+	}
+
+	dia := func(mes time.Month, d int) time.Time { return time.Date(ano, mes, d, 0, 0, 0, 0, time.UTC) }
+	pascoa := domingoDePascoa(ano)
+	datas := []time.Time{
+		dia(time.January, 1),
+		pascoa.AddDate(0, 0, -48), // segunda de Carnaval
+		pascoa.AddDate(0, 0, -47), // terça de Carnaval
+		pascoa.AddDate(0, 0, -2),  // Sexta-feira Santa
+		dia(time.April, 21),
+		dia(time.May, 1),
+		pascoa.AddDate(0, 0, 60), // Corpus Christi
+		dia(time.September, 7),
+		dia(time.October, 12),
+		dia(time.November, 2),
+		dia(time.November, 15),
+		dia(time.December, 25),
+	}
+	// Dia da Consciência Negra é feriado nacional desde 2024 (Lei 14.759/2023)
+	if ano >= 2024 {
+		datas = append(datas, dia(time.November, 20))
+	}
+
+	f := make(map[string]bool, len(datas))
+	for _, d := range datas {
+		f[d.Format(layoutData)] = true
+	}
+	feriadosCache.Store(ano, f)
+	return f
+}
+
+// domingoDePascoa calcula a Páscoa pelo algoritmo de Meeus/Jones/Butcher
+func domingoDePascoa(ano int) time.Time {
+	a := ano % 19
+	b, c := ano/100, ano%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	mes := (h + l - 7*m + 114) / 31
+	dia := (h+l-7*m+114)%31 + 1
+	return time.Date(ano, time.Month(mes), dia, 0, 0, 0, 0, time.UTC)
+}
+
+// ehDiaUtil diz se a data é dia útil: nem fim de semana nem feriado nacional
+func ehDiaUtil(t time.Time) bool {
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	return !feriadosNacionais(t.Year())[t.Format(layoutData)]
+}
+
+// diasUteisEntre conta os dias úteis de (desde, ate]; em intervalos longos as
+// semanas inteiras são contadas de uma vez e os feriados descontados depois
+func diasUteisEntre(desde, ate time.Time) int {
+	desde = time.Date(desde.Year(), desde.Month(), desde.Day(), 0, 0, 0, 0, time.UTC)
+	ate = time.Date(ate.Year(), ate.Month(), ate.Day(), 0, 0, 0, 0, time.UTC)
+	if !ate.After(desde) {
+		return 0
+	}
+	dias := int(ate.Sub(desde).Hours()/24 + 0.5)
+	if dias < 14 {
+		n := 0
+		for d := desde.AddDate(0, 0, 1); !d.After(ate); d = d.AddDate(0, 0, 1) {
+			if ehDiaUtil(d) {
+				n++
+			}
+		}
+		return n
+	}
+	n := dias / 7 * 5
+	for d := desde.AddDate(0, 0, dias/7*7+1); !d.After(ate); d = d.AddDate(0, 0, 1) {
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+			n++
+		}
+	}
+	for ano := desde.Year(); ano <= ate.Year(); ano++ {
+		for f := range feriadosNacionais(ano) {
+			d, _ := time.Parse(layoutData, f)
+			if d.After(desde) && !d.After(ate) && d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+				n--
+			}
+		}
+	}
+	return n
+}
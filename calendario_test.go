@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func dataTeste(t *testing.T, s string) time.Time {
+	t.Helper()
+	d, err := time.Parse(layoutData, s)
+	if err != nil {
+		t.Fatalf("data inválida no teste: %q", s)
+	}
+	return d
+}
+
+func TestDomingoDePascoa(t *testing.T) {
+	casos := []struct {
+		ano    int
+		pascoa string
+	}{
+		{2000, "2000-04-23"},
+		{2008, "2008-03-23"},
+		{2019, "2019-04-21"},
+		{2024, "2024-03-31"},
+		{2025, "2025-04-20"},
+		{2038, "2038-04-25"},
+		{2285, "2285-03-22"},
+	}
+	for _, c := range casos {
+		if got := domingoDePascoa(c.ano).Format(layoutData); got != c.pascoa {
+			t.Errorf("domingoDePascoa(%d) = %s, queria %s", c.ano, got, c.pascoa)
+		}
+	}
+}
+
+func TestEhDiaUtil(t *testing.T) {
+	casos := []struct {
+		nome string
+		dia  string
+		util bool
+	}{
+		{"dia comum", "2025-04-22", true},
+		{"sábado", "2025-04-19", false},
+		{"domingo", "2025-04-20", false},
+		{"confraternização", "2025-01-01", false},
+		{"segunda de Carnaval", "2025-03-03", false},
+		{"terça de Carnaval", "2025-03-04", false},
+		{"quarta de cinzas", "2025-03-05", true},
+		{"Sexta-feira Santa", "2025-04-18", false},
+		{"Corpus Christi", "2025-06-19", false},
+		{"Consciência Negra desde 2024", "2024-11-20", false},
+		{"Consciência Negra antes de 2024", "2023-11-20", true},
+		{"Natal", "2024-12-25", false},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			if got := ehDiaUtil(dataTeste(t, c.dia)); got != c.util {
+				t.Errorf("ehDiaUtil(%s) = %v, queria %v", c.dia, got, c.util)
+			}
+		})
+	}
+}
+
+func TestDiasUteisEntre(t *testing.T) {
+	casos := []struct {
+		nome      string
+		desde     string
+		ate       string
+		diasUteis int
+	}{
+		{"mesmo dia", "2025-04-22", "2025-04-22", 0},
+		{"invertido", "2025-04-23", "2025-04-22", 0},
+		{"dia seguinte", "2025-04-22", "2025-04-23", 1},
+		{"fim de semana", "2025-04-25", "2025-04-28", 1},
+		{"Carnaval", "2025-02-28", "2025-03-07", 3},
+		{"fevereiro de 2025", "2025-01-31", "2025-02-28", 20},
+		{"ano de 2025", "2024-12-31", "2025-12-31", 252},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			if got := diasUteisEntre(dataTeste(t, c.desde), dataTeste(t, c.ate)); got != c.diasUteis {
+				t.Errorf("diasUteisEntre(%s, %s) = %d, queria %d", c.desde, c.ate, got, c.diasUteis)
+			}
+		})
+	}
+}
+
+// a contagem por semanas inteiras precisa bater com a contagem dia a dia
+func TestDiasUteisEntreIntervalosLongos(t *testing.T) {
+	inicio := dataTeste(t, "2023-12-20")
+	for _, desde := range []int{0, 3, 11, 40} {
+		for _, dias := range []int{13, 14, 15, 20, 45, 400, 1100} {
+			d0 := inicio.AddDate(0, 0, desde)
+			d1 := d0.AddDate(0, 0, dias)
+			n := 0
+			for d := d0.AddDate(0, 0, 1); !d.After(d1); d = d.AddDate(0, 0, 1) {
+				if ehDiaUtil(d) {
+					n++
+				}
+			}
+			if got := diasUteisEntre(d0, d1); got != n {
+				t.Errorf("diasUteisEntre(%s, %s) = %d, queria %d", d0.Format(layoutData), d1.Format(layoutData), got, n)
+			}
+		}
+	}
+}
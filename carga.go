@@ -0,0 +1,301 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// modoCarga define o que acontece com os dados que já estão na tabela
+type modoCarga string
+
+const (
+	cargaAppend   modoCarga = "append"   // só acrescenta
+	cargaTruncate modoCarga = "truncate" // esvazia a tabela antes de carregar
+	cargaReplace  modoCarga = "replace"  // apaga as linhas dos meses de competência do arquivo antes de carregar
+)
+
+// modoCargaForcado, se definido (--mode na CLI), vale para todas as tabelas
+var modoCargaForcado modoCarga
+
+// configCarga é como um CSV entra na tabela. Com chave, as linhas entram por
+// UPSERT (INSERT ... ON CONFLICT DO UPDATE) e recarregar nunca duplica dados.
+type configCarga struct {
+	modo     modoCarga
+	particao string          // coluna de data cujo mês (competência) é a partição do replace, ex: dt_comptc
+	chave    []string        // chave natural, já com os nomes limpos (cleanColumnName)
+	schema   string          // schema (schemas/<nome>.json) cujos tipos fixam os das colunas, no lugar dos inferidos
+	cadastro map[string]bool // com --foreign-keys, CNPJs de cadastro_fundos; os demais vão para os rejeitos
+
+	// cnpjChave é a coluna cnpj que identifica o fundo (chave no schema): CNPJ
+	// inválido nela recusa a linha; nas demais colunas cnpj* vira NULL com aviso
+	cnpjChave string
+}
+
+// cargasPadrao é a configuração de cada tabela do pipeline, por prefixo do nome
+var cargasPadrao = []struct {
+	prefixo string
+	config  configCarga
+}{
+	{"inf_diario_ultimos_dias", configCarga{modo: cargaReplace, particao: "dt_comptc",
+		chave: []string{"cnpj_fundo_classe", "id_subclasse", "dt_comptc"}, schema: "inf_diario"}},
+	{"fund_metrics", configCarga{modo: cargaReplace, particao: "dt_referencia",
+		chave: []string{"cnpj_fundo_classe", "id_subclasse", "dt_referencia"}, schema: "fund_metrics"}},
+	{"lamina", configCarga{modo: cargaReplace, particao: "dt_comptc", schema: "lamina"}},
+	{"cda_", configCarga{modo: cargaReplace, particao: "dt_comptc", schema: "cda"}},
+	{"fidc_", configCarga{modo: cargaReplace, particao: "dt_comptc", schema: "fidc"}},
+	{"fip", configCarga{modo: cargaReplace, particao: "dt_comptc", schema: "fip"}},
+	// cadastros são fotografias completas: cada carga substitui a anterior
+	{"cadastro_", configCarga{modo: cargaTruncate, schema: "cadastro"}},
+	{"registro_", configCarga{modo: cargaTruncate, schema: "cadastro"}},
+}
+
+// configCargaPara devolve a configuração da tabela; tabelas fora do pipeline usam append
+func configCargaPara(tableName string) configCarga {
+	cfg := configCarga{modo: cargaAppend}
+	for _, c := range cargasPadrao {
+		if strings.HasPrefix(strings.ToLower(tableName), c.prefixo) {
+			cfg = c.config
+			break
+		}
+	}
+	if modoCargaForcado != "" {
+		cfg.modo = modoCargaForcado
+	}
+	return cfg
+}
+
+// parseModoCarga valida o valor de --mode
+func parseModoCarga(s string) (modoCarga, error) {
+	switch m := modoCarga(s); m {
+	case "", cargaAppend, cargaTruncate, cargaReplace:
+		return m, nil
+	}
+	return "", fmt.Errorf("modo de carga inválido: %s (use append, truncate ou replace)", s)
+}
+
+// prepararCarga aplica o modo de carga e garante o índice da chave natural,
+// dentro da mesma transação da carga
+func prepararCarga(tx *sql.Tx, csvFile, tableName string, cfg configCarga) error {
+	switch cfg.modo {
+	case cargaTruncate:
+		if _, err := tx.Exec("TRUNCATE TABLE " + qi(tableName)); err != nil {
+			return fmt.Errorf("erro ao esvaziar %s: %w", tableName, err)
+		}
+		fmt.Printf("✓ Tabela '%s' esvaziada\n", tableName)
+	case cargaReplace:
+		if cfg.particao == "" {
+			return fmt.Errorf("tabela %s sem coluna de partição para o modo replace", tableName)
+		}
+		// apaga o mês inteiro, não só as datas do arquivo: se o último dia útil
+		// de um mês muda (ex: 15/10 → 16/10), a linha antiga do mês também sai
+		meses, err := competenciasParticao(csvFile, cfg.particao)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE date_trunc('month', %s::date) = ANY($1::date[])",
+			qi(tableName), qi(cfg.particao)), pq.Array(meses))
+		if err != nil {
+			return fmt.Errorf("erro ao apagar partição de %s: %w", tableName, err)
+		}
+		n, _ := res.RowsAffected()
+		fmt.Printf("✓ %d linhas de '%s' substituídas (%d meses de %s)\n", n, tableName, len(meses), cfg.particao)
+	}
+
+	if len(cfg.chave) == 0 {
+		return nil
+	}
+	indice := truncarIdentificador(tableName, "_chave_natural")
+	if _, err := tx.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s)",
+		qi(indice), qi(tableName), qis(cfg.chave))); err != nil {
+		return fmt.Errorf("erro ao criar a chave (%s) em %s; se a tabela já tem duplicadas, recarregue com --mode truncate: %w",
+			strings.Join(cfg.chave, ", "), tableName, err)
+	}
+	return nil
+}
+
+// competenciasParticao lê os meses distintos (AAAA-MM-01) da coluna de partição no CSV
+func competenciasParticao(csvFile, coluna string) ([]string, error) {
+	f, reader, header, err := abrirCSVCarga(csvFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	idx := -1
+	for i, h := range header {
+		if h == coluna {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("coluna de partição %s ausente em %s", coluna, csvFile)
+	}
+
+	vistos := map[string]bool{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler %s: %w", csvFile, err)
+		}
+		// linhas sem competência não apagam nada; a carga as recusa (formatoCarga.valor)
+		v := strings.TrimSpace(record[idx])
+		if ehNulo(v) {
+			continue
+		}
+		t, err := time.Parse(layoutData, v)
+		if err != nil {
+			if t, err = time.Parse(layoutDataBR, v); err != nil {
+				return nil, fmt.Errorf("%s=%q não é data em %s", coluna, v, csvFile)
+			}
+		}
+		vistos[t.Format("2006-01")+"-01"] = true
+	}
+
+	valores := make([]string, 0, len(vistos))
+	for v := range vistos {
+		valores = append(valores, v)
+	}
+	sort.Strings(valores)
+	return valores, nil
+}
+
+// criarTabelaTemporaria cria a tabela de staging do UPSERT, descartada no commit
+func criarTabelaTemporaria(tx *sql.Tx, tableName string) (string, error) {
+	staging := truncarIdentificador("carga_"+tableName, "")
+	_, err := tx.Exec(fmt.Sprintf("CREATE TEMP TABLE %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP", qi(staging), qi(tableName)))
+	if err != nil {
+		return "", fmt.Errorf("erro ao criar tabela temporária para %s: %w", tableName, err)
+	}
+	return staging, nil
+}
+
+// formatoCarga diz como cada valor do CSV vai para o banco e guarda as linhas
+// recusadas na carga (CNPJ da chave inválido ou fora do cadastro), que não
+// entram na tabela, e os avisos dos CNPJs secundários anulados
+type formatoCarga struct {
+	arquivo   string
+	chave     map[string]bool
+	cnpjChave string
+	tipos     map[string]string // tipo atual de cada coluna na tabela
+	cadastro  map[string]bool
+	particao  string            // no modo replace, coluna de partição: vazia recusa a linha
+	rejeitos  []linhaQuarentena // recusadas e avisos (Aviso preenchido)
+}
+
+// novoFormatoCarga lê os tipos atuais da tabela, já ajustada por evoluirTabela
+func novoFormatoCarga(tx *sql.Tx, csvFile, tableName string, cfg configCarga) (*formatoCarga, error) {
+	tipos, err := colunasDaTabela(tx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return formatoCargaPara(csvFile, cfg, tipos), nil
+}
+
+// formatoCargaPara monta o formato a partir dos tipos já lidos da tabela
+func formatoCargaPara(csvFile string, cfg configCarga, tipos map[string]string) *formatoCarga {
+	f := &formatoCarga{arquivo: csvFile, chave: map[string]bool{}, cnpjChave: cfg.cnpjChave, tipos: tipos, cadastro: cfg.cadastro}
+	for _, c := range cfg.chave {
+		f.chave[c] = true
+	}
+	if cfg.modo == cargaReplace {
+		f.particao = cfg.particao
+	}
+	return f
+}
+
+// valor normaliza (normalizarValor) e converte (converterValor) um valor do CSV;
+// aviso descreve um CNPJ secundário inválido, gravado como NULL
+func (f *formatoCarga) valor(coluna, val string) (interface{}, string, error) {
+	v, aviso, err := normalizarValor(coluna, val, f.chave[coluna], coluna == f.cnpjChave)
+	if err != nil {
+		return nil, "", err
+	}
+	// sem competência a linha não sairia no próximo replace e seria duplicada a cada recarga
+	if coluna == f.particao && (v == nil || v == "") {
+		return nil, "", fmt.Errorf("%s vazio: no modo replace a linha precisa de competência", coluna)
+	}
+	if f.cadastro != nil && coluna == "cnpj_fundo_classe" {
+		if s, ok := v.(string); ok && !f.cadastro[s] {
+			return nil, "", fmt.Errorf("%s=%q ausente de %s", coluna, s, tabelaCadastroFundos)
+		}
+	}
+	if s, ok := v.(string); ok && s != "" {
+		return converterValor(f.tipos[coluna], s), aviso, nil
+	}
+	return v, aviso, nil
+}
+
+// linha converte um registro do CSV nas primeiras posições de out; se algum
+// valor for recusado, a linha vai para f.rejeitos e linha devolve false.
+// Valores anulados entram em f.rejeitos como aviso e a linha segue.
+func (f *formatoCarga) linha(header, record []string, numero int, out []interface{}) bool {
+	var avisos []string
+	for j, val := range record {
+		v, aviso, err := f.valor(header[j], val)
+		if err != nil {
+			f.rejeitos = append(f.rejeitos, linhaQuarentena{Arquivo: f.arquivo, Linha: numero,
+				Texto: strings.Join(record, ","), Motivo: err.Error()})
+			return false
+		}
+		if aviso != "" {
+			avisos = append(avisos, aviso)
+		}
+		out[j] = v
+	}
+	if len(avisos) > 0 {
+		f.rejeitos = append(f.rejeitos, linhaQuarentena{Arquivo: f.arquivo, Linha: numero,
+			Texto: strings.Join(record, ","), Aviso: avisoCNPJInvalido, Motivo: strings.Join(avisos, "; ")})
+	}
+	return true
+}
+
+// mesclarStaging faz o UPSERT da staging na tabela final; linhas repetidas na
+// chave dentro do mesmo arquivo ficam com a última
+func mesclarStaging(tx *sql.Tx, staging, tableName string, chave []string) (int64, error) {
+	existentes, err := colunasDaTabela(tx, tableName)
+	if err != nil {
+		return 0, err
+	}
+	ehChave := map[string]bool{}
+	for _, c := range chave {
+		ehChave[c] = true
+	}
+
+	var colunas, updates []string
+	for c := range existentes {
+		colunas = append(colunas, c)
+	}
+	sort.Strings(colunas)
+	for _, c := range colunas {
+		if !ehChave[c] {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", qi(c), qi(c)))
+		}
+	}
+	conflito := "DO NOTHING"
+	if len(updates) > 0 {
+		conflito = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+
+	lista, listaChave := qis(colunas), qis(chave)
+	query := fmt.Sprintf(`INSERT INTO %s (%s)
+		SELECT DISTINCT ON (%s) %s FROM %s ORDER BY %s, ctid DESC
+		ON CONFLICT (%s) %s`,
+		qi(tableName), lista,
+		listaChave, lista, qi(staging), listaChave,
+		listaChave, conflito)
+	res, err := tx.Exec(query)
+	if err != nil {
+		return 0, fmt.Errorf("erro no UPSERT de %s: %w", tableName, err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompetenciasParticao(t *testing.T) {
+	casos := []struct {
+		nome     string
+		conteudo string
+		meses    []string
+		erro     string
+	}{
+		{"meses distintos", "CNPJ,DT_COMPTC,VL\na,2025-02-28,1\nb,2025-01-31,2\nc,2025-02-27,3\n",
+			[]string{"2025-01-01", "2025-02-01"}, ""},
+		{"data brasileira", "CNPJ,DT_COMPTC,VL\na,31/03/2025,1\n", []string{"2025-03-01"}, ""},
+		{"competência vazia não entra", "CNPJ,DT_COMPTC,VL\na,,1\nb,2025-01-31,2\n", []string{"2025-01-01"}, ""},
+		{"registro malformado", "CNPJ,DT_COMPTC,VL\na,2025-01-31,1\nb,2025-02-28\nc,2025-03-31,3\n", nil, "erro ao ler"},
+		{"data inválida", "CNPJ,DT_COMPTC,VL\na,fev/2025,1\n", nil, "não é data"},
+		{"sem a coluna", "CNPJ,VL\na,1\n", nil, "ausente"},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			arquivo := filepath.Join(t.TempDir(), "teste.csv")
+			if err := os.WriteFile(arquivo, []byte(c.conteudo), 0644); err != nil {
+				t.Fatal(err)
+			}
+			meses, err := competenciasParticao(arquivo, "dt_comptc")
+			if c.erro != "" {
+				if err == nil || !strings.Contains(err.Error(), c.erro) {
+					t.Fatalf("competenciasParticao = %v, %v; queria erro com %q", meses, err, c.erro)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(meses, c.meses) {
+				t.Errorf("competenciasParticao = %v, queria %v", meses, c.meses)
+			}
+		})
+	}
+}
+
+func TestFormatoCargaParticaoVazia(t *testing.T) {
+	casos := []struct {
+		nome   string
+		modo   modoCarga
+		chave  []string
+		valor  string
+		recusa bool
+	}{
+		{"replace recusa", cargaReplace, nil, "", true},
+		{"replace recusa nulo", cargaReplace, nil, "NA", true},
+		{"replace recusa partição na chave", cargaReplace, []string{"dt_comptc"}, "", true},
+		{"replace aceita data", cargaReplace, nil, "2025-01-31", false},
+		{"append aceita vazio", cargaAppend, nil, "", false},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			cfg := configCarga{modo: c.modo, particao: "dt_comptc", chave: c.chave}
+			f := formatoCargaPara("teste.csv", cfg, map[string]string{"dt_comptc": "date"})
+			out := make([]interface{}, 2)
+			aceita := f.linha([]string{"cnpj_fundo_classe", "dt_comptc"}, []string{"11.222.333/0001-81", c.valor}, 2, out)
+			if aceita == c.recusa || (len(f.rejeitos) > 0) != c.recusa {
+				t.Errorf("linha aceita = %v, rejeitos %v; queria recusa=%v", aceita, f.rejeitos, c.recusa)
+			}
+		})
+	}
+}
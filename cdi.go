@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// urlCDI é a série 12 do SGS do Banco Central: CDI diário, em % ao dia
+const urlCDI = "https://api.bcb.gov.br/dados/serie/bcdata.sgs.12/dados?formato=json&dataInicial=01/01/%d&dataFinal=31/12/%d"
+
+// dirCDI guarda uma cópia por ano da série (csvs/cdi/cdi_2024.csv); a cópia só
+// é baixada de novo quando não cobre o período pedido
+var dirCDI = "csvs/cdi"
+
+// atualizarCDI baixa um ano da série; variável para os testes não dependerem do SGS
+var atualizarCDI = baixarCDI
+
+// serieCDI é o CDI acumulado por dia útil: acumulado[i] é o fator de
+// capitalização do início da série até datas[i], inclusive
+type serieCDI struct {
+	datas     []time.Time
+	acumulado []float64
+}
+
+// carregarCDI lê (e baixa, se preciso) o CDI diário dos anos de desde até ate
+func carregarCDI(desde, ate time.Time) (serieCDI, error) {
+	taxas := map[string]float64{}
+	for ano := desde.Year(); ano <= ate.Year(); ano++ {
+		arquivo := filepath.Join(dirCDI, fmt.Sprintf("cdi_%d.csv", ano))
+		necessario := time.Date(ano, time.December, 31, 0, 0, 0, 0, time.UTC)
+		if ate.Before(necessario) {
+			necessario = ate
+		}
+		ultima, temCopia := ultimaDataCDI(arquivo)
+		if !temCopia || ultima.Before(necessario) {
+			if err := atualizarCDI(ano, arquivo); err != nil {
+				if !temCopia {
+					return serieCDI{}, fmt.Errorf("erro ao baixar o CDI de %d: %w", ano, err)
+				}
+				// o SGS fora do ar não invalida a cópia: as métricas saem com o que já temos
+				fmt.Printf("Aviso: erro ao atualizar o CDI de %d (%v); usando a cópia até %s\n", ano, err, ultima.Format(layoutData))
+			}
+		}
+		if err := lerCDI(arquivo, taxas); err != nil {
+			return serieCDI{}, err
+		}
+	}
+
+	var s serieCDI
+	for d := range taxas {
+		if t, err := time.Parse(layoutData, d); err == nil {
+			s.datas = append(s.datas, t)
+		}
+	}
+	sort.Slice(s.datas, func(i, j int) bool { return s.datas[i].Before(s.datas[j]) })
+	fator := 1.0
+	for _, d := range s.datas {
+		fator *= 1 + taxas[d.Format(layoutData)]/100
+		s.acumulado = append(s.acumulado, fator)
+	}
+	return s, nil
+}
+
+// baixarCDI baixa um ano da série do SGS e grava como CSV (data,valor)
+func baixarCDI(ano int, arquivo string) error {
+	buf := newSpoolBuffer()
+	defer buf.Close()
+	if _, err := downloadFile(fmt.Sprintf(urlCDI, ano, ano), buf, nil); err != nil {
+		return err
+	}
+
+	var serie []struct {
+		Data  string `json:"data"` // DD/MM/AAAA
+		Valor string `json:"valor"`
+	}
+	r, n := buf.ReaderAt()
+	if err := json.NewDecoder(io.NewSectionReader(r, 0, n)).Decode(&serie); err != nil {
+		return fmt.Errorf("resposta inválida do SGS: %w", err)
+	}
+
+	return writeAtomic(arquivo, 0644, func(w io.Writer) error {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"data", "valor"})
+		for _, p := range serie {
+			t, err := time.Parse(layoutDataBR, p.Data)
+			if err != nil {
+				return fmt.Errorf("data inválida no SGS: %q", p.Data)
+			}
+			cw.Write([]string{t.Format(layoutData), p.Valor})
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+}
+
+// ultimaDataCDI devolve a última data da cópia local; ok é false sem cópia legível
+func ultimaDataCDI(arquivo string) (time.Time, bool) {
+	taxas := map[string]float64{}
+	if err := lerCDI(arquivo, taxas); err != nil {
+		return time.Time{}, false
+	}
+	var ultima time.Time
+	for d := range taxas {
+		if t, err := time.Parse(layoutData, d); err == nil && t.After(ultima) {
+			ultima = t
+		}
+	}
+	return ultima, true
+}
+
+func lerCDI(arquivo string, cdi map[string]float64) error {
+	f, err := os.Open(arquivo)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	registros, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return fmt.Errorf("erro ao ler %s: %w", arquivo, err)
+	}
+	for _, r := range registros[min(1, len(registros)):] {
+		if len(r) < 2 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(r[1], 64); err == nil {
+			cdi[r[0]] = v
+		}
+	}
+	return nil
+}
+
+// fatorAte devolve o acumulado até a data (inclusive); 1 antes do início da série
+func (s serieCDI) fatorAte(t time.Time) float64 {
+	i := sort.Search(len(s.datas), func(i int) bool { return s.datas[i].After(t) })
+	if i == 0 {
+		return 1
+	}
+	return s.acumulado[i-1]
+}
+
+// acumuladoEntre devolve o CDI acumulado de (desde, ate]; ok é false se a série
+// não cobre o intervalo
+func (s serieCDI) acumuladoEntre(desde, ate time.Time) (float64, bool) {
+	if len(s.datas) == 0 || s.datas[0].After(desde.AddDate(0, 0, toleranciaBase)) ||
+		s.datas[len(s.datas)-1].Before(ate.AddDate(0, 0, -toleranciaBase)) {
+		return 0, false
+	}
+	return s.fatorAte(ate)/s.fatorAte(desde) - 1, true
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCarregarCDICopiaLocal(t *testing.T) {
+	casos := []struct {
+		nome    string
+		copia   string // última data da cópia de 2024; vazio sem cópia
+		ate     string
+		sgsFora bool
+		baixa   bool
+		ultima  string // última data da série carregada; vazio quando falha
+	}{
+		{"cópia cobre o período", "2024-06-28", "2024-06-28", false, false, "2024-06-28"},
+		{"cópia cobre o ano", "2024-12-31", "2024-12-31", false, false, "2024-12-31"},
+		{"cópia atrasada", "2024-05-31", "2024-06-28", false, true, "2024-06-28"},
+		{"cópia atrasada e SGS fora do ar", "2024-05-31", "2024-06-28", true, true, "2024-05-31"},
+		{"sem cópia", "", "2024-06-28", false, true, "2024-06-28"},
+		{"sem cópia e SGS fora do ar", "", "2024-06-28", true, true, ""},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			dir := t.TempDir()
+			antigoDir, antigoAtualizar := dirCDI, atualizarCDI
+			defer func() { dirCDI, atualizarCDI = antigoDir, antigoAtualizar }()
+			dirCDI = dir
+
+			escrever := func(arquivo string, ate string) {
+				linhas := []string{"data,valor"}
+				for _, d := range diasUteisTeste(t, "2024-01-02", ate) {
+					linhas = append(linhas, fmt.Sprintf("%s,0.04", d.Format(layoutData)))
+				}
+				if err := os.WriteFile(arquivo, []byte(strings.Join(linhas, "\n")+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if c.copia != "" {
+				escrever(filepath.Join(dir, "cdi_2024.csv"), c.copia)
+			}
+			baixou := false
+			atualizarCDI = func(ano int, arquivo string) error {
+				baixou = true
+				if c.sgsFora {
+					return errors.New("SGS fora do ar")
+				}
+				escrever(arquivo, c.ate)
+				return nil
+			}
+
+			s, err := carregarCDI(dataTeste(t, "2024-01-31"), dataTeste(t, c.ate))
+			if baixou != c.baixa {
+				t.Errorf("baixou = %v, queria %v", baixou, c.baixa)
+			}
+			if c.ultima == "" {
+				if err == nil {
+					t.Fatalf("carregarCDI sem cópia e com o SGS fora do ar deveria falhar")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.datas[len(s.datas)-1].Format(layoutData); got != c.ultima {
+				t.Errorf("última data = %s, queria %s", got, c.ultima)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// cnpj é um CNPJ validado, guardado sem máscara: 12 caracteres de raiz e ordem
+// (dígitos ou, a partir de 2026, letras maiúsculas) e 2 dígitos verificadores
+type cnpj string
+
+// parseCNPJ aceita o CNPJ com ou sem máscara. CNPJs só numéricos com menos de
+// 14 dígitos (zeros à esquerda perdidos em planilhas) são completados com zeros.
+func parseCNPJ(s string) (cnpj, error) {
+	limpo := strings.ToUpper(strings.NewReplacer(".", "", "/", "", "-", "", " ", "").Replace(strings.TrimSpace(s)))
+	if limpo == "" {
+		return "", fmt.Errorf("CNPJ vazio")
+	}
+
+	numerico := true
+	for _, r := range limpo {
+		switch {
+		case r >= '0' && r <= '9':
+		case r >= 'A' && r <= 'Z':
+			numerico = false
+		default:
+			return "", fmt.Errorf("CNPJ %q com caractere inválido %q", s, r)
+		}
+	}
+	if numerico && len(limpo) < 14 {
+		limpo = strings.Repeat("0", 14-len(limpo)) + limpo
+	}
+	if len(limpo) != 14 {
+		return "", fmt.Errorf("CNPJ %q com %d caracteres", s, len(limpo))
+	}
+	if limpo[12] < '0' || limpo[12] > '9' || limpo[13] < '0' || limpo[13] > '9' {
+		return "", fmt.Errorf("CNPJ %q com dígito verificador não numérico", s)
+	}
+	if strings.Count(limpo, limpo[:1]) == 14 {
+		return "", fmt.Errorf("CNPJ %q com todos os caracteres iguais", s)
+	}
+
+	dv1 := digitoVerificadorCNPJ(limpo[:12])
+	dv2 := digitoVerificadorCNPJ(limpo[:12] + string(rune('0'+dv1)))
+	if int(limpo[12]-'0') != dv1 || int(limpo[13]-'0') != dv2 {
+		return "", fmt.Errorf("CNPJ %q com dígito verificador inválido", s)
+	}
+	return cnpj(limpo), nil
+}
+
+// digitoVerificadorCNPJ calcula o módulo 11 com pesos 2..9 da direita para a
+// esquerda. Cada caractere vale seu código ASCII menos 48, o que mantém os
+// dígitos como estão e dá A=17 ... Z=42 no CNPJ alfanumérico.
+func digitoVerificadorCNPJ(base string) int {
+	soma, peso := 0, 2
+	for i := len(base) - 1; i >= 0; i-- {
+		soma += int(base[i]-'0') * peso
+		if peso++; peso > 9 {
+			peso = 2
+		}
+	}
+	if resto := soma % 11; resto >= 2 {
+		return 11 - resto
+	}
+	return 0
+}
+
+// formatado devolve o CNPJ com máscara: 00.000.000/0000-00
+func (c cnpj) formatado() string {
+	s := string(c)
+	return s[0:2] + "." + s[2:5] + "." + s[5:8] + "/" + s[8:12] + "-" + s[12:14]
+}
+
+// semMascara devolve os 14 caracteres, sem pontuação
+func (c cnpj) semMascara() string {
+	return string(c)
+}
+
+func (c cnpj) String() string {
+	return c.formatado()
+}
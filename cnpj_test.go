@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseCNPJ(t *testing.T) {
+	casos := []struct {
+		nome      string
+		entrada   string
+		formatado string // vazio quando deve falhar
+		erro      string
+	}{
+		{"com máscara", "11.222.333/0001-81", "11.222.333/0001-81", ""},
+		{"sem máscara", "11222333000181", "11.222.333/0001-81", ""},
+		{"espaços", " 11 222 333 0001 81 ", "11.222.333/0001-81", ""},
+		{"zeros à esquerda perdidos", "191", "00.000.000/0001-91", ""},
+		{"alfanumérico", "12.ABC.345/01DE-35", "12.ABC.345/01DE-35", ""},
+		{"alfanumérico minúsculo", "12abc34501de35", "12.ABC.345/01DE-35", ""},
+		{"dígito errado", "11.222.333/0001-82", "", "dígito verificador inválido"},
+		{"alfanumérico com dígito errado", "12ABC34501DE36", "", "dígito verificador inválido"},
+		{"dígito verificador letra", "12ABC34501DEA5", "", "não numérico"},
+		{"todos iguais", "11111111111111", "", "todos os caracteres iguais"},
+		{"alfanumérico curto", "ABC123", "", "6 caracteres"},
+		{"longo", "112223330001811", "", "15 caracteres"},
+		{"caractere inválido", "11.222.333/0001_81", "", "caractere inválido"},
+		{"vazio", "  ", "", "vazio"},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			n, err := parseCNPJ(c.entrada)
+			if c.erro != "" {
+				if err == nil || !strings.Contains(err.Error(), c.erro) {
+					t.Fatalf("parseCNPJ(%q) = %v, %v; queria erro com %q", c.entrada, n, err, c.erro)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCNPJ(%q): %v", c.entrada, err)
+			}
+			if got := n.formatado(); got != c.formatado {
+				t.Errorf("parseCNPJ(%q).formatado() = %q, queria %q", c.entrada, got, c.formatado)
+			}
+			if got := n.semMascara(); got != strings.NewReplacer(".", "", "/", "", "-", "").Replace(c.formatado) {
+				t.Errorf("parseCNPJ(%q).semMascara() = %q", c.entrada, got)
+			}
+		})
+	}
+}
+
+func TestDigitoVerificadorCNPJ(t *testing.T) {
+	casos := []struct {
+		base string
+		dv   int
+	}{
+		{"112223330001", 8},
+		{"1122233300018", 1},
+		{"12ABC34501DE", 3},
+		{"12ABC34501DE3", 5},
+		{"000000000001", 9},
+	}
+	for _, c := range casos {
+		if got := digitoVerificadorCNPJ(c.base); got != c.dv {
+			t.Errorf("digitoVerificadorCNPJ(%q) = %d, queria %d", c.base, got, c.dv)
+		}
+	}
+}
+
+func TestColunaSchemaAplicarCNPJ(t *testing.T) {
+	casos := []struct {
+		nome    string
+		coluna  colunaSchema
+		valor   string
+		saida   string
+		aviso   bool
+		rejeita bool
+	}{
+		{"chave válida", colunaSchema{Nome: "CNPJ_FUNDO_CLASSE", Tipo: "cnpj", Chave: true}, "11222333000181", "11.222.333/0001-81", false, false},
+		{"chave inválida recusa", colunaSchema{Nome: "CNPJ_FUNDO_CLASSE", Tipo: "cnpj", Chave: true}, "11222333000182", "", false, true},
+		{"chave vazia obrigatória recusa", colunaSchema{Nome: "CNPJ_FUNDO_CLASSE", Tipo: "cnpj", Chave: true}, "", "", false, true},
+		{"secundária válida", colunaSchema{Nome: "CNPJ_ADMIN", Tipo: "cnpj", Nulo: true}, "11222333000181", "11.222.333/0001-81", false, false},
+		{"secundária inválida anula", colunaSchema{Nome: "CNPJ_ADMIN", Tipo: "cnpj", Nulo: true}, "11222333000182", "", true, false},
+		{"secundária vazia", colunaSchema{Nome: "CNPJ_AUDITOR", Tipo: "cnpj", Nulo: true}, "", "", false, false},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			v, aviso, err := c.coluna.aplicar(c.valor)
+			if c.rejeita {
+				var rejeicao rejeicaoLinha
+				if err == nil || !errors.As(err, &rejeicao) {
+					t.Fatalf("aplicar(%q) = %q, %v; queria rejeicaoLinha", c.valor, v, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("aplicar(%q): %v", c.valor, err)
+			}
+			if v != c.saida || (aviso != "") != c.aviso {
+				t.Errorf("aplicar(%q) = %q, aviso %q; queria %q, aviso %v", c.valor, v, aviso, c.saida, c.aviso)
+			}
+		})
+	}
+}
+
+func TestNormalizarValorCNPJ(t *testing.T) {
+	casos := []struct {
+		nome      string
+		coluna    string
+		valor     string
+		cnpjChave bool
+		saida     interface{}
+		aviso     bool
+		erro      bool
+	}{
+		{"chave válida", "cnpj_fundo_classe", "11222333000181", true, "11.222.333/0001-81", false, false},
+		{"chave inválida", "cnpj_fundo_classe", "11222333000182", true, nil, false, true},
+		{"secundária inválida vira NULL", "cnpj_custodiante", "11222333000182", false, nil, true, false},
+		{"secundária nula", "cnpj_controlador", "N/A", false, nil, false, false},
+		{"não é cnpj", "denom_social", " FUNDO X ", false, "FUNDO X", false, false},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			v, aviso, err := normalizarValor(c.coluna, c.valor, false, c.cnpjChave)
+			if (err != nil) != c.erro {
+				t.Fatalf("normalizarValor(%q, %q): erro %v, queria erro=%v", c.coluna, c.valor, err, c.erro)
+			}
+			if v != c.saida || (aviso != "") != c.aviso {
+				t.Errorf("normalizarValor(%q, %q) = %v, aviso %q; queria %v, aviso %v", c.coluna, c.valor, v, aviso, c.saida, c.aviso)
+			}
+		})
+	}
+}
@@ -1,1148 +1,344 @@
 package main
 
 import (
-	"bufio"
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
-	"time"
-
-	"github.com/go-gota/gota/dataframe"
-	"github.com/go-gota/gota/series"
-	"golang.org/x/text/encoding/charmap"
-	"golang.org/x/text/transform"
 )
 
-// padroniza FIDC's
-func csvPadronizationFidc(tabs []string, anos, meses []int) error {
-	const maxGoroutines = 15
-	sem := make(chan struct{}, maxGoroutines)
-
-	for _, tab := range tabs {
-		for _, ano := range anos {
-			// dfCh := make(chan dataframe.DataFrame)
-			var wg sync.WaitGroup
-
-			for _, mes := range meses {
-				arquivo := fmt.Sprintf("csvs/fidc/inf_mensal_fidc_tab%s%d%02d.csv", tab, ano, mes)
-				if _, err := os.Stat(arquivo); err != nil {
-					continue
-				}
-
-				sem <- struct{}{}
-				wg.Add(1)
-				go func(arquivo string) {
-					defer wg.Done()
-					defer func() { <-sem }()
-
-					f, err := os.Open(arquivo)
-					if err != nil {
-						fmt.Printf("Erro ao abrir arquivo %s: %v\n", arquivo, err)
-						return
-					}
-					defer f.Close()
-
-					reader := transform.NewReader(f, charmap.ISO8859_1.NewDecoder())
-					scanner := bufio.NewScanner(reader)
-
-					var records [][]string
-					var header []string
-					lineNum := 0
-
-					for scanner.Scan() {
-						lineNum++
-						line := scanner.Text()
-
-						r := csv.NewReader(strings.NewReader(line))
-						r.Comma = ';'
-						r.LazyQuotes = true
-
-						row, err := r.Read()
-						if err != nil {
-							fmt.Printf("Erro ao ler linha %d em %s: %v\n", lineNum, arquivo, err)
-							if header != nil {
-								fixedRow := fixCsvLine(arquivo, lineNum, len(header))
-								if fixedRow != nil {
-									records = append(records, fixedRow)
-									continue
-								}
-							}
-							fmt.Printf("Pulando linha %d irrecuperável\n", lineNum)
-							continue
-						}
-
-						if header == nil {
-							header = row
-							records = append(records, row)
-							continue
-						}
-
-						if len(row) != len(header) {
-							fmt.Printf("Linha %d tem %d campos, header tem %d campos\n", lineNum, len(row), len(header))
-							fixedRow := fixCsvLine(arquivo, lineNum, len(header))
-							if fixedRow != nil {
-								records = append(records, fixedRow)
-								continue
-							}
-							diff := len(row) - len(header)
-							if diff > 0 {
-								for i, val := range row {
-									if _, err := fmt.Sscanf(val, "%f", new(float64)); err != nil {
-										mergedVal := strings.Join(row[i:i+diff+1], ";")
-										newRow := append(row[:i], mergedVal)
-										if i+diff+1 < len(row) {
-											newRow = append(newRow, row[i+diff+1:]...)
-										}
-										row = newRow
-										break
-									}
-								}
-							}
-						}
+// tarefaPadronizacao é um arquivo de origem e onde gravar sua versão padronizada
+type tarefaPadronizacao struct {
+	entrada    string
+	saida      string
+	schema     schemaDataset
+	aparaAspas bool
+	origem     origemArquivo // download de onde veio a entrada, para a linhagem da saída
+}
 
-						if len(row) != len(header) {
-							fmt.Printf("Ignorando linha irrecuperável %d em %s: %v\n", lineNum, arquivo, row)
-							continue
-						}
+// errArquivoVazio interrompe a gravação quando a origem não tem nem cabeçalho
+var errArquivoVazio = errors.New("arquivo sem cabeçalho")
+
+// padronizarArquivo lê a entrada com o cvmCsvReader, aplica o schema do dataset e
+// grava a saída linha a linha (memória constante, independente do tamanho do
+// arquivo); linhas reparadas ou rejeitadas e as colunas de origem fora do schema
+// vão para o sidecar de quarentena
+func padronizarArquivo(t tarefaPadronizacao) (resumoPadronizacao, error) {
+	resumo := resumoPadronizacao{entrada: t.entrada, saida: t.saida}
+
+	quarentena := caminhoQuarentena(t.saida)
+	reader := newCvmCsvReader(t.entrada)
+	reader.aparaAspas = t.aparaAspas
+	reader.quarentena = novoSidecarQuarentena(quarentena)
+
+	err := writeAtomic(t.saida, 0644, func(w io.Writer) error {
+		cw := csv.NewWriter(w)
+		var transformador *transformadorColunas
+		reader.aoLerCabecalho = func(header []string) error {
+			var err error
+			transformador, err = t.schema.paraArquivo(t.entrada).preparar(header)
+			if err != nil {
+				return err
+			}
+			if len(transformador.extras) > 0 {
+				reader.avisar(1, strings.Join(header, ";"), "colunas_fora_do_schema",
+					"colunas mantidas no fim da saída: "+strings.Join(transformador.extras, ", "))
+			}
+			if len(transformador.duplicadas) > 0 {
+				reader.avisar(1, strings.Join(header, ";"), "colunas_duplicadas",
+					"colunas repetidas ignoradas: "+strings.Join(transformador.duplicadas, ", "))
+			}
+			return cw.Write(transformador.header)
+		}
 
-						records = append(records, row)
-					}
+		err := reader.forEach(func(row []string) error {
+			out, avisos, err := transformador.linha(row)
+			if err != nil {
+				return err
+			}
+			if err := cw.Write(out); err != nil {
+				return err
+			}
+			if len(avisos) > 0 {
+				return avisoLinha{avisoCNPJInvalido, strings.Join(avisos, "; ")}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if transformador == nil {
+			return errArquivoVazio
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+	resumo.linhas, resumo.reparadas, resumo.rejeitadas = reader.linhas, reader.reparadas, reader.rejeitadas
+	resumo.avisos = reader.avisos
+
+	if errQ := reader.quarentena.fechar(); errQ != nil {
+		return resumo, fmt.Errorf("erro ao gravar quarentena %s: %w", quarentena, errQ)
+	}
+	if reader.quarentena.linhas > 0 {
+		resumo.quarentena = quarentena
+	}
 
-					if err := scanner.Err(); err != nil {
-						fmt.Printf("Erro ao escanear arquivo %s: %v\n", arquivo, err)
-					}
+	if errors.Is(err, errArquivoVazio) {
+		return resumo, nil
+	}
+	if err != nil {
+		return resumo, fmt.Errorf("erro ao padronizar %s em %s: %w", t.entrada, t.saida, err)
+	}
+	origem := t.origem
+	origem.Arquivo = t.entrada
+	if err := gravarLinhagem(t.saida, linhagemArquivo{Origens: []origemArquivo{origem}, Rejeitadas: reader.rejeitadas}); err != nil {
+		return resumo, fmt.Errorf("erro ao gravar a linhagem de %s: %w", t.saida, err)
+	}
+	fmt.Printf("Arquivo %s gerado com sucesso!\n", t.saida)
+	return resumo, nil
+}
 
-					if len(records) > 0 {
-						df := dataframe.LoadRecords(records)
+// padronizarEmParalelo executa as tarefas com no máximo maxGoroutines simultâneas
+func padronizarEmParalelo(tarefas []tarefaPadronizacao, maxGoroutines int) (relatorioPadronizacao, error) {
+	// o manifesto diz de qual download veio cada entrada (linhagem da saída)
+	manifest, err := loadManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tarefas {
+		if e, ok := manifest.origem(tarefas[i].entrada); ok {
+			tarefas[i].origem = origemArquivo{URL: e.URL, SHA256: e.SHA256}
+		}
+	}
 
-						// colMap := map[string]string{
-						// 	"CNPJ_FUNDO": "CNPJ_FUNDO_CLASSE",
-						// }
-						// for _, colName := range df.Names() {
-						// 	if newName, ok := colMap[colName]; ok && newName != colName {
-						// 		df = df.Rename(newName, colName)
-						// 	}
-						// }
+	sem := make(chan struct{}, maxGoroutines)
+	var erros errosConcorrentes
+	var wg sync.WaitGroup
 
-						mapColNameValue := map[string]string{}
-						hasIdSubClasse, hasTpFundoClasse := false, false
+	relatorio := make(relatorioPadronizacao, len(tarefas))
+	for i, t := range tarefas {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, t tarefaPadronizacao) {
+			defer wg.Done()
+			defer func() { <-sem }()
 
-						colMap := map[string]string{
-							"TP_FUNDO":   "TP_FUNDO_CLASSE",
-							"CNPJ_FUNDO": "CNPJ_FUNDO_CLASSE",
-						}
+			resumo, err := padronizarArquivo(t)
+			relatorio[i] = resumo
+			if err != nil {
+				erros.add(err)
+			}
+		}(i, t)
+	}
 
-						for _, colName := range df.Names() {
-							if colName == "ID_SUBCLASSE" {
-								hasIdSubClasse = true
-							}
-							if colName == "TP_FUNDO_CLASSE" {
-								hasTpFundoClasse = true
-							}
-							if newName, ok := colMap[colName]; ok && newName != colName {
-								df = df.Rename(newName, colName)
-							}
-						}
+	wg.Wait()
+	relatorio.imprimir()
+	return relatorio, erros.err()
+}
 
-						if !hasIdSubClasse {
-							mapColNameValue["ID_SUBCLASSE"] = ""
-						}
-						if !hasTpFundoClasse {
-							mapColNameValue["TP_FUNDO_CLASSE"] = "Não informado"
-						}
+// existe diz se o arquivo de origem foi baixado
+func existe(arquivo string) bool {
+	_, err := os.Stat(arquivo)
+	return err == nil
+}
 
-						for colName, colValue := range mapColNameValue {
-							vals := make([]string, df.Nrow())
-							for i := range vals {
-								vals[i] = colValue
-							}
-							newCol := series.New(vals, series.String, colName)
-							df = df.Mutate(newCol)
-						}
+// padroniza FIDC's
+func csvPadronizationFidc(tabs []string, anos, meses []int) (relatorioPadronizacao, error) {
+	schema, err := carregarSchema("fidc")
+	if err != nil {
+		return nil, err
+	}
 
-						os.MkdirAll("csvs/fidc_padronized", os.ModePerm)
-						outFileName := fmt.Sprintf("csvs/fidc_padronized/inf_mensal_fidc_tab%s%d%02d.csv", tab, ano, mes)
-						outFile, err := os.Create(outFileName)
-						if err != nil {
-							fmt.Printf("Erro ao criar arquivo %s: %v\n", outFileName, err)
-						}
-						if err := df.WriteCSV(outFile); err != nil {
-							fmt.Printf("Erro ao escrever CSV em %s: %v\n", outFileName, err)
-						}
-						outFile.Close()
-						fmt.Printf("Arquivo %s gerado com sucesso!\n", outFileName)
-					}
-				}(arquivo)
+	var tarefas []tarefaPadronizacao
+	for _, tab := range tabs {
+		for _, ano := range anos {
+			for _, mes := range meses {
+				arquivo := fmt.Sprintf("csvs/fidc/inf_mensal_fidc_tab%s%d%02d.csv", tab, ano, mes)
+				if !existe(arquivo) {
+					continue
+				}
+				tarefas = append(tarefas, tarefaPadronizacao{
+					entrada: arquivo,
+					saida:   fmt.Sprintf("csvs/fidc_padronized/inf_mensal_fidc_tab%s%d%02d.csv", tab, ano, mes),
+					schema:  schema,
+				})
 			}
-			wg.Wait()
 		}
 	}
 
-	return nil
+	return padronizarEmParalelo(tarefas, 15)
 }
 
 // padroniza FIP's
-func csvPadronizationFip(tabs []string, anos []int) error {
-	const maxGoroutines = 15
-	sem := make(chan struct{}, maxGoroutines)
+func csvPadronizationFip(tabs []string, anos []int) (relatorioPadronizacao, error) {
+	schema, err := carregarSchema("fip")
+	if err != nil {
+		return nil, err
+	}
 
+	var tarefas []tarefaPadronizacao
 	for _, tab := range tabs {
 		for _, ano := range anos {
-			// dfCh := make(chan dataframe.DataFrame)
-			var wg sync.WaitGroup
-
 			arquivo := fmt.Sprintf("csvs/%s/inf_tri_quadri_%s_%d.csv", tab, tab, ano)
-			if _, err := os.Stat(arquivo); err != nil {
+			if !existe(arquivo) {
 				continue
 			}
-
-			sem <- struct{}{}
-			wg.Add(1)
-			go func(arquivo string) {
-				defer wg.Done()
-				defer func() { <-sem }()
-
-				f, err := os.Open(arquivo)
-				if err != nil {
-					fmt.Printf("Erro ao abrir arquivo %s: %v\n", arquivo, err)
-					return
-				}
-				defer f.Close()
-
-				reader := transform.NewReader(f, charmap.ISO8859_1.NewDecoder())
-				scanner := bufio.NewScanner(reader)
-
-				var records [][]string
-				var header []string
-				lineNum := 0
-
-				for scanner.Scan() {
-					lineNum++
-					line := scanner.Text()
-
-					r := csv.NewReader(strings.NewReader(line))
-					r.Comma = ';'
-					r.LazyQuotes = true
-
-					row, err := r.Read()
-					if err != nil {
-						fmt.Printf("Erro ao ler linha %d em %s: %v\n", lineNum, arquivo, err)
-						if header != nil {
-							fixedRow := fixCsvLine(arquivo, lineNum, len(header))
-							if fixedRow != nil {
-								records = append(records, fixedRow)
-								continue
-							}
-						}
-						fmt.Printf("Pulando linha %d irrecuperável\n", lineNum)
-						continue
-					}
-
-					if header == nil {
-						header = row
-						records = append(records, row)
-						continue
-					}
-
-					if len(row) != len(header) {
-						fmt.Printf("Linha %d tem %d campos, header tem %d campos\n", lineNum, len(row), len(header))
-						fixedRow := fixCsvLine(arquivo, lineNum, len(header))
-						if fixedRow != nil {
-							records = append(records, fixedRow)
-							continue
-						}
-						diff := len(row) - len(header)
-						if diff > 0 {
-							for i, val := range row {
-								if _, err := fmt.Sscanf(val, "%f", new(float64)); err != nil {
-									mergedVal := strings.Join(row[i:i+diff+1], ";")
-									newRow := append(row[:i], mergedVal)
-									if i+diff+1 < len(row) {
-										newRow = append(newRow, row[i+diff+1:]...)
-									}
-									row = newRow
-									break
-								}
-							}
-						}
-					}
-
-					if len(row) != len(header) {
-						fmt.Printf("Ignorando linha irrecuperável %d em %s: %v\n", lineNum, arquivo, row)
-						continue
-					}
-
-					records = append(records, row)
-				}
-
-				if err := scanner.Err(); err != nil {
-					fmt.Printf("Erro ao escanear arquivo %s: %v\n", arquivo, err)
-				}
-
-				if len(records) > 0 {
-					df := dataframe.LoadRecords(records)
-
-					// colMap := map[string]string{
-					// 	"CNPJ_FUNDO": "CNPJ_FUNDO_CLASSE",
-					// }
-					// for _, colName := range df.Names() {
-					// 	if newName, ok := colMap[colName]; ok && newName != colName {
-					// 		df = df.Rename(newName, colName)
-					// 	}
-					// }
-
-					mapColNameValue := map[string]string{}
-					hasTpFundoClasse := false
-
-					colMap := map[string]string{
-						"CNPJ_FUNDO": "CNPJ_FUNDO_CLASSE",
-					}
-
-					for _, colName := range df.Names() {
-						if colName == "TP_FUNDO_CLASSE" {
-							hasTpFundoClasse = true
-						}
-						if newName, ok := colMap[colName]; ok && newName != colName {
-							df = df.Rename(newName, colName)
-						}
-					}
-
-					if !hasTpFundoClasse {
-						mapColNameValue["TP_FUNDO_CLASSE"] = "FIP"
-					}
-
-					for colName, colValue := range mapColNameValue {
-						vals := make([]string, df.Nrow())
-						for i := range vals {
-							vals[i] = colValue
-						}
-						newCol := series.New(vals, series.String, colName)
-						df = df.Mutate(newCol)
-					}
-
-					os.MkdirAll(fmt.Sprintf("csvs/%s_padronized", tab), os.ModePerm)
-					outFileName := fmt.Sprintf("csvs/%s_padronized/inf_tri_quadri_%s_%d_.csv", tab, tab, ano)
-					outFile, err := os.Create(outFileName)
-					if err != nil {
-						fmt.Printf("Erro ao criar arquivo %s: %v\n", outFileName, err)
-					}
-					if err := df.WriteCSV(outFile); err != nil {
-						fmt.Printf("Erro ao escrever CSV em %s: %v\n", outFileName, err)
-					}
-					outFile.Close()
-					fmt.Printf("Arquivo %s gerado com sucesso!\n", outFileName)
-				}
-			}(arquivo)
-
-			wg.Wait()
+			tarefas = append(tarefas, tarefaPadronizacao{
+				entrada: arquivo,
+				saida:   fmt.Sprintf("csvs/%s_padronized/inf_tri_quadri_%s_%d_.csv", tab, tab, ano),
+				schema:  schema,
+			})
 		}
 	}
 
-	return nil
+	return padronizarEmParalelo(tarefas, 15)
 }
 
-// PADRAO PARA REPASSAR PARA TODAS AS OUTRAS!!!
-func csvPadronizationLamina(tabs []string, anos, meses []int) error {
-	const maxGoroutines = 15
-	sem := make(chan struct{}, maxGoroutines)
+// padroniza lâminas
+func csvPadronizationLamina(tabs []string, anos, meses []int) (relatorioPadronizacao, error) {
+	schema, err := carregarSchema("lamina")
+	if err != nil {
+		return nil, err
+	}
 
+	var tarefas []tarefaPadronizacao
 	for _, tab := range tabs {
 		for _, ano := range anos {
-			// dfCh := make(chan dataframe.DataFrame)
-			var wg sync.WaitGroup
-
 			for _, mes := range meses {
 				arquivo := fmt.Sprintf("csvs/lamina/lamina_fi%s%d%02d.csv", tab, ano, mes)
-				if _, err := os.Stat(arquivo); err != nil {
+				if !existe(arquivo) {
 					continue
 				}
-
-				sem <- struct{}{}
-				wg.Add(1)
-				go func(arquivo string) {
-					defer wg.Done()
-					defer func() { <-sem }()
-
-					f, err := os.Open(arquivo)
-					if err != nil {
-						fmt.Printf("Erro ao abrir arquivo %s: %v\n", arquivo, err)
-						return
-					}
-					defer f.Close()
-
-					reader := transform.NewReader(f, charmap.ISO8859_1.NewDecoder())
-					scanner := bufio.NewScanner(reader)
-
-					var records [][]string
-					var header []string
-					lineNum := 0
-
-					for scanner.Scan() {
-						lineNum++
-						line := scanner.Text()
-
-						r := csv.NewReader(strings.NewReader(line))
-						r.Comma = ';'
-						r.LazyQuotes = true
-
-						row, err := r.Read()
-						if err != nil {
-							fmt.Printf("Erro ao ler linha %d em %s: %v\n", lineNum, arquivo, err)
-							if header != nil {
-								fixedRow := fixCsvLine(arquivo, lineNum, len(header))
-								if fixedRow != nil {
-									records = append(records, fixedRow)
-									continue
-								}
-							}
-							fmt.Printf("Pulando linha %d irrecuperável\n", lineNum)
-							continue
-						}
-
-						if header == nil {
-							header = row
-							records = append(records, row)
-							continue
-						}
-
-						if len(row) != len(header) {
-							fmt.Printf("Linha %d tem %d campos, header tem %d campos\n", lineNum, len(row), len(header))
-							fixedRow := fixCsvLine(arquivo, lineNum, len(header))
-							if fixedRow != nil {
-								records = append(records, fixedRow)
-								continue
-							}
-							diff := len(row) - len(header)
-							if diff > 0 {
-								for i, val := range row {
-									if _, err := fmt.Sscanf(val, "%f", new(float64)); err != nil {
-										mergedVal := strings.Join(row[i:i+diff+1], ";")
-										newRow := append(row[:i], mergedVal)
-										if i+diff+1 < len(row) {
-											newRow = append(newRow, row[i+diff+1:]...)
-										}
-										row = newRow
-										break
-									}
-								}
-							}
-						}
-
-						if len(row) != len(header) {
-							fmt.Printf("Ignorando linha irrecuperável %d em %s: %v\n", lineNum, arquivo, row)
-							continue
-						}
-
-						records = append(records, row)
-					}
-
-					if err := scanner.Err(); err != nil {
-						fmt.Printf("Erro ao escanear arquivo %s: %v\n", arquivo, err)
-					}
-
-					if len(records) > 0 {
-						df := dataframe.LoadRecords(records)
-
-						// colMap := map[string]string{
-						// 	"CNPJ_FUNDO": "CNPJ_FUNDO_CLASSE",
-						// }
-						// for _, colName := range df.Names() {
-						// 	if newName, ok := colMap[colName]; ok && newName != colName {
-						// 		df = df.Rename(newName, colName)
-						// 	}
-						// }
-
-						mapColNameValue := map[string]string{}
-						hasIdSubClasse, hasTpFundoClasse := false, false
-
-						colMap := map[string]string{
-							"TP_FUNDO":   "TP_FUNDO_CLASSE",
-							"CNPJ_FUNDO": "CNPJ_FUNDO_CLASSE",
-						}
-
-						for _, colName := range df.Names() {
-							if colName == "ID_SUBCLASSE" {
-								hasIdSubClasse = true
-							}
-							if colName == "TP_FUNDO_CLASSE" {
-								hasTpFundoClasse = true
-							}
-							if newName, ok := colMap[colName]; ok && newName != colName {
-								df = df.Rename(newName, colName)
-							}
-						}
-
-						if !hasIdSubClasse {
-							mapColNameValue["ID_SUBCLASSE"] = ""
-						}
-						if !hasTpFundoClasse {
-							mapColNameValue["TP_FUNDO_CLASSE"] = "Não informado"
-						}
-
-						for colName, colValue := range mapColNameValue {
-							vals := make([]string, df.Nrow())
-							for i := range vals {
-								vals[i] = colValue
-							}
-							newCol := series.New(vals, series.String, colName)
-							df = df.Mutate(newCol)
-						}
-
-						os.MkdirAll("csvs/lamina_padronized", os.ModePerm)
-						outFileName := fmt.Sprintf("csvs/lamina_padronized/lamina_fi%s%d%02d.csv", tab, ano, mes)
-						outFile, err := os.Create(outFileName)
-						if err != nil {
-							fmt.Printf("Erro ao criar arquivo %s: %v\n", outFileName, err)
-						}
-						if err := df.WriteCSV(outFile); err != nil {
-							fmt.Printf("Erro ao escrever CSV em %s: %v\n", outFileName, err)
-						}
-						outFile.Close()
-						fmt.Printf("Arquivo %s gerado com sucesso!\n", outFileName)
-					}
-				}(arquivo)
+				tarefas = append(tarefas, tarefaPadronizacao{
+					entrada: arquivo,
+					saida:   fmt.Sprintf("csvs/lamina_padronized/lamina_fi%s%d%02d.csv", tab, ano, mes),
+					schema:  schema,
+				})
 			}
-			wg.Wait()
 		}
 	}
 
-	return nil
+	return padronizarEmParalelo(tarefas, 15)
 }
 
 // Cda é basicamente a "carteira" do fundo, mas dividida em MUITOS arquivos mensais (sinceramente, sei lá, mas blz)
-func csvPadronizationCda() error {
-	const maxGoroutines = 4
-	sem := make(chan struct{}, maxGoroutines)
-
+// Os blocos variam, então os arquivos são listados do diretório e filtrados pela
+// competência (AAAAMM) no fim do nome.
+func csvPadronizationCda(anos, meses []int) (relatorioPadronizacao, error) {
 	dir := "csvs/cda"
 	files, err := os.ReadDir(dir)
 	if err != nil {
-		return fmt.Errorf("erro ao ler diretório %s: %v", dir, err)
+		return nil, fmt.Errorf("erro ao ler diretório %s: %v", dir, err)
 	}
-	// verificar se var wg feita aqui não causa lentidão durante a procura por ela nunca resetar
-	// avaliar depois.
-	var wg sync.WaitGroup
+
+	// cada bloco tem seu layout (variante) no schema
+	schema, err := carregarSchema("cda")
+	if err != nil {
+		return nil, err
+	}
+
+	pedidas := map[string]bool{}
+	for _, ano := range anos {
+		for _, mes := range meses {
+			pedidas[fmt.Sprintf("%d%02d", ano, mes)] = true
+		}
+	}
+
+	var tarefas []tarefaPadronizacao
 	for _, file := range files {
 		if file.IsDir() || !strings.HasPrefix(file.Name(), "cda") {
 			continue
 		}
-		arquivo := dir + "/" + file.Name()
-		if _, err := os.Stat(arquivo); err != nil {
+		nome := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		if len(nome) < 6 || !pedidas[nome[len(nome)-6:]] {
 			continue
 		}
-		sem <- struct{}{}
-		wg.Add(1)
-		go func(arquivo string) {
-			defer wg.Done()
-			defer func() { <-sem }()
-
-			f, err := os.Open(arquivo)
-			if err != nil {
-				fmt.Printf("Erro ao abrir arquivo %s: %v\n", arquivo, err)
-				return
-			}
-			defer f.Close()
-
-			reader := transform.NewReader(f, charmap.ISO8859_1.NewDecoder())
-			scanner := bufio.NewScanner(reader)
-
-			var records [][]string
-			var header []string
-			lineNum := 0
-
-			for scanner.Scan() {
-				lineNum++
-				line := scanner.Text()
-
-				r := csv.NewReader(strings.NewReader(line))
-				r.Comma = ';'
-				r.LazyQuotes = true
-
-				row, err := r.Read()
-				if err != nil {
-					fmt.Printf("Erro ao ler linha %d em %s: %v\n", lineNum, arquivo, err)
-					if header != nil {
-						fixedRow := fixCsvLine(arquivo, lineNum, len(header))
-						if fixedRow != nil {
-							records = append(records, fixedRow)
-							continue
-						}
-					}
-					fmt.Printf("Pulando linha %d irrecuperável\n", lineNum)
-					continue
-				}
-
-				if header == nil {
-					header = row
-					records = append(records, row)
-					continue
-				}
-
-				if len(row) != len(header) {
-					fmt.Printf("Linha %d tem %d campos, header tem %d campos\n", lineNum, len(row), len(header))
-					fixedRow := fixCsvLine(arquivo, lineNum, len(header))
-					if fixedRow != nil {
-						records = append(records, fixedRow)
-						continue
-					}
-					diff := len(row) - len(header)
-					if diff > 0 {
-						for i, val := range row {
-							if _, err := fmt.Sscanf(val, "%f", new(float64)); err != nil {
-								mergedVal := strings.Join(row[i:i+diff+1], ";")
-								newRow := append(row[:i], mergedVal)
-								if i+diff+1 < len(row) {
-									newRow = append(newRow, row[i+diff+1:]...)
-								}
-								row = newRow
-								break
-							}
-						}
-					}
-				}
-
-				if len(row) != len(header) {
-					fmt.Printf("Ignorando linha irrecuperável %d em %s: %v\n", lineNum, arquivo, row)
-					continue
-				}
-
-				records = append(records, row)
-			}
-
-			if err := scanner.Err(); err != nil {
-				fmt.Printf("Erro ao escanear arquivo %s: %v\n", arquivo, err)
-			}
-
-			if len(records) > 0 {
-				df := dataframe.LoadRecords(records)
-
-				mapColNameValue := map[string]string{}
-				hasTpFundoClasse, hasIdSubClasse := false, false
-
-				colMap := map[string]string{
-					"TP_FUNDO":   "TP_FUNDO_CLASSE",
-					"CNPJ_FUNDO": "CNPJ_FUNDO_CLASSE",
-				}
-
-				if strings.HasPrefix(file.Name(), "cda_fi_BLC_2_") {
-					colMap["CNPJ_FUNDO_COTA"] = "CNPJ_FUNDO_CLASSE_COTA"
-					colMap["NM_FUNDO_COTA"] = "NM_FUNDO_CLASSE_SUBCLASSE_COTA"
-
-					for _, colName := range df.Names() {
-						if colName == "ID_SUBCLASSE" {
-							hasIdSubClasse = true
-						}
-						if newName, ok := colMap[colName]; ok && newName != colName {
-							df = df.Rename(newName, colName)
-						}
-					}
-
-					if !hasIdSubClasse {
-						mapColNameValue["ID_SUBCLASSE"] = "Não informado"
-					}
-
-				} else {
-					for _, colName := range df.Names() {
-						if colName == "TP_FUNDO_CLASSE" {
-							hasTpFundoClasse = true
-						}
-						if newName, ok := colMap[colName]; ok && newName != colName {
-							df = df.Rename(newName, colName)
-						}
-					}
-
-					if !hasTpFundoClasse {
-						mapColNameValue["TP_FUNDO_CLASSE"] = "Não informado"
-					}
-				}
-
-				for colName, colValue := range mapColNameValue {
-					vals := make([]string, df.Nrow())
-					for i := range vals {
-						vals[i] = colValue
-					}
-					newCol := series.New(vals, series.String, colName)
-					df = df.Mutate(newCol)
-				}
-
-				os.MkdirAll("csvs/cda_padronized", os.ModePerm)
-				outFileName := dir + "_padronized" + "/" + file.Name()
-				outFile, err := os.Create(outFileName)
-				if err != nil {
-					fmt.Printf("Erro ao criar arquivo %s: %v\n", outFileName, err)
-				}
-				if err := df.WriteCSV(outFile); err != nil {
-					fmt.Printf("Erro ao escrever CSV em %s: %v\n", outFileName, err)
-				}
-				outFile.Close()
-				fmt.Printf("Arquivo %s gerado com sucesso!\n", outFileName)
-			}
-		}(arquivo)
+		if !schema.temLayout(file.Name()) {
+			fmt.Printf("Arquivo %s/%s sem layout no schema do CDA, ignorado\n", dir, file.Name())
+			continue
+		}
+		tarefas = append(tarefas, tarefaPadronizacao{
+			entrada: dir + "/" + file.Name(),
+			saida:   dir + "_padronized" + "/" + file.Name(),
+			schema:  schema,
+		})
 	}
 
-	wg.Wait()
-	// Fechar o canal de semáforo
-	close(sem)
-
-	return nil
+	// arquivos do CDA são grandes, poucas goroutines
+	return padronizarEmParalelo(tarefas, 4)
 }
 
 // padroniza inf_diario
-func csvPadronizationInfDiario(anos, meses []int) error {
-	const maxGoroutines = 5
-	sem := make(chan struct{}, maxGoroutines)
+func csvPadronizationInfDiario(anos, meses []int) (relatorioPadronizacao, error) {
+	schema, err := carregarSchema("inf_diario")
+	if err != nil {
+		return nil, err
+	}
 
+	var tarefas []tarefaPadronizacao
 	for _, ano := range anos {
-		// dfCh := make(chan dataframe.DataFrame)
-		var wg sync.WaitGroup
-
 		for _, mes := range meses {
 			arquivo := fmt.Sprintf("csvs/inf_diario/inf_diario_fi_%d%02d.csv", ano, mes)
-			if _, err := os.Stat(arquivo); err != nil {
+			if !existe(arquivo) {
 				continue
 			}
-
-			sem <- struct{}{}
-			wg.Add(1)
-			go func(arquivo string) {
-				defer wg.Done()
-				defer func() { <-sem }()
-
-				f, err := os.Open(arquivo)
-				if err != nil {
-					fmt.Printf("Erro ao abrir arquivo %s: %v\n", arquivo, err)
-					return
-				}
-				defer f.Close()
-
-				reader := transform.NewReader(f, charmap.ISO8859_1.NewDecoder())
-				scanner := bufio.NewScanner(reader)
-
-				var records [][]string
-				var header []string
-				lineNum := 0
-
-				for scanner.Scan() {
-					lineNum++
-					line := scanner.Text()
-
-					r := csv.NewReader(strings.NewReader(line))
-					r.Comma = ';'
-					r.LazyQuotes = true
-
-					row, err := r.Read()
-					if err != nil {
-						fmt.Printf("Erro ao ler linha %d em %s: %v\n", lineNum, arquivo, err)
-						if header != nil {
-							fixedRow := fixCsvLine(arquivo, lineNum, len(header))
-							if fixedRow != nil {
-								records = append(records, fixedRow)
-								continue
-							}
-						}
-						fmt.Printf("Pulando linha %d irrecuperável\n", lineNum)
-						continue
-					}
-
-					if header == nil {
-						header = row
-						records = append(records, row)
-						continue
-					}
-
-					if len(row) != len(header) {
-						fmt.Printf("Linha %d tem %d campos, header tem %d campos\n", lineNum, len(row), len(header))
-						fixedRow := fixCsvLine(arquivo, lineNum, len(header))
-						if fixedRow != nil {
-							records = append(records, fixedRow)
-							continue
-						}
-						diff := len(row) - len(header)
-						if diff > 0 {
-							for i, val := range row {
-								if _, err := fmt.Sscanf(val, "%f", new(float64)); err != nil {
-									mergedVal := strings.Join(row[i:i+diff+1], ";")
-									newRow := append(row[:i], mergedVal)
-									if i+diff+1 < len(row) {
-										newRow = append(newRow, row[i+diff+1:]...)
-									}
-									row = newRow
-									break
-								}
-							}
-						}
-					}
-
-					if len(row) != len(header) {
-						fmt.Printf("Ignorando linha irrecuperável %d em %s: %v\n", lineNum, arquivo, row)
-						continue
-					}
-
-					records = append(records, row)
-				}
-
-				if err := scanner.Err(); err != nil {
-					fmt.Printf("Erro ao escanear arquivo %s: %v\n", arquivo, err)
-				}
-
-				if len(records) > 0 {
-					df := dataframe.LoadRecords(records)
-
-					// colMap := map[string]string{
-					// 	"CNPJ_FUNDO": "CNPJ_FUNDO_CLASSE",
-					// }
-					// for _, colName := range df.Names() {
-					// 	if newName, ok := colMap[colName]; ok && newName != colName {
-					// 		df = df.Rename(newName, colName)
-					// 	}
-					// }
-
-					mapColNameValue := map[string]string{}
-					hasIdSubClasse, hasTpFundoClasse := false, false
-
-					colMap := map[string]string{
-						"TP_FUNDO":   "TP_FUNDO_CLASSE",
-						"CNPJ_FUNDO": "CNPJ_FUNDO_CLASSE",
-					}
-
-					for _, colName := range df.Names() {
-						if colName == "ID_SUBCLASSE" {
-							hasIdSubClasse = true
-						}
-						if colName == "TP_FUNDO_CLASSE" {
-							hasTpFundoClasse = true
-						}
-						if newName, ok := colMap[colName]; ok && newName != colName {
-							df = df.Rename(newName, colName)
-						}
-					}
-
-					if !hasIdSubClasse {
-						mapColNameValue["ID_SUBCLASSE"] = ""
-					}
-					if !hasTpFundoClasse {
-						mapColNameValue["TP_FUNDO_CLASSE"] = "Não informado"
-					}
-
-					for colName, colValue := range mapColNameValue {
-						vals := make([]string, df.Nrow())
-						for i := range vals {
-							vals[i] = colValue
-						}
-						newCol := series.New(vals, series.String, colName)
-						df = df.Mutate(newCol)
-					}
-
-					os.MkdirAll("csvs/inf_diario_padronized", os.ModePerm)
-					outFileName := fmt.Sprintf("csvs/inf_diario_padronized/inf_diario_fi_%d%02d.csv", ano, mes)
-					outFile, err := os.Create(outFileName)
-					if err != nil {
-						fmt.Printf("Erro ao criar arquivo %s: %v\n", outFileName, err)
-					}
-					if err := df.WriteCSV(outFile); err != nil {
-						fmt.Printf("Erro ao escrever CSV em %s: %v\n", outFileName, err)
-					}
-					outFile.Close()
-					fmt.Printf("Arquivo %s gerado com sucesso!\n", outFileName)
-				}
-			}(arquivo)
+			tarefas = append(tarefas, tarefaPadronizacao{
+				entrada: arquivo,
+				saida:   fmt.Sprintf("csvs/inf_diario_padronized/inf_diario_fi_%d%02d.csv", ano, mes),
+				schema:  schema,
+			})
 		}
-		wg.Wait()
 	}
 
-	return nil
+	return padronizarEmParalelo(tarefas, 5)
 }
 
-// pega o ultimo dia do inf_diario padronizado
+// pickLastDayOfMonthInfDiario gera, por (CNPJ_FUNDO_CLASSE, ID_SUBCLASSE), a
+// linha do último dia útil de cada mês em csvs/inf_diario_ultimos_dias
 func pickLastDayOfMonthInfDiario(anos, meses []int) error {
-	const maxGoroutines = 5
-	sem := make(chan struct{}, maxGoroutines)
-
-	for _, ano := range anos {
-		var wg sync.WaitGroup
-
-		for _, mes := range meses {
-			arquivo := fmt.Sprintf("csvs/inf_diario_padronized/inf_diario_fi_%d%02d.csv", ano, mes)
-			if _, err := os.Stat(arquivo); err != nil {
-				continue
-			}
-
-			sem <- struct{}{}
-			wg.Add(1)
-			go func(arquivo string) {
-				defer wg.Done()
-				defer func() { <-sem }()
-
-				f, err := os.Open(arquivo)
-				if err != nil {
-					fmt.Printf("Erro ao abrir arquivo %s: %v\n", arquivo, err)
-					return
-				}
-				defer f.Close()
-
-				df := dataframe.ReadCSV(f)
-				f.Close()
-
-				dates := df.Col("DT_COMPTC").Records()
-				parsed := make([]time.Time, len(dates))
-				for i, d := range dates {
-					t, _ := time.Parse("2006-01-02", d)
-					parsed[i] = t
-				}
-
-				cnpjs := df.Col("CNPJ_FUNDO_CLASSE").Records()
-				lastRows := []int{}
-				lastSeen := map[string]time.Time{}
-				rowIdx := map[string]int{}
-
-				for i, cnpj := range cnpjs {
-					curDate := parsed[i]
-					if curDate.After(lastSeen[cnpj]) {
-						lastSeen[cnpj] = curDate
-						rowIdx[cnpj] = i
-					}
-				}
-				for _, idx := range rowIdx {
-					lastRows = append(lastRows, idx)
-				}
-				df = df.Subset(lastRows)
-				os.MkdirAll("csvs/inf_diario_ultimos_dias", os.ModePerm)
-				outFileName := fmt.Sprintf("csvs/inf_diario_ultimos_dias/inf_diario_fi_%d%02d.csv", ano, mes)
-				outFile, err := os.Create(outFileName)
-				if err != nil {
-					fmt.Println(err)
-				}
-				if err := df.WriteCSV(outFile); err != nil {
-					fmt.Println(err)
-				}
-				outFile.Close()
-				fmt.Printf("Arquivo %s gerado com sucesso!\n", outFileName)
-			}(arquivo)
-		}
-		wg.Wait()
-	}
-
-	return nil
+	return gerarSnapshots(snapshotUltimoDiaMes, anos, meses)
 }
 
 // tab := []string{"adm_fii"} cadOuDoc := "cad"
 // padroniza os CSV simples, que precisam apenas de padronização de colunas
 // e verificação de colunas
 // aux := _classe, _fundo, _subclasse// prefix = registro_
-func simpleCsvPadronization(tabs, auxs []string, cadOuDoc, prefix string) error {
+func simpleCsvPadronization(tabs, auxs []string, cadOuDoc, prefix string) (relatorioPadronizacao, error) {
+	schema, err := carregarSchema("cadastro")
+	if err != nil {
+		return nil, err
+	}
+
+	var tarefas []tarefaPadronizacao
 	for _, tab := range tabs {
 		for _, aux := range auxs {
-			var merged dataframe.DataFrame
-			first := true
-
-			dfCh := make(chan dataframe.DataFrame)
-			var wg sync.WaitGroup
-
-			arquivo := ""
-			if prefix != "" {
-				arquivo = fmt.Sprintf("csvs/%s/%s_%s.csv", tab, prefix, aux)
-				if _, err := os.Stat(arquivo); err != nil {
-					continue
-				}
-			} else {
-				arquivo = fmt.Sprintf("csvs/%s/%s_%s.csv", tab, cadOuDoc, tab)
-				if _, err := os.Stat(arquivo); err != nil {
-					continue
-				}
-			}
-
-			wg.Add(1)
-			go func(arquivo string) {
-				defer wg.Done()
-
-				f, err := os.Open(arquivo)
-				if err != nil {
-					fmt.Printf("Erro ao abrir arquivo %s: %v\n", arquivo, err)
-					return
-				}
-				defer f.Close()
-
-				reader := transform.NewReader(f, charmap.ISO8859_1.NewDecoder())
-				scanner := bufio.NewScanner(reader)
-
-				var records [][]string
-				var header []string
-				lineNum := 0
-
-				for scanner.Scan() {
-					lineNum++
-					line := scanner.Text()
-
-					// Processa a linha como CSV
-					r := csv.NewReader(strings.NewReader(line))
-					r.Comma = ';'
-					r.LazyQuotes = true
-
-					row, err := r.Read()
-					if err != nil {
-						fmt.Printf("Erro ao ler linha %d em %s: %v\n", lineNum, arquivo, err)
-
-						// Tenta reparar a linha usando a função tryFixCsvLine
-						if header != nil { // só tenta reparar se já temos o header
-							fixedRow := fixCsvLine(arquivo, lineNum, len(header))
-							if fixedRow != nil {
-								records = append(records, fixedRow)
-								continue
-							}
-						}
-						// Se não conseguiu reparar, pula esta linha
-						fmt.Printf("Pulando linha %d irrecuperável\n", lineNum)
-						continue
-					}
-
-					// Limpa aspas soltas
-					for i, val := range row {
-						row[i] = strings.TrimPrefix(val, `"`)
-						row[i] = strings.TrimSuffix(val, `"`)
-					}
-
-					if header == nil {
-						header = row
-						records = append(records, row)
-						continue
-					}
-
-					// Ajusta linhas com excesso de campos automaticamente
-					if len(row) != len(header) {
-						fmt.Printf("Linha %d tem %d campos, header tem %d campos\n", lineNum, len(row), len(header))
-
-						// Tenta reparar usando tryFixCsvLine primeiro
-						fixedRow := fixCsvLine(arquivo, lineNum, len(header))
-						if fixedRow != nil {
-							records = append(records, fixedRow)
-							continue
-						}
-
-						// Se tryFixCsvLine não funcionou, tenta o método automático
-						diff := len(row) - len(header)
-						if diff > 0 {
-							// Junta os campos extras no campo que provavelmente contém texto (como nome do fundo)
-							// Procuramos o primeiro campo que seja "não numérico" como candidato
-							for i, val := range row {
-								if _, err := fmt.Sscanf(val, "%f", new(float64)); err != nil {
-									// Found candidate for merge
-									mergedVal := strings.Join(row[i:i+diff+1], ";")
-									newRow := append(row[:i], mergedVal)
-									if i+diff+1 < len(row) {
-										newRow = append(newRow, row[i+diff+1:]...)
-									}
-									row = newRow
-									break
-								}
-							}
-						}
-					}
-
-					// Ignora linhas que ainda não batem
-					if len(row) != len(header) {
-						fmt.Printf("Ignorando linha irrecuperável %d em %s: %v\n", lineNum, arquivo, row)
-						continue
-					}
-
-					records = append(records, row)
-				}
-
-				if err := scanner.Err(); err != nil {
-					fmt.Printf("Erro ao escanear arquivo %s: %v\n", arquivo, err)
-				}
-
-				if len(records) > 0 {
-					df := dataframe.LoadRecords(records)
-
-					// Padroniza colunas ANTES do merge
-					colMap := map[string]string{
-						"CNPJ_FUNDO": "CNPJ_FUNDO_CLASSE",
-					}
-					for _, colName := range df.Names() {
-						if newName, ok := colMap[colName]; ok && newName != colName {
-							df = df.Rename(newName, colName)
-						}
-					}
-
-					colMap = map[string]string{
-						"TP_FUNDO": "TP_FUNDO_CLASSE",
-					}
-					for _, colName := range df.Names() {
-						if newName, ok := colMap[colName]; ok && newName != colName {
-							df = df.Rename(newName, colName)
-						}
-					}
-
-					dfCh <- df
-				}
-			}(arquivo)
-
-			// Fechar channel após todas as goroutines terminarem
-			go func() {
-				wg.Wait()
-				close(dfCh)
-			}()
-
-			// Coleta os dataframes e faz RBind
-			for df := range dfCh {
-				if first {
-					merged = df
-					first = false
-				} else {
-					merged = merged.RBind(df)
-				}
-			}
-
-			if merged.Nrow() == 0 {
-				fmt.Printf("Nenhum dado encontrado para o ano %s\n", tabs)
-				continue
-			}
-			os.MkdirAll(fmt.Sprintf("csvs/%s_padronized", tab), os.ModePerm)
-
+			arquivo := fmt.Sprintf("csvs/%s/%s_%s.csv", tab, cadOuDoc, tab)
 			outFileName := fmt.Sprintf("csvs/%s_padronized/%s_%s.csv", tab, cadOuDoc, tab)
 			if prefix != "" {
+				arquivo = fmt.Sprintf("csvs/%s/%s_%s.csv", tab, prefix, aux)
 				outFileName = fmt.Sprintf("csvs/%s_padronized/%s_%s.csv", tab, prefix, aux)
 			}
-
-			outFile, err := os.Create(outFileName)
-			if err != nil {
-				return err
-			}
-			if err := merged.WriteCSV(outFile); err != nil {
-				return err
+			if !existe(arquivo) {
+				continue
 			}
-			outFile.Close()
-			fmt.Printf("Arquivo %s gerado com sucesso!\n", outFileName)
+			tarefas = append(tarefas, tarefaPadronizacao{
+				entrada:    arquivo,
+				saida:      outFileName,
+				schema:     schema,
+				aparaAspas: true,
+			})
 		}
 	}
 
-	return nil
+	return padronizarEmParalelo(tarefas, 15)
 }
 
-// transforma linhas em string para tentar reparar linhas com aspas soltas
-func fixCsvLine(filename string, lineNum int, expectedCols int) []string {
-	f, err := os.Open(filename)
-	if err != nil {
-		fmt.Printf("Não consegui reabrir %s: %v\n", filename, err)
-		return nil
-	}
-	defer f.Close()
-
-	reader := transform.NewReader(f, charmap.ISO8859_1.NewDecoder())
-	scanner := bufio.NewScanner(reader)
-
-	current := 0
-	var line string
-	for scanner.Scan() {
-		current++
-		if current == lineNum {
-			line = scanner.Text()
-			break
-		}
-	}
-
+// fixCsvLine tenta reparar uma linha com aspas soltas, a partir do texto já lido
+func fixCsvLine(line string, expectedCols int) []string {
 	if line == "" {
 		return nil
 	}
@@ -1161,19 +357,8 @@ func fixCsvLine(filename string, lineNum int, expectedCols int) []string {
 		row = strings.Split(line, ";")
 	}
 
-	if len(row) > expectedCols {
-		diff := len(row) - expectedCols
-		for i, val := range row {
-			if _, err := fmt.Sscanf(val, "%f", new(float64)); err != nil {
-				mergedVal := strings.Join(row[i:i+diff+1], ";")
-				newRow := append(row[:i], mergedVal)
-				if i+diff+1 < len(row) {
-					newRow = append(newRow, row[i+diff+1:]...)
-				}
-				row = newRow
-				break
-			}
-		}
+	if merged := juntarCamposExtras(row, expectedCols); merged != nil {
+		row = merged
 	}
 
 	if len(row) != expectedCols {
@@ -1184,6 +369,5 @@ func fixCsvLine(filename string, lineNum int, expectedCols int) []string {
 		row[i] = strings.Trim(row[i], `"`)
 	}
 
-	fmt.Printf("Linha %d de %s reparada com sucesso!\n", lineNum, filename)
 	return row
 }
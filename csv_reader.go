@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+// linhaQuarentena é uma linha do arquivo de origem que foi rejeitada ou reparada
+// automaticamente, ou um aviso sobre ela (ex: colunas do cabeçalho fora do
+// schema); vai para o arquivo de quarentena (_rejects/<arquivo>.jsonl)
+type linhaQuarentena struct {
+	Arquivo string `json:"arquivo"`
+	Linha   int    `json:"linha"`
+	Texto   string `json:"texto"`
+	Reparo  string `json:"reparo,omitempty"` // vazio quando a linha foi rejeitada
+	Aviso   string `json:"aviso,omitempty"`  // preenchido quando a linha seguiu, só com o aviso
+	Motivo  string `json:"motivo"`
+}
+
+// rejeitada diz se a linha ficou fora da saída (nem reparada, nem só um aviso)
+func (l linhaQuarentena) rejeitada() bool {
+	return l.Reparo == "" && l.Aviso == ""
+}
+
+// contextoLinha é o que uma estratégia de reparo recebe sobre a linha quebrada
+type contextoLinha struct {
+	arquivo  string
+	linha    int
+	raw      string
+	campos   []string // nil quando o csv.Reader não conseguiu ler a linha
+	esperado int      // quantidade de colunas do cabeçalho
+}
+
+// estrategiaReparo tenta consertar uma linha; devolve nil quando não consegue
+type estrategiaReparo struct {
+	nome    string
+	aplicar func(c contextoLinha) []string
+}
+
+// reparosPadrao são as estratégias usadas nos arquivos da CVM, na ordem em que são tentadas
+var reparosPadrao = []estrategiaReparo{
+	{
+		nome: "aspas_soltas",
+		aplicar: func(c contextoLinha) []string {
+			return fixCsvLine(c.raw, c.esperado)
+		},
+	},
+	{
+		nome: "juntar_campos_extras",
+		aplicar: func(c contextoLinha) []string {
+			if c.campos == nil {
+				return nil
+			}
+			return juntarCamposExtras(c.campos, c.esperado)
+		},
+	},
+}
+
+// cvmCsvReader lê um CSV da CVM linha a linha, decodificando o encoding e
+// reparando (ou rejeitando) as linhas que não batem com o cabeçalho
+type cvmCsvReader struct {
+	arquivo     string
+	delimitador rune
+	encoding    encoding.Encoding
+	reparos     []estrategiaReparo
+	aparaAspas  bool // remove aspas que sobram no início/fim dos campos
+
+	// aoLerCabecalho, se definido, é chamado uma vez com o cabeçalho antes da primeira linha
+	aoLerCabecalho func(header []string) error
+
+	// quarentena recebe as linhas rejeitadas ou reparadas assim que aparecem;
+	// nil só conta, sem gravar
+	quarentena *sidecarQuarentena
+
+	header     []string
+	linhas     int // linhas de dados aceitas pelo callback (reparadas inclusive)
+	rejeitadas int
+	reparadas  int
+	avisos     int
+
+	scanner         *bufio.Scanner
+	lookahead       []string // linhas já lidas do scanner e devolvidas
+	ultimasJuntadas []string // linhas consumidas pelo último juntarLinhasQuebradas
+}
+
+// maxLinhasJuntadas limita quantas linhas físicas um registro com quebra de linha
+// dentro de aspas pode ocupar
+const maxLinhasJuntadas = 20
+
+// newCvmCsvReader cria o leitor com o padrão dos arquivos da CVM: ';' e ISO-8859-1
+func newCvmCsvReader(arquivo string) *cvmCsvReader {
+	return &cvmCsvReader{
+		arquivo:     arquivo,
+		delimitador: ';',
+		encoding:    charmap.ISO8859_1,
+		reparos:     reparosPadrao,
+	}
+}
+
+// parse lê uma linha isolada com o delimitador configurado
+func (r *cvmCsvReader) parse(line string) ([]string, error) {
+	cr := csv.NewReader(strings.NewReader(line))
+	cr.Comma = r.delimitador
+	cr.LazyQuotes = true
+
+	row, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	r.limparAspas(row)
+	return row, nil
+}
+
+// limparAspas remove aspas soltas do início/fim dos campos, se configurado
+func (r *cvmCsvReader) limparAspas(row []string) {
+	if !r.aparaAspas {
+		return
+	}
+	for i, val := range row {
+		row[i] = strings.TrimSuffix(strings.TrimPrefix(val, `"`), `"`)
+	}
+}
+
+// reparar aplica as estratégias em ordem e devolve a primeira que produzir a
+// quantidade certa de colunas, junto com o nome da estratégia
+func (r *cvmCsvReader) reparar(c contextoLinha) ([]string, string) {
+	for _, reparo := range r.reparos {
+		if row := reparo.aplicar(c); row != nil && len(row) == c.esperado {
+			return row, reparo.nome
+		}
+	}
+	return nil, ""
+}
+
+// proximaLinha devolve a próxima linha física, consumindo antes o lookahead
+func (r *cvmCsvReader) proximaLinha() (string, bool) {
+	if len(r.lookahead) > 0 {
+		line := r.lookahead[0]
+		r.lookahead = r.lookahead[1:]
+		return line, true
+	}
+	if !r.scanner.Scan() {
+		return "", false
+	}
+	return r.scanner.Text(), true
+}
+
+// aspasAbertas diz se a linha termina dentro de um campo entre aspas: com
+// LazyQuotes o csv.Reader aceita um campo assim sem erro, mesmo que a quebra de
+// linha esteja no último campo e a contagem de campos bata com o cabeçalho
+func aspasAbertas(line string) bool {
+	return strings.Count(line, `"`)%2 != 0
+}
+
+// juntarLinhasQuebradas trata nomes com quebra de linha dentro de aspas, que o
+// scanner entrega como várias linhas. As linhas seguintes só são consumidas se o
+// registro juntado fechar as aspas e tiver exatamente o número de colunas do
+// cabeçalho; caso contrário voltam para o lookahead e nada muda.
+func (r *cvmCsvReader) juntarLinhasQuebradas(line string) ([]string, int) {
+	if !aspasAbertas(line) {
+		return nil, 0
+	}
+
+	acc := line
+	var lidas []string
+	for len(lidas) < maxLinhasJuntadas {
+		next, ok := r.proximaLinha()
+		if !ok {
+			break
+		}
+		lidas = append(lidas, next)
+		acc += "\n" + next
+		if strings.Count(acc, `"`)%2 != 0 {
+			continue
+		}
+
+		cr := csv.NewReader(strings.NewReader(acc))
+		cr.Comma = r.delimitador
+		cr.FieldsPerRecord = len(r.header)
+		if row, err := cr.Read(); err == nil {
+			if _, err := cr.Read(); err == io.EOF {
+				r.ultimasJuntadas = lidas
+				r.limparAspas(row)
+				return row, len(lidas)
+			}
+		}
+		break
+	}
+
+	r.lookahead = append(lidas, r.lookahead...)
+	return nil, 0
+}
+
+func (r *cvmCsvReader) rejeitar(linha int, texto, motivo string) {
+	fmt.Printf("Ignorando linha irrecuperável %d em %s: %s\n", linha, r.arquivo, motivo)
+	r.rejeitadas++
+	r.registrar(linhaQuarentena{Arquivo: r.arquivo, Linha: linha, Texto: texto, Motivo: motivo})
+}
+
+func (r *cvmCsvReader) registrarReparo(l linhaQuarentena) {
+	r.reparadas++
+	r.registrar(l)
+}
+
+// avisar registra um problema que não impediu a linha de seguir
+func (r *cvmCsvReader) avisar(linha int, texto, aviso, motivo string) {
+	r.avisos++
+	r.registrar(linhaQuarentena{Arquivo: r.arquivo, Linha: linha, Texto: texto, Aviso: aviso, Motivo: motivo})
+}
+
+func (r *cvmCsvReader) registrar(l linhaQuarentena) {
+	if r.quarentena != nil {
+		r.quarentena.gravar(l)
+	}
+}
+
+// forEach chama fn para cada linha de dados (o cabeçalho fica em r.header).
+// Linhas irrecuperáveis ou reparadas vão para r.quarentena em vez de interromper a
+// leitura; fn também pode rejeitar uma linha devolvendo rejeicaoLinha, ou
+// aceitá-la com um aviso na quarentena devolvendo avisoLinha. Cada linha
+// conta uma vez só, pelo resultado final: reparada e aceita por fn entra como
+// reparada (com o aviso de fn na mesma entrada, se houver); reparada e
+// rejeitada por fn entra só como rejeitada.
+func (r *cvmCsvReader) forEach(fn func(row []string) error) error {
+	f, err := os.Open(r.arquivo)
+	if err != nil {
+		return fmt.Errorf("erro ao abrir arquivo %s: %w", r.arquivo, err)
+	}
+	defer f.Close()
+
+	r.scanner = bufio.NewScanner(transform.NewReader(f, r.encoding.NewDecoder()))
+	r.scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	r.lookahead = nil
+
+	lineNum := 0
+	for {
+		line, ok := r.proximaLinha()
+		if !ok {
+			break
+		}
+		lineNum++
+		inicio := lineNum
+		texto := line
+
+		row, err := r.parse(line)
+		if r.header == nil {
+			if err != nil {
+				r.rejeitar(lineNum, line, "cabeçalho ilegível: "+err.Error())
+				continue
+			}
+			r.header = row
+			if r.aoLerCabecalho != nil {
+				if err := r.aoLerCabecalho(row); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		var reparos, motivos []string
+		if err != nil || len(row) != len(r.header) || aspasAbertas(line) {
+			if joined, n := r.juntarLinhasQuebradas(line); joined != nil {
+				texto = strings.Join(append([]string{line}, r.ultimasJuntadas...), "\n")
+				row, err = joined, nil
+				lineNum += n
+				reparos = append(reparos, "juntar_linhas_quebradas")
+				motivos = append(motivos, "quebra de linha entre aspas")
+				fmt.Printf("Linhas %d-%d de %s juntadas (quebra de linha entre aspas)\n", inicio, lineNum, r.arquivo)
+			}
+		}
+
+		if err != nil || len(row) != len(r.header) {
+			motivo := fmt.Sprintf("%d campos, cabeçalho tem %d", len(row), len(r.header))
+			if err != nil {
+				motivo = err.Error()
+			}
+			fixed, reparo := r.reparar(contextoLinha{arquivo: r.arquivo, linha: inicio, raw: line, campos: row, esperado: len(r.header)})
+			if fixed == nil {
+				r.rejeitar(inicio, texto, motivo)
+				continue
+			}
+			fmt.Printf("Linha %d de %s reparada com sucesso (%s)!\n", inicio, r.arquivo, reparo)
+			reparos = append(reparos, reparo)
+			motivos = append(motivos, motivo)
+			row = fixed
+		}
+
+		var aviso avisoLinha
+		if err := fn(row); err != nil {
+			var rejeicao rejeicaoLinha
+			switch {
+			case errors.As(err, &aviso):
+			case errors.As(err, &rejeicao):
+				motivo := rejeicao.motivo
+				if len(reparos) > 0 {
+					motivo += " (após reparo " + strings.Join(reparos, "+") + ")"
+				}
+				r.rejeitar(inicio, texto, motivo)
+				continue
+			default:
+				return err
+			}
+		}
+		// reparada e aceita com aviso: uma entrada só, com o reparo e o aviso
+		switch {
+		case len(reparos) > 0:
+			if aviso.aviso != "" {
+				motivos = append(motivos, aviso.motivo)
+			}
+			r.registrarReparo(linhaQuarentena{Arquivo: r.arquivo, Linha: inicio, Texto: texto,
+				Reparo: strings.Join(reparos, "+"), Aviso: aviso.aviso, Motivo: strings.Join(motivos, "; ")})
+		case aviso.aviso != "":
+			r.avisar(inicio, texto, aviso.aviso, aviso.motivo)
+		}
+		r.linhas++
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return fmt.Errorf("erro ao escanear arquivo %s: %w", r.arquivo, err)
+	}
+	return nil
+}
+
+// juntarCamposExtras junta os campos excedentes no primeiro campo não numérico
+// (normalmente o nome do fundo, que veio com ';' no meio)
+func juntarCamposExtras(row []string, esperado int) []string {
+	diff := len(row) - esperado
+	if diff <= 0 {
+		return nil
+	}
+	for i, val := range row {
+		if _, err := fmt.Sscanf(val, "%f", new(float64)); err != nil {
+			newRow := make([]string, 0, esperado)
+			newRow = append(newRow, row[:i]...)
+			newRow = append(newRow, strings.Join(row[i:i+diff+1], ";"))
+			newRow = append(newRow, row[i+diff+1:]...)
+			return newRow
+		}
+	}
+	return nil
+}
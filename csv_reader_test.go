@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// lerCSVTeste grava conteudo (em ISO-8859-1, como os arquivos da CVM) e lê com
+// o cvmCsvReader, devolvendo as linhas aceitas pelo callback e a quarentena
+func lerCSVTeste(t *testing.T, conteudo string, fn func(row []string) error) (*cvmCsvReader, [][]string, []linhaQuarentena) {
+	t.Helper()
+	arquivo := filepath.Join(t.TempDir(), "teste.csv")
+	codificado, err := charmap.ISO8859_1.NewEncoder().String(conteudo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(arquivo, []byte(codificado), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := newCvmCsvReader(arquivo)
+	r.quarentena = novoSidecarQuarentena(caminhoQuarentena(arquivo))
+	var linhas [][]string
+	err = r.forEach(func(row []string) error {
+		var err error
+		if fn != nil {
+			err = fn(row)
+		}
+		// a linha com aviso segue para a saída, como na padronização
+		var aviso avisoLinha
+		if err == nil || errors.As(err, &aviso) {
+			linhas = append(linhas, append([]string(nil), row...))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.quarentena.fechar(); err != nil {
+		t.Fatal(err)
+	}
+	return r, linhas, lerQuarentenaTeste(t, caminhoQuarentena(arquivo))
+}
+
+func lerQuarentenaTeste(t *testing.T, path string) []linhaQuarentena {
+	t.Helper()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var linhas []linhaQuarentena
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var l linhaQuarentena
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatal(err)
+		}
+		linhas = append(linhas, l)
+	}
+	return linhas
+}
+
+func TestJuntarCamposExtras(t *testing.T) {
+	casos := []struct {
+		nome     string
+		campos   []string
+		esperado int
+		saida    []string
+	}{
+		{"nome com ponto e vírgula", []string{"1", "FUNDO A", " B", "10"}, 3, []string{"1", "FUNDO A; B", "10"}},
+		{"dois excedentes", []string{"1", "A", "B", "C", "10"}, 3, []string{"1", "A;B;C", "10"}},
+		{"sem excedentes", []string{"1", "A", "10"}, 3, nil},
+		{"faltam campos", []string{"1", "A"}, 3, nil},
+		{"só números", []string{"1", "2", "3", "4"}, 3, nil},
+	}
+	for _, c := range casos {
+		if got := juntarCamposExtras(c.campos, c.esperado); !reflect.DeepEqual(got, c.saida) {
+			t.Errorf("%s: juntarCamposExtras(%q, %d) = %q, queria %q", c.nome, c.campos, c.esperado, got, c.saida)
+		}
+	}
+}
+
+func TestFixCsvLine(t *testing.T) {
+	casos := []struct {
+		nome     string
+		linha    string
+		esperado int
+		saida    []string
+	}{
+		{"aspas soltas no meio", `1;"FUNDO "X" LTDA";10`, 3, []string{"1", `FUNDO "X" LTDA`, "10"}},
+		{"aspas sem fechar", `1;"FUNDO X;10`, 3, []string{"1", "FUNDO X", "10"}},
+		{"ponto e vírgula no nome", `1;FUNDO A; B;10`, 3, []string{"1", "FUNDO A; B", "10"}},
+		{"campos a menos", `1;10`, 3, nil},
+		{"vazia", ``, 3, nil},
+	}
+	for _, c := range casos {
+		if got := fixCsvLine(c.linha, c.esperado); !reflect.DeepEqual(got, c.saida) {
+			t.Errorf("%s: fixCsvLine(%q) = %q, queria %q", c.nome, c.linha, got, c.saida)
+		}
+	}
+}
+
+func TestCvmCsvReaderReparos(t *testing.T) {
+	casos := []struct {
+		nome       string
+		conteudo   string
+		linhas     [][]string
+		reparadas  int
+		rejeitadas int
+	}{
+		{"linhas boas", "ID;NOME;VL\n1;FUNDO A;10\n2;FUNDO É;20\n",
+			[][]string{{"1", "FUNDO A", "10"}, {"2", "FUNDO É", "20"}}, 0, 0},
+		{"ponto e vírgula no nome", "ID;NOME;VL\n1;FUNDO A; B;10\n",
+			[][]string{{"1", "FUNDO A; B", "10"}}, 1, 0},
+		{"quebra de linha entre aspas", "ID;NOME;VL\n1;\"FUNDO\nA\";10\n2;B;20\n",
+			[][]string{{"1", "FUNDO\nA", "10"}, {"2", "B", "20"}}, 1, 0},
+		{"quebra de linha entre aspas na última coluna", "ID;VL;NOME\n1;10;\"FUNDO\nA\"\n2;20;B\n",
+			[][]string{{"1", "10", "FUNDO\nA"}, {"2", "20", "B"}}, 1, 0},
+		{"aspas sem fechar não engolem a linha seguinte", "ID;NOME;VL\n1;\"FUNDO A;10\n2;B;20\n",
+			[][]string{{"1", "FUNDO A", "10"}, {"2", "B", "20"}}, 1, 0},
+		{"campos a menos", "ID;NOME;VL\n1;10\n2;B;20\n",
+			[][]string{{"2", "B", "20"}}, 0, 1},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			r, linhas, _ := lerCSVTeste(t, c.conteudo, nil)
+			if !reflect.DeepEqual(linhas, c.linhas) {
+				t.Errorf("linhas = %q, queria %q", linhas, c.linhas)
+			}
+			if r.linhas != len(c.linhas) || r.reparadas != c.reparadas || r.rejeitadas != c.rejeitadas {
+				t.Errorf("linhas %d, reparadas %d, rejeitadas %d; queria %d, %d, %d",
+					r.linhas, r.reparadas, r.rejeitadas, len(c.linhas), c.reparadas, c.rejeitadas)
+			}
+		})
+	}
+}
+
+// cada linha entra uma vez só na contagem e na quarentena, pelo resultado final
+func TestCvmCsvReaderContagemPorResultado(t *testing.T) {
+	conteudo := "ID;NOME;VL\n" +
+		"1;FUNDO A;10\n" + // aceita
+		"2;FUNDO B; C;20\n" + // reparada e aceita
+		"3;FUNDO D; E;X\n" + // reparada e recusada pelo callback
+		"4;FUNDO F;X\n" + // recusada pelo callback
+		"5;FUNDO G;-1\n" + // aceita com aviso
+		"6;FUNDO H; I;-1\n" + // reparada e aceita com aviso
+		"7;30\n" // irrecuperável
+	fn := func(row []string) error {
+		switch row[2] {
+		case "X":
+			return rejeicaoLinha{motivo: "VL inválido"}
+		case "-1":
+			return avisoLinha{aviso: "vl_negativo", motivo: "VL negativo"}
+		}
+		return nil
+	}
+	r, linhas, quarentena := lerCSVTeste(t, conteudo, fn)
+
+	if len(linhas) != 4 || r.linhas != 4 || r.reparadas != 2 || r.rejeitadas != 3 || r.avisos != 1 {
+		t.Fatalf("linhas %d (callback %d), reparadas %d, rejeitadas %d, avisos %d; queria 4, 2, 3, 1",
+			r.linhas, len(linhas), r.reparadas, r.rejeitadas, r.avisos)
+	}
+
+	type registro struct {
+		linha  int
+		estado string
+	}
+	var got []registro
+	for _, l := range quarentena {
+		estado := "rejeitada"
+		switch {
+		case l.Reparo != "" && l.Aviso != "":
+			estado = "reparada com aviso"
+		case l.Reparo != "":
+			estado = "reparada"
+		case l.Aviso != "":
+			estado = "aviso"
+		}
+		got = append(got, registro{l.Linha, estado})
+	}
+	queria := []registro{{3, "reparada"}, {4, "rejeitada"}, {5, "rejeitada"}, {6, "aviso"}, {7, "reparada com aviso"}, {8, "rejeitada"}}
+	if !reflect.DeepEqual(got, queria) {
+		t.Errorf("quarentena = %v, queria %v", got, queria)
+	}
+	if contarRejeitadas(quarentena) != r.rejeitadas {
+		t.Errorf("contarRejeitadas = %d, queria %d", contarRejeitadas(quarentena), r.rejeitadas)
+	}
+	if !strings.Contains(quarentena[1].Motivo, "após reparo") {
+		t.Errorf("motivo da linha reparada e recusada = %q, queria a menção ao reparo", quarentena[1].Motivo)
+	}
+}
+
+func TestCvmCsvReaderErroDoCallbackInterrompe(t *testing.T) {
+	arquivo := filepath.Join(t.TempDir(), "teste.csv")
+	if err := os.WriteFile(arquivo, []byte("ID;VL\n1;10\n2;20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	falha := errors.New("disco cheio")
+	n := 0
+	err := newCvmCsvReader(arquivo).forEach(func(row []string) error {
+		n++
+		return falha
+	})
+	if !errors.Is(err, falha) || n != 1 {
+		t.Errorf("forEach = %v após %d linhas; queria o erro do callback na primeira", err, n)
+	}
+}
@@ -4,52 +4,24 @@ import (
 	"database/sql"
 	"encoding/csv"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
-func database(tableName, csvFile string) {
-
-	godotenv.Load(".env")
-
-	host := os.Getenv("HOST")
-	port := os.Getenv("PORT")
-	user := os.Getenv("USER")
-	password := os.Getenv("PASSWORD")
-	dbName := os.Getenv("DATABASE")
-
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbName)
-	// 1. Cria o banco de dados se não existir
-	if err := createDatabase(connStr, dbName); err != nil {
-		log.Fatal(err)
-	}
-
-	// 2. Conecta ao banco criado
-	db, err := sql.Open("postgres", connStr)
+// database importa um único CSV. Para vários arquivos use newLoader e
+// carregarVarios, que reaproveitam a mesma conexão.
+func database(tableName, csvFile string) error {
+	l, err := newLoader(1)
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	if err := db.Ping(); err != nil {
-		log.Fatal(err)
-	}
-
-	// // 3. Cria a tabela baseada no CSV
-	if err := createTableFromCSV(db, csvFile, tableName); err != nil {
-		log.Fatal(err)
-	}
-
-	// 4. Importa os dados
-	if err := importCSV(db, csvFile, tableName); err != nil {
-		log.Fatal(err)
+		return err
 	}
+	defer l.Close()
 
-	fmt.Println("✓ Banco, tabela e dados criados com sucesso!")
+	return l.carregar(tableName, csvFile)
 }
 
 // createDatabase cria o banco de dados se não existir
@@ -69,7 +41,7 @@ func createDatabase(connStr, dbName string) error {
 	}
 
 	if !exists {
-		_, err = db.Exec(fmt.Sprintf("CREATE DATABASE %s", dbName))
+		_, err = db.Exec("CREATE DATABASE " + qi(dbName))
 		if err != nil {
 			return fmt.Errorf("erro ao criar banco: %w", err)
 		}
@@ -81,65 +53,36 @@ func createDatabase(connStr, dbName string) error {
 	return nil
 }
 
-// createTableFromCSV cria a tabela baseada no cabeçalho do CSV
-func createTableFromCSV(db *sql.DB, csvFile, tableName string) error {
-	f, err := os.Open(csvFile)
+// createTableFromCSV cria a tabela baseada no cabeçalho do CSV ou, se ela já
+// existir, ajusta a estrutura para o CSV (ver evoluirTabela). Os tipos são
+// inferidos lendo o arquivo (ver inferirColunas), tipos fixados no schema do
+// dataset (ver colunaSchema.tipoSQL) têm precedência e extras são colunas que não vêm do CSV, como as
+// de proveniência.
+func createTableFromCSV(db *sql.DB, csvFile, tableName string, tipos map[string]string, extras []colunaTabela) error {
+	colunas, examinadas, err := inferirColunas(csvFile)
 	if err != nil {
-		return fmt.Errorf("erro ao abrir CSV: %w", err)
-	}
-	defer f.Close()
-
-	reader := csv.NewReader(f)
-	reader.Comma = ',' // Ajuste conforme necessário
-
-	// Lê o cabeçalho
-	header, err := reader.Read()
-	if err != nil {
-		return fmt.Errorf("erro ao ler cabeçalho: %w", err)
+		return err
 	}
 
-	// Lê algumas linhas para inferir tipos
-	sampleRows := [][]string{}
-	for i := 0; i < 50; i++ {
-		row, err := reader.Read()
-		if err != nil {
-			break
+	fmt.Printf("Tipos de '%s' (%d linhas examinadas de %s):\n", tableName, examinadas, csvFile)
+	for i, c := range colunas {
+		origem := "inferido"
+		if tipo, ok := tipos[c.nome]; ok {
+			if err := validarTipoSQL(tipo); err != nil {
+				return fmt.Errorf("coluna %s: %w", c.nome, err)
+			}
+			colunas[i].tipo = tipo
+			colunas[i].semAmostra = false
+			origem = "schema"
+		} else if c.semAmostra {
+			origem = "sem valores"
 		}
-		sampleRows = append(sampleRows, row)
+		fmt.Printf("  %-40s %-16s %s\n", c.nome, colunas[i].tipo, origem)
 	}
+	colunas = append(colunas, extras...)
 
-	// Dropa a tabela se existir
-	// _, err = db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", tableName))
-	// if err != nil {
-	// 	return err
-	// }
-
-	// Constrói o CREATE TABLE
-	var columns []string
-	for i, colName := range header {
-		// Limpa o nome da coluna
-		colName = cleanColumnName(colName)
-		// Infere o tipo de dado
-		colType := inferType(sampleRows, i, header)
-		columns = append(columns, fmt.Sprintf("%s %s", colName, colType))
-	}
-
-	// createSQL := fmt.Sprintf("CREATE TABLE %s (\n  id SERIAL PRIMARY KEY,\n  %s\n)",
-	createSQL := fmt.Sprintf("CREATE TABLE %s (%s\n)",
-		tableName,
-		strings.Join(columns, ",\n  "))
-
-	_, err = db.Exec(createSQL)
-	if err != nil {
-		fmt.Println("erro ao criar tabela: %w - continuando mesmo assim", err)
-	}
-
-	fmt.Printf("✓ Tabela '%s' criada com %d colunas\n", tableName, len(header))
-	fmt.Println("\nEstrutura da tabela:")
-	fmt.Println(createSQL)
-	fmt.Println()
-
-	return nil
+	// Cria a tabela ou adiciona/alarga as colunas que faltam
+	return evoluirTabela(db, tableName, csvFile, colunas)
 }
 
 // cleanColumnName limpa e formata o nome da coluna
@@ -158,197 +101,178 @@ func cleanColumnName(name string) string {
 	return result.String()
 }
 
-// inferType tenta inferir o tipo de dado da coluna
-func inferType(rows [][]string, colIndex int, header []string) string {
-	if len(rows) == 0 {
-		return "TEXT"
-	}
+// importCSV importa os dados numa única transação: aplica o modo de carga
+// (append, truncate ou replace), carrega com COPY FROM STDIN e, se o COPY
+// falhar, tenta de novo com INSERT em lotes. Com chave natural os dados passam
+// por uma tabela temporária e entram por UPSERT. Com prov, cada linha recebe as
+// colunas de proveniência. Linhas com CNPJ da chave inválido ficam de fora e
+// voltam como rejeitos, junto com os avisos dos CNPJs secundários anulados.
+func importCSV(db *sql.DB, csvFile, tableName string, cfg configCarga, prov *proveniencia) (int, []linhaQuarentena, error) {
+	inicio := time.Now()
 
-	// Verifica se o nome da coluna começa com "cnpj"
-	if colIndex < len(header) && strings.HasPrefix(strings.ToLower(header[colIndex]), "cnpj") {
-		return "TEXT"
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, nil, err
 	}
+	defer tx.Rollback()
 
-	isInt := true
-	isFloat := true
-	isDate := true
-	hasNonNumericChars := false
-	validValuesCount := 0
-
-	for _, row := range rows {
-		if colIndex >= len(row) {
-			continue
-		}
-		val := strings.TrimSpace(row[colIndex])
-		valLower := strings.ToLower(val)
-
-		// Ignora valores vazios/nulos mas continua verificando
-		if val == "" || valLower == "null" || valLower == "na" || valLower == "nan" || valLower == "n/a" {
-			continue
-		}
+	if err := prepararCarga(tx, csvFile, tableName, cfg); err != nil {
+		return 0, nil, err
+	}
 
-		validValuesCount++
-
-		// Verifica se é data no formato AAAA-MM-DD
-		if len(val) == 10 && val[4] == '-' && val[7] == '-' {
-			parts := strings.Split(val, "-")
-			if len(parts) == 3 {
-				var y, m, d int
-				if _, err := fmt.Sscanf(parts[0], "%d", &y); err != nil || y < 1900 || y > 2100 {
-					isDate = false
-				}
-				if _, err := fmt.Sscanf(parts[1], "%d", &m); err != nil || m < 1 || m > 12 {
-					isDate = false
-				}
-				if _, err := fmt.Sscanf(parts[2], "%d", &d); err != nil || d < 1 || d > 31 {
-					isDate = false
-				}
-			} else {
-				isDate = false
-			}
-		} else {
-			isDate = false
+	destino := tableName
+	if len(cfg.chave) > 0 {
+		if destino, err = criarTabelaTemporaria(tx, tableName); err != nil {
+			return 0, nil, err
 		}
+	}
+	formato, err := novoFormatoCarga(tx, csvFile, tableName, cfg)
+	if err != nil {
+		return 0, nil, err
+	}
 
-		if strings.Contains(val, "/") ||
-			strings.Count(val, ".") > 1 ||
-			strings.Count(val, "-") > 1 {
-			hasNonNumericChars = true
+	if _, err := tx.Exec("SAVEPOINT carga"); err != nil {
+		return 0, nil, err
+	}
+	recordCount, err := copyCSV(tx, csvFile, destino, formato, prov)
+	if err != nil {
+		fmt.Printf("\nCOPY falhou para %s (%v), usando INSERT em lotes\n", csvFile, err)
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT carga"); err != nil {
+			return 0, nil, err
 		}
-		for _, r := range val {
-			if r != '-' && r != '.' && r != ',' && (r < '0' || r > '9') {
-				hasNonNumericChars = true
-				break
-			}
+		recordCount, err = insertCSV(tx, csvFile, destino, formato, prov)
+		if err != nil {
+			return 0, nil, err
 		}
+	}
 
-		if strings.Contains(val, ".") || strings.Contains(val, ",") {
-			isInt = false
-		} else {
-			testVal := strings.TrimPrefix(val, "-")
-			for _, r := range testVal {
-				if r < '0' || r > '9' {
-					isInt = false
-					break
-				}
-			}
-			if isInt && len(testVal) > 0 {
-				const maxInt32 = 2147483647
-				const minInt32 = -2147483648
-				var num int64
-				if _, err := fmt.Sscanf(val, "%d", &num); err == nil {
-					if num > maxInt32 || num < minInt32 {
-						isInt = false
-					}
-				} else {
-					isInt = false
-				}
-			}
-		}
-		if !hasNonNumericChars {
-			var f float64
-			testVal := strings.ReplaceAll(val, ",", ".")
-			if _, err := fmt.Sscanf(testVal, "%f", &f); err != nil {
-				isFloat = false
-			}
-		} else {
-			isFloat = false
+	if len(cfg.chave) > 0 {
+		if _, err := mesclarStaging(tx, destino, tableName, cfg.chave); err != nil {
+			return 0, nil, err
 		}
 	}
 
-	if validValuesCount == 0 {
-		return "TEXT"
-	}
-	if isDate {
-		return "DATE"
-	}
-	if hasNonNumericChars {
-		return "TEXT"
-	}
-	if isInt {
-		return "INTEGER"
-	}
-	if isFloat {
-		return "DECIMAL"
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
 	}
-	return "TEXT"
-}
 
-// formataCNPJ recebe um valor e retorna o CNPJ formatado (se possível)
-func formataCNPJ(val string) string {
-	// Remove tudo que não é dígito
-	var digits strings.Builder
-	for _, r := range val {
-		if r >= '0' && r <= '9' {
-			digits.WriteRune(r)
-		}
+	fmt.Printf("\r✓ Importados %d registros no total (%s, modo %s)\n", recordCount, time.Since(inicio).Round(time.Millisecond), cfg.modo)
+	recusadas := contarRejeitadas(formato.rejeitos)
+	if recusadas > 0 {
+		fmt.Printf("  %d linhas de %s recusadas na carga\n", recusadas, csvFile)
 	}
-	cnpj := digits.String()
-	if len(cnpj) != 14 && strings.ToLower(cnpj) == "nan" {
-		return val // Não é um CNPJ válido, retorna original
-	} else {
-		zerosNeeded := 14 - len(cnpj)
-		if zerosNeeded > 0 {
-			cnpj = strings.Repeat("0", zerosNeeded) + cnpj
-		}
+	if n := len(formato.rejeitos) - recusadas; n > 0 {
+		fmt.Printf("  %d linhas de %s com CNPJ secundário inválido anulado\n", n, csvFile)
 	}
-	// Formata: 00.000.000/0000-00
-	return fmt.Sprintf("%s.%s.%s/%s-%s",
-		cnpj[0:2], cnpj[2:5], cnpj[5:8], cnpj[8:12], cnpj[12:14])
+	return recordCount, formato.rejeitos, nil
 }
 
-// importCSV importa os dados usando COPY FROM
-func importCSV(db *sql.DB, csvFile, tableName string) error {
+// abrirCSVCarga abre o CSV padronizado e devolve o leitor e o cabeçalho já limpo
+func abrirCSVCarga(csvFile string) (*os.File, *csv.Reader, []string, error) {
 	f, err := os.Open(csvFile)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
-	defer f.Close()
 
 	reader := csv.NewReader(f)
 	reader.Comma = ','
 	reader.LazyQuotes = true
+	reader.ReuseRecord = true
 
 	// Lê o cabeçalho
 	header, err := reader.Read()
 	if err != nil {
-		return err
+		f.Close()
+		return nil, nil, nil, err
 	}
 
-	// Limpa os nomes das colunas
-	for i := range header {
-		header[i] = cleanColumnName(header[i])
+	// Limpa os nomes das colunas, resolvendo vazios e repetidos
+	return f, reader, resolverNomesColunas(header), nil
+}
+
+// copyCSV carrega o arquivo inteiro com um único COPY
+func copyCSV(tx *sql.Tx, csvFile, tableName string, formato *formatoCarga, prov *proveniencia) (int, error) {
+	f, reader, header, err := abrirCSVCarga(csvFile)
+	if err != nil {
+		return 0, err
 	}
+	defer f.Close()
+	formato.rejeitos = nil
 
-	tx, err := db.Begin()
+	// pq.CopyIn já põe tabela e colunas entre aspas
+	stmt, err := tx.Prepare(pq.CopyIn(tableName, append(header, prov.nomes()...)...))
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer tx.Rollback()
+	defer stmt.Close()
 
-	// Usa INSERT em lotes (mais compatível que COPY)
-	batch := [][]string{}
-	batchSize := 1000
 	recordCount := 0
-
+	values := append(make([]interface{}, len(header)), prov.valores()...)
 	for {
 		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			// Insere último lote
-			if len(batch) > 0 {
-				if err := insertBatch(tx, tableName, header, batch); err != nil {
-					return err
-				}
-				recordCount += len(batch)
-			}
+			return recordCount, err
+		}
+		linha, _ := reader.FieldPos(0)
+		if !formato.linha(header, record, linha, values) {
+			continue
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return recordCount, err
+		}
+		recordCount++
+		if recordCount%100000 == 0 {
+			fmt.Printf("\r✓ Importados %d registros...", recordCount)
+		}
+	}
+
+	// Exec sem argumentos envia o que ficou no buffer do COPY
+	if _, err := stmt.Exec(); err != nil {
+		return recordCount, err
+	}
+	return recordCount, stmt.Close()
+}
+
+// insertCSV carrega o arquivo com INSERT em lotes (mais compatível que COPY)
+func insertCSV(tx *sql.Tx, csvFile, tableName string, formato *formatoCarga, prov *proveniencia) (int, error) {
+	f, reader, header, err := abrirCSVCarga(csvFile)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	formato.rejeitos = nil
+	colunas := append(header, prov.nomes()...)
+
+	// o Postgres aceita no máximo 65535 parâmetros por comando
+	batchSize := 1000
+	if maxLinhas := 65535 / len(colunas); maxLinhas < batchSize {
+		batchSize = maxLinhas
+	}
+
+	batch := [][]interface{}{}
+	recordCount := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return recordCount, err
+		}
 
-		batch = append(batch, record)
+		values := append(make([]interface{}, len(header)), prov.valores()...)
+		linha, _ := reader.FieldPos(0)
+		if !formato.linha(header, record, linha, values) {
+			continue
+		}
+		batch = append(batch, values)
 
 		if len(batch) >= batchSize {
-			if err := insertBatch(tx, tableName, header, batch); err != nil {
-				return err
+			if err := insertBatch(tx, tableName, colunas, batch); err != nil {
+				return recordCount, err
 			}
 			recordCount += len(batch)
 			fmt.Printf("\r✓ Importados %d registros...", recordCount)
@@ -356,50 +280,69 @@ func importCSV(db *sql.DB, csvFile, tableName string) error {
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return err
+	// Insere último lote
+	if err := insertBatch(tx, tableName, colunas, batch); err != nil {
+		return recordCount, err
 	}
+	recordCount += len(batch)
 
-	fmt.Printf("\r✓ Importados %d registros no total\n", recordCount)
-	return nil
+	return recordCount, nil
 }
 
-func insertBatch(tx *sql.Tx, tableName string, header []string, batch [][]string) error {
+// insertBatch insere linhas já convertidas por formatoCarga.linha
+func insertBatch(tx *sql.Tx, tableName string, colunas []string, batch [][]interface{}) error {
 	if len(batch) == 0 {
 		return nil
 	}
 
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES ",
-		tableName,
-		strings.Join(header, ", "))
+	var query strings.Builder
+	fmt.Fprintf(&query, "INSERT INTO %s (%s) VALUES ", qi(tableName), qis(colunas))
 
-	var values []interface{}
+	values := make([]interface{}, 0, len(batch)*len(colunas))
 	for i, record := range batch {
 		if i > 0 {
-			query += ", "
+			query.WriteString(", ")
 		}
-		query += "("
+		query.WriteString("(")
 		for j, val := range record {
 			if j > 0 {
-				query += ", "
-			}
-			query += fmt.Sprintf("$%d", len(values)+1)
-			valTrimmed := strings.TrimSpace(val)
-			valLower := strings.ToLower(valTrimmed)
-			// Verifica se a coluna é CNPJ
-			if strings.HasPrefix(header[j], "cnpj") && valTrimmed != "" {
-				valTrimmed = formataCNPJ(valTrimmed)
-			}
-			// Trata valores vazios e nulos como NULL
-			if valTrimmed == "" || valLower == "null" || valLower == "na" || valLower == "nan" || valLower == "n/a" {
-				values = append(values, nil)
-			} else {
-				values = append(values, valTrimmed)
+				query.WriteString(", ")
 			}
+			values = append(values, val)
+			fmt.Fprintf(&query, "$%d", len(values))
 		}
-		query += ")"
+		query.WriteString(")")
 	}
 
-	_, err := tx.Exec(query, values...)
+	_, err := tx.Exec(query.String(), values...)
 	return err
 }
+
+// normalizarValor prepara um valor do CSV para o banco: CNPJs formatados e
+// vazio/null/na/nan/n/a como NULL. Colunas da chave natural recebem "" em vez
+// de NULL, senão o índice único não enxerga a duplicata (ex: ID_SUBCLASSE vazio).
+// Um CNPJ inválido na coluna que identifica o fundo (cnpjChave) devolve erro e a
+// linha vai para os rejeitos da carga; nas demais colunas cnpj* (administrador,
+// auditor, emissor...) vira NULL e o problema volta em aviso.
+func normalizarValor(coluna, val string, chave, cnpjChave bool) (interface{}, string, error) {
+	valTrimmed := strings.TrimSpace(val)
+	// Trata valores vazios e nulos como NULL
+	if ehNulo(valTrimmed) {
+		if chave {
+			return "", "", nil
+		}
+		return nil, "", nil
+	}
+	// Verifica se a coluna é CNPJ
+	if strings.HasPrefix(coluna, "cnpj") {
+		n, err := parseCNPJ(valTrimmed)
+		if err != nil {
+			if !cnpjChave {
+				return nil, fmt.Sprintf("%s: %v", coluna, err), nil
+			}
+			return nil, "", fmt.Errorf("%s: %w", coluna, err)
+		}
+		return n.formatado(), "", nil
+	}
+	return valTrimmed, "", nil
+}
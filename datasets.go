@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// periodicidade indica de quanto em quanto tempo a CVM publica um novo arquivo do dataset
+type periodicidade int
+
+const (
+	periodicidadeEstatica periodicidade = iota // um único arquivo, sobrescrito pela CVM (cadastros)
+	periodicidadeDiaria
+	periodicidadeMensal
+	periodicidadeTrimestral
+	periodicidadeAnual
+)
+
+// tipoArquivo indica como o arquivo é publicado no portal
+type tipoArquivo int
+
+const (
+	arquivoZip tipoArquivo = iota // .zip que precisa ser descompactado em dest
+	arquivoCsv                    // .csv publicado direto, apenas movido para dest
+)
+
+// urlLayout descreve a URL e o nome local do arquivo a partir de um determinado ano.
+// Placeholders aceitos: {ano}, {mes}, {anomes} e {data} (AAAAMMDD).
+type urlLayout struct {
+	desdeAno int
+	url      string
+	arquivo  string // nome do arquivo publicado (usado nos logs)
+	destino  string // nome final em dest, apenas para arquivoCsv
+}
+
+// cvmDataset descreve um dataset do dados.cvm.gov.br
+type cvmDataset struct {
+	nome          string
+	periodicidade periodicidade
+	tipo          tipoArquivo
+	layouts       []urlLayout // ordenados por desdeAno
+	hist          *urlLayout  // arquivo anual da pasta HIST (zip), quando existir
+	dest          string
+	membros       []string // arquivos esperados após a extração (mesmos placeholders)
+}
+
+const cvmBaseURL = "https://dados.cvm.gov.br/dados"
+
+// fiDoc monta o dataset padrão de FI/DOC/<objeto>, mensal com fallback HIST anual
+func fiDoc(objeto string, membros ...string) cvmDataset {
+	return cvmDataset{
+		nome:          objeto,
+		periodicidade: periodicidadeMensal,
+		tipo:          arquivoZip,
+		layouts: []urlLayout{{
+			url:     fmt.Sprintf("%s/FI/DOC/%s/DADOS/%s_fi_{anomes}.zip", cvmBaseURL, objeto, objeto),
+			arquivo: objeto + "_fi_{anomes}.zip",
+		}},
+		hist: &urlLayout{
+			url:     fmt.Sprintf("%s/FI/DOC/%s/DADOS/HIST/%s_fi_{ano}.zip", cvmBaseURL, objeto, objeto),
+			arquivo: objeto + "_fi_{ano}.zip",
+		},
+		dest:    "csvs/" + objeto,
+		membros: membros,
+	}
+}
+
+// cadCsv monta o dataset de um cadastro publicado como CSV simples (ex: cad_fi.csv)
+func cadCsv(tab, cadOuDoc string) cvmDataset {
+	nomeArquivo := fmt.Sprintf("%s_%s.csv", cadOuDoc, tab)
+	return cvmDataset{
+		nome:          fmt.Sprintf("%s_%s", cadOuDoc, tab),
+		periodicidade: periodicidadeEstatica,
+		tipo:          arquivoCsv,
+		layouts: []urlLayout{{
+			url:     fmt.Sprintf("%s/%s/%s/DADOS/%s", cvmBaseURL, tab, cadOuDoc, nomeArquivo),
+			arquivo: nomeArquivo,
+			destino: nomeArquivo,
+		}},
+		dest:    "csvs/" + tab,
+		membros: []string{nomeArquivo},
+	}
+}
+
+// cadZip monta o dataset de um cadastro publicado compactado (ex: registro_fundo_classe.zip)
+func cadZip(nome, tab, cadOuDoc, aux string, membros ...string) cvmDataset {
+	return cvmDataset{
+		nome:          nome,
+		periodicidade: periodicidadeEstatica,
+		tipo:          arquivoZip,
+		layouts: []urlLayout{{
+			url:     fmt.Sprintf("%s/%s/%s/DADOS/%s.zip", cvmBaseURL, tab, cadOuDoc, aux),
+			arquivo: aux + ".zip",
+		}},
+		dest:    "csvs/" + tab,
+		membros: membros,
+	}
+}
+
+// cvmDatasets é o registro de todos os datasets conhecidos. Um dataset novo da CVM
+// deve ser apenas uma entrada aqui.
+var cvmDatasets = map[string]cvmDataset{
+	"inf_diario": fiDoc("inf_diario", "inf_diario_fi_{anomes}.csv"),
+	"lamina": fiDoc("lamina",
+		"lamina_fi_{anomes}.csv",
+		"lamina_fi_carteira_{anomes}.csv",
+		"lamina_fi_rentab_ano_{anomes}.csv",
+		"lamina_fi_rentab_mes_{anomes}.csv",
+	),
+	"cda": fiDoc("cda",
+		"cda_fi_BLC_1_{anomes}.csv", "cda_fi_BLC_2_{anomes}.csv", "cda_fi_BLC_3_{anomes}.csv",
+		"cda_fi_BLC_4_{anomes}.csv", "cda_fi_BLC_5_{anomes}.csv", "cda_fi_BLC_6_{anomes}.csv",
+		"cda_fi_BLC_7_{anomes}.csv", "cda_fi_BLC_8_{anomes}.csv", "cda_fi_PL_{anomes}.csv",
+	),
+	"fidc": {
+		nome:          "fidc",
+		periodicidade: periodicidadeMensal,
+		tipo:          arquivoZip,
+		layouts: []urlLayout{{
+			url:     cvmBaseURL + "/fidc/DOC/INF_MENSAL/DADOS/inf_mensal_fidc_{anomes}.zip",
+			arquivo: "fidc_fi_{anomes}.zip",
+		}},
+		dest: "csvs/fidc",
+		membros: []string{
+			"inf_mensal_fidc_tab_IV_{anomes}.csv",
+			"inf_mensal_fidc_tab_X_1_{anomes}.csv",
+			"inf_mensal_fidc_tab_X_2_{anomes}.csv",
+			"inf_mensal_fidc_tab_X_3_{anomes}.csv",
+		},
+	},
+	// até 2023 o informe do FIP era trimestral, a partir de 2024 passou a ser quadrimestral
+	"fip": {
+		nome:          "fip",
+		periodicidade: periodicidadeAnual,
+		tipo:          arquivoCsv,
+		layouts: []urlLayout{
+			{
+				url:     cvmBaseURL + "/fip/DOC/inf_trimestral/DADOS/inf_trimestral_fip_{ano}.csv",
+				arquivo: "inf_trimestral_fip_{ano}.csv",
+				destino: "inf_tri_quadri_fip_{ano}.csv",
+			},
+			{
+				desdeAno: 2024,
+				url:      cvmBaseURL + "/fip/DOC/inf_quadrimestral/DADOS/inf_quadrimestral_fip_{ano}.csv",
+				arquivo:  "inf_quadrimestral_fip_{ano}.csv",
+				destino:  "inf_tri_quadri_fip_{ano}.csv",
+			},
+		},
+		dest:    "csvs/fip",
+		membros: []string{"inf_tri_quadri_fip_{ano}.csv"},
+	},
+	"fii": {
+		nome:          "fii",
+		periodicidade: periodicidadeAnual,
+		tipo:          arquivoZip,
+		layouts: []urlLayout{{
+			url:     cvmBaseURL + "/FII/DOC/INF_MENSAL/DADOS/inf_mensal_fii_{ano}.zip",
+			arquivo: "inf_mensal_fii_{ano}.zip",
+		}},
+		dest: "csvs/fii",
+		membros: []string{
+			"inf_mensal_fii_geral_{ano}.csv",
+			"inf_mensal_fii_complemento_{ano}.csv",
+			"inf_mensal_fii_ativo_passivo_{ano}.csv",
+		},
+	},
+	"cad_fi":      cadCsv("fi", "cad"),
+	"cad_adm_fii": cadCsv("adm_fii", "cad"),
+	"registro_fi": cadZip("registro_fi", "fi", "cad", "registro_fundo_classe",
+		"registro_fundo.csv", "registro_classe.csv", "registro_subclasse.csv"),
+}
+
+// datasetPorNome busca o dataset no registro
+func datasetPorNome(nome string) (cvmDataset, error) {
+	ds, ok := cvmDatasets[nome]
+	if !ok {
+		return cvmDataset{}, fmt.Errorf("dataset desconhecido: %s (disponíveis: %s)", nome, strings.Join(nomesDatasets(), ", "))
+	}
+	return ds, nil
+}
+
+// nomesDatasets lista os datasets registrados em ordem alfabética
+func nomesDatasets() []string {
+	nomes := make([]string, 0, len(cvmDatasets))
+	for nome := range cvmDatasets {
+		nomes = append(nomes, nome)
+	}
+	sort.Strings(nomes)
+	return nomes
+}
+
+// layoutDoAno retorna o layout vigente para o ano informado
+func (ds cvmDataset) layoutDoAno(ano int) urlLayout {
+	layout := ds.layouts[0]
+	for _, l := range ds.layouts {
+		if l.desdeAno <= ano {
+			layout = l
+		}
+	}
+	return layout
+}
+
+// expandir substitui os placeholders de um padrão de URL/arquivo
+func expandir(padrao string, ano, mes, dia int) string {
+	return strings.NewReplacer(
+		"{ano}", fmt.Sprintf("%04d", ano),
+		"{mes}", fmt.Sprintf("%02d", mes),
+		"{anomes}", fmt.Sprintf("%04d%02d", ano, mes),
+		"{data}", fmt.Sprintf("%04d%02d%02d", ano, mes, dia),
+	).Replace(padrao)
+}
+
+// novoJob monta um Job a partir de um layout já escolhido
+func (ds cvmDataset) novoJob(layout urlLayout, tipo tipoArquivo, ano, mes, dia int) Job {
+	job := Job{
+		dataset: ds.nome,
+		tipo:    tipo,
+		ano:     ano,
+		mes:     mes,
+		url:     expandir(layout.url, ano, mes, dia),
+		file:    expandir(layout.arquivo, ano, mes, dia),
+		dest:    ds.dest,
+		aux:     expandir(layout.destino, ano, mes, dia),
+	}
+	for _, m := range ds.membros {
+		job.membros = append(job.membros, expandir(m, ano, mes, dia))
+	}
+	return job
+}
+
+// jobsHistorico gera os jobs dos arquivos anuais da pasta HIST; datasets sem
+// pasta HIST são erro, em vez de cair nos arquivos do período
+func (ds cvmDataset) jobsHistorico(anos []int) ([]Job, error) {
+	if ds.hist == nil {
+		return nil, fmt.Errorf("o dataset %s não tem arquivos anuais na pasta HIST (--hist)", ds.nome)
+	}
+	var jobs []Job
+	for _, ano := range anos {
+		job := ds.novoJob(*ds.hist, arquivoZip, ano, 0, 0)
+		// o zip anual traz todos os meses, os membros mensais não se aplicam
+		job.membros = nil
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// buildJobs gera os jobs de download de um dataset para os anos/meses pedidos
+func buildJobs(ds cvmDataset, anos, meses []int) []Job {
+	var jobs []Job
+
+	// meses em ordem decrescente, como sempre foi feito (mais recente primeiro)
+	mesesDesc := append([]int(nil), meses...)
+	sort.Sort(sort.Reverse(sort.IntSlice(mesesDesc)))
+
+	switch ds.periodicidade {
+	case periodicidadeEstatica:
+		jobs = append(jobs, ds.novoJob(ds.layoutDoAno(0), ds.tipo, 0, 0, 0))
+	case periodicidadeAnual:
+		for _, ano := range anos {
+			jobs = append(jobs, ds.novoJob(ds.layoutDoAno(ano), ds.tipo, ano, 0, 0))
+		}
+	case periodicidadeTrimestral:
+		for _, ano := range anos {
+			for _, mes := range mesesDesc {
+				if mes%3 == 0 {
+					jobs = append(jobs, ds.novoJob(ds.layoutDoAno(ano), ds.tipo, ano, mes, 0))
+				}
+			}
+		}
+	case periodicidadeMensal:
+		for _, ano := range anos {
+			for _, mes := range mesesDesc {
+				jobs = append(jobs, ds.novoJob(ds.layoutDoAno(ano), ds.tipo, ano, mes, 0))
+			}
+		}
+	case periodicidadeDiaria:
+		for _, ano := range anos {
+			for _, mes := range mesesDesc {
+				ultimoDia := time.Date(ano, time.Month(mes)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+				for dia := ultimoDia; dia >= 1; dia-- {
+					jobs = append(jobs, ds.novoJob(ds.layoutDoAno(ano), ds.tipo, ano, mes, dia))
+				}
+			}
+		}
+	}
+
+	return jobs
+}
+
+// todosOsMeses é o padrão de meses usado pelos wrappers antigos
+var todosOsMeses = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
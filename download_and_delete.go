@@ -2,61 +2,254 @@ package main
 
 import (
 	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/carlmjohnson/requests"
 )
 
-func downloadFile(url, output string) error {
-	f, err := os.Create(output)
-	if err != nil {
-		return err
+// errNaoPublicado indica que a CVM ainda não publicou o arquivo (HTTP 404)
+var errNaoPublicado = errors.New("arquivo ainda não publicado")
+
+// contadorBytes conta quantos bytes passaram pelo writer
+type contadorBytes struct {
+	w io.Writer
+	n int64
+}
+
+func (c *contadorBytes) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// retryConfig controla as novas tentativas do downloadFile
+type retryConfig struct {
+	tentativas     int           // total de tentativas por arquivo (incluindo a primeira)
+	backoffInicial time.Duration // espera antes da segunda tentativa, dobrando a cada falha
+	backoffMax     time.Duration
+	timeout        time.Duration // tempo máximo de cada requisição
+}
+
+// downloadRetry é a configuração usada pelos downloads; a CLI pode sobrescrever
+var downloadRetry = retryConfig{
+	tentativas:     5,
+	backoffInicial: 2 * time.Second,
+	backoffMax:     time.Minute,
+	timeout:        10 * time.Minute,
+}
+
+// downloadFile baixa url em out e devolve a entrada de manifesto do arquivo.
+// Erros 5xx, 429 e falhas de rede/timeout são tentados de novo com backoff
+// exponencial; um 404 é devolvido na hora como errNaoPublicado. Com anterior != nil
+// a requisição é condicional (If-None-Match/If-Modified-Since) e um 304 volta
+// como errNaoModificado.
+func downloadFile(url string, out *spoolBuffer, anterior *manifestEntry) (manifestEntry, error) {
+	cfg := downloadRetry
+	if cfg.tentativas < 1 {
+		cfg.tentativas = 1
+	}
+
+	var lastErr error
+	for tentativa := 1; tentativa <= cfg.tentativas; tentativa++ {
+		entry, err := downloadFileOnce(url, out, cfg.timeout, anterior)
+		if err == nil {
+			fmt.Println("Download concluído:", url)
+			return entry, nil
+		}
+		if errors.Is(err, errNaoPublicado) || errors.Is(err, errNaoModificado) || !erroRetentavel(err) {
+			return entry, err
+		}
+		lastErr = err
+
+		if tentativa < cfg.tentativas {
+			espera := backoffComJitter(cfg, tentativa)
+			fmt.Printf("Erro ao baixar %s (tentativa %d/%d): %v; tentando de novo em %s\n",
+				url, tentativa, cfg.tentativas, err, espera.Round(time.Millisecond))
+			time.Sleep(espera)
+		}
+	}
+
+	return manifestEntry{URL: url}, fmt.Errorf("falha após %d tentativas: %w", cfg.tentativas, lastErr)
+}
+
+// downloadFileOnce faz uma única tentativa; out é esvaziado a cada chamada
+func downloadFileOnce(url string, out *spoolBuffer, timeout time.Duration, anterior *manifestEntry) (manifestEntry, error) {
+	entry := manifestEntry{URL: url}
+
+	if err := out.Reset(); err != nil {
+		return entry, err
 	}
-	defer f.Close()
 
-	err = requests.
-		URL(url).
-		ToWriter(f).
-		Fetch(context.Background())
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	rb := requests.URL(url).CheckStatus(http.StatusOK, http.StatusNotModified)
+	if anterior != nil {
+		rb.HeaderOptional("If-None-Match", anterior.ETag).
+			HeaderOptional("If-Modified-Since", anterior.LastModified)
+	}
+
+	hash := sha256.New()
+	cw := &contadorBytes{w: io.MultiWriter(out, hash)}
+	err := rb.
+		Handle(func(res *http.Response) error {
+			if res.StatusCode == http.StatusNotModified {
+				return errNaoModificado
+			}
+			entry.ETag = res.Header.Get("ETag")
+			entry.LastModified = res.Header.Get("Last-Modified")
+			_, err := io.Copy(cw, res.Body)
+			return err
+		}).
+		Fetch(ctx)
+	entry.Tamanho = cw.n
+	if requests.HasStatusErr(err, http.StatusNotFound) {
+		return entry, fmt.Errorf("%s: %w", url, errNaoPublicado)
+	}
 	if err != nil {
-		return err
+		return entry, err
 	}
 
-	fmt.Println("Download concluído:", output)
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	entry.BaixadoEm = time.Now()
+	// servidor sem suporte a requisição condicional, mas o conteúdo é o mesmo
+	if anterior != nil && anterior.SHA256 == entry.SHA256 {
+		return entry, errNaoModificado
+	}
 
-	return nil
+	return entry, nil
 }
 
-func unzip(src, dest string) error {
-	r, err := zip.OpenReader(src)
-	if err != nil {
-		return err
+// erroRetentavel diz se vale a pena tentar de novo: 5xx/429 e erros de rede sim,
+// outros status HTTP (403, 410...) não
+func erroRetentavel(err error) bool {
+	if requests.HasStatusErr(err, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout) {
+		return true
 	}
-	defer func() {
-		if err := r.Close(); err != nil {
-			panic(err)
-		}
-	}()
+	if se := new(requests.ResponseError); errors.As(err, &se) {
+		return false
+	}
+	// timeouts, conexões resetadas, EOF inesperado...
+	return true
+}
 
-	os.MkdirAll(dest, 0755)
+// backoffComJitter calcula a espera da tentativa n: backoffInicial * 2^(n-1),
+// limitada a backoffMax, com jitter aleatório de ±25% para os 12 workers não
+// baterem no portal ao mesmo tempo
+func backoffComJitter(cfg retryConfig, tentativa int) time.Duration {
+	espera := cfg.backoffInicial << (tentativa - 1)
+	if espera <= 0 || espera > cfg.backoffMax {
+		espera = cfg.backoffMax
+	}
+	return espera*3/4 + time.Duration(rand.Int63n(int64(espera)/2+1))
+}
 
-	// Closure to address file descriptors issue with all the deferred .Close() methods
-	extractAndWriteFile := func(f *zip.File) error {
-		rc, err := f.Open()
+// scratchDir é onde os downloads maiores que limiteMemoria são gravados
+// temporariamente ("" usa o diretório temporário do sistema)
+var scratchDir = ""
+
+// limiteMemoria é o tamanho até o qual um download fica só em memória
+var limiteMemoria int64 = 32 << 20
+
+// spoolBuffer guarda o download em memória e, passando de limite, despeja tudo
+// num arquivo temporário em dir. Close remove o arquivo temporário.
+type spoolBuffer struct {
+	dir    string
+	limite int64
+	mem    bytes.Buffer
+	f      *os.File
+	n      int64
+}
+
+func newSpoolBuffer() *spoolBuffer {
+	return &spoolBuffer{dir: scratchDir, limite: limiteMemoria}
+}
+
+func (s *spoolBuffer) Write(p []byte) (int, error) {
+	if s.f == nil && int64(s.mem.Len()+len(p)) > s.limite {
+		f, err := os.CreateTemp(s.dir, "dAndD-*.download")
 		if err != nil {
+			return 0, err
+		}
+		s.f = f
+		if _, err := s.mem.WriteTo(f); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if s.f != nil {
+		n, err = s.f.Write(p)
+	} else {
+		n, err = s.mem.Write(p)
+	}
+	s.n += int64(n)
+	return n, err
+}
+
+// Reset descarta o conteúdo (usado antes de cada nova tentativa)
+func (s *spoolBuffer) Reset() error {
+	s.mem.Reset()
+	s.n = 0
+	if s.f != nil {
+		if err := s.f.Truncate(0); err != nil {
 			return err
 		}
-		defer func() {
-			if err := rc.Close(); err != nil {
-				panic(err)
-			}
-		}()
+		if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// ReaderAt devolve o conteúdo baixado e o tamanho, para ler o zip sem copiar
+func (s *spoolBuffer) ReaderAt() (io.ReaderAt, int64) {
+	if s.f != nil {
+		return s.f, s.n
+	}
+	return bytes.NewReader(s.mem.Bytes()), s.n
+}
+
+// Close libera a memória e remove o arquivo temporário, se houver
+func (s *spoolBuffer) Close() error {
+	s.mem = bytes.Buffer{}
+	if s.f == nil {
+		return nil
+	}
+	name := s.f.Name()
+	errClose := s.f.Close()
+	s.f = nil
+	return errors.Join(errClose, os.Remove(name))
+}
+
+// extrairZip extrai os membros do zip direto em dest, cada um via arquivo
+// temporário + rename para nunca deixar um CSV pela metade, e devolve os nomes
+// dos arquivos extraídos (relativos a dest)
+func extrairZip(r *zip.Reader, dest string) ([]string, error) {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return nil, err
+	}
+
+	extractAndWriteFile := func(f *zip.File) (err error) {
 		path := filepath.Join(dest, f.Name)
 
 		// Check for ZipSlip (Directory traversal)
@@ -65,33 +258,33 @@ func unzip(src, dest string) error {
 		}
 
 		if f.FileInfo().IsDir() {
-			os.MkdirAll(path, f.Mode())
-		} else {
-			os.MkdirAll(filepath.Dir(path), f.Mode())
-			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-			if err != nil {
-				return err
-			}
-			defer func() {
-				if err := f.Close(); err != nil {
-					panic(err)
-				}
-			}()
-
-			_, err = io.Copy(f, rc)
-			if err != nil {
-				return err
-			}
+			return os.MkdirAll(path, 0755)
 		}
-		return nil
-	}
 
-	for _, f := range r.File {
-		err := extractAndWriteFile(f)
+		rc, err := f.Open()
 		if err != nil {
 			return err
 		}
+		defer func() {
+			err = errors.Join(err, rc.Close())
+		}()
+
+		perm := f.Mode().Perm()
+		if perm == 0 {
+			perm = 0644
+		}
+		return writeFileAtomic(path, rc, perm)
 	}
 
-	return nil
+	var membros []string
+	for _, f := range r.File {
+		if err := extractAndWriteFile(f); err != nil {
+			return membros, fmt.Errorf("erro ao extrair %s: %w", f.Name, err)
+		}
+		if !f.FileInfo().IsDir() {
+			membros = append(membros, f.Name)
+		}
+	}
+
+	return membros, nil
 }
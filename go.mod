@@ -6,13 +6,9 @@ toolchain go1.24.7
 
 require (
 	github.com/carlmjohnson/requests v0.24.3
-	github.com/go-gota/gota v0.12.0
 	github.com/joho/godotenv v1.5.1
 	github.com/lib/pq v1.10.9
 	golang.org/x/text v0.29.0
 )
 
-require (
-	golang.org/x/net v0.27.0 // indirect
-	gonum.org/v1/gonum v0.9.1 // indirect
-)
+require golang.org/x/net v0.27.0 // indirect
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// maxIdentificador é o limite do Postgres (NAMEDATALEN - 1); nomes maiores são truncados em silêncio
+const maxIdentificador = 63
+
+// nomeTabelaValido aceita só nomes simples, sem schema, aspas ou espaços
+var nomeTabelaValido = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// tipoSQLValido aceita os tipos gerados pela inferência e os declarados nos schemas (sql)
+var tipoSQLValido = regexp.MustCompile(`^[A-Z][A-Z ]*(\(\d+(,\d+)?\))?$`)
+
+// qi põe um identificador entre aspas duplas para usar em SQL
+func qi(nome string) string {
+	return pq.QuoteIdentifier(nome)
+}
+
+// qis aplica qi a uma lista e junta com vírgulas
+func qis(nomes []string) string {
+	out := make([]string, len(nomes))
+	for i, n := range nomes {
+		out[i] = qi(n)
+	}
+	return strings.Join(out, ", ")
+}
+
+// nomeTabela valida e normaliza (minúsculas) um nome de tabela vindo da CLI ou
+// do código. As tabelas antigas foram criadas sem aspas, ou seja, em
+// minúsculas; "cda_fi_BLC_1" continua indo para cda_fi_blc_1.
+func nomeTabela(nome string) (string, error) {
+	n := strings.ToLower(strings.TrimSpace(nome))
+	if !nomeTabelaValido.MatchString(n) {
+		return "", fmt.Errorf("nome de tabela inválido: %q (use letras, dígitos e _)", nome)
+	}
+	if len(n) > maxIdentificador {
+		return "", fmt.Errorf("nome de tabela %q passa de %d caracteres", nome, maxIdentificador)
+	}
+	return n, nil
+}
+
+// validarTipoSQL impede que um tipo configurado leve SQL junto
+func validarTipoSQL(tipo string) error {
+	if !tipoSQLValido.MatchString(strings.ToUpper(tipo)) {
+		return fmt.Errorf("tipo SQL inválido: %q", tipo)
+	}
+	return nil
+}
+
+// resolverNomesColunas limpa o cabeçalho (cleanColumnName) e resolve nomes
+// vazios e repetidos de forma determinística: vazio vira coluna_<posição> e
+// repetidos ganham _2, _3... na ordem do arquivo
+func resolverNomesColunas(header []string) []string {
+	nomes := make([]string, len(header))
+	usados := map[string]bool{}
+	for i, h := range header {
+		base := cleanColumnName(h)
+		if base == "" {
+			base = "coluna_" + strconv.Itoa(i+1)
+		}
+		base = truncarIdentificador(base, "")
+
+		nome := base
+		for n := 2; usados[nome]; n++ {
+			nome = truncarIdentificador(base, "_"+strconv.Itoa(n))
+		}
+		usados[nome] = true
+		nomes[i] = nome
+	}
+	return nomes
+}
+
+// truncarIdentificador corta base para que base+sufixo caiba em maxIdentificador
+func truncarIdentificador(base, sufixo string) string {
+	if len(base)+len(sufixo) > maxIdentificador {
+		base = base[:maxIdentificador-len(sufixo)]
+	}
+	return base + sufixo
+}
+
+// valorConexao escapa um valor da string de conexão do lib/pq (entre aspas simples)
+func valorConexao(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
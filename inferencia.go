@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// amostraInferencia limita quantas linhas a inferência de tipos examina
+// (--infer-sample); 0 lê o arquivo inteiro. Com amostra, as linhas examinadas
+// ficam espalhadas pelo arquivo, não só no começo.
+var amostraInferencia int
+
+// formatos de data e hora aceitos nos CSVs da CVM
+const (
+	layoutData        = "2006-01-02"
+	layoutDataBR      = "02/01/2006"
+	layoutTimestamp   = "2006-01-02 15:04:05"
+	layoutTimestampT  = "2006-01-02T15:04:05"
+	layoutTimestampBR = "02/01/2006 15:04:05"
+)
+
+// inferidorColuna acumula o que foi visto numa coluna, valor a valor
+type inferidorColuna struct {
+	validos    int
+	inteiro32  bool
+	inteiro64  bool
+	numerico   bool
+	digitosInt int // maior quantidade de dígitos antes da vírgula
+	escala     int // maior quantidade de dígitos depois da vírgula
+	booleano   bool
+	data       bool
+	timestamp  bool // data ou data e hora
+}
+
+func novoInferidorColuna() *inferidorColuna {
+	return &inferidorColuna{inteiro32: true, inteiro64: true, numerico: true, booleano: true, data: true, timestamp: true}
+}
+
+// ehNulo diz se o valor é tratado como NULL na carga (ver normalizarValor)
+func ehNulo(val string) bool {
+	switch strings.ToLower(val) {
+	case "", "null", "na", "nan", "n/a":
+		return true
+	}
+	return false
+}
+
+func (c *inferidorColuna) observar(val string) {
+	val = strings.TrimSpace(val)
+	if ehNulo(val) {
+		return
+	}
+	c.validos++
+
+	if c.booleano {
+		switch strings.ToUpper(val) {
+		case "S", "N":
+		default:
+			c.booleano = false
+		}
+	}
+	if c.data && !ehData(val) {
+		c.data = false
+	}
+	if c.timestamp && !ehData(val) && !ehTimestamp(val) {
+		c.timestamp = false
+	}
+
+	if !c.numerico {
+		return
+	}
+	intPart, fracPart, ok := partesNumero(val)
+	if !ok {
+		c.numerico, c.inteiro32, c.inteiro64 = false, false, false
+		return
+	}
+	c.digitosInt = max(c.digitosInt, len(intPart))
+	c.escala = max(c.escala, len(fracPart))
+	if fracPart != "" || strings.ContainsAny(val, ".,") {
+		c.inteiro32, c.inteiro64 = false, false
+		return
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		c.inteiro32, c.inteiro64 = false, false
+		return
+	}
+	if n > math.MaxInt32 || n < math.MinInt32 {
+		c.inteiro32 = false
+	}
+}
+
+// tipo devolve o tipo SQL mais estreito que comporta todos os valores vistos
+func (c *inferidorColuna) tipo() string {
+	switch {
+	case c.validos == 0:
+		return "TEXT"
+	case c.booleano:
+		return "BOOLEAN"
+	case c.data:
+		return "DATE"
+	case c.timestamp:
+		return "TIMESTAMP"
+	case c.inteiro32:
+		return "INTEGER"
+	case c.inteiro64:
+		return "BIGINT"
+	case c.numerico && c.digitosInt+c.escala <= 1000:
+		return fmt.Sprintf("NUMERIC(%d,%d)", max(1, c.digitosInt+c.escala), c.escala)
+	}
+	return "TEXT"
+}
+
+// partesNumero separa um número com '.' ou ',' decimal em parte inteira e
+// fracionária (sem sinal). Zeros à esquerda ("0012") indicam código, não número.
+func partesNumero(val string) (string, string, bool) {
+	val = strings.TrimPrefix(strings.TrimPrefix(val, "-"), "+")
+	if strings.Count(val, ".")+strings.Count(val, ",") > 1 {
+		return "", "", false
+	}
+	intPart, fracPart, _ := strings.Cut(strings.Replace(val, ",", ".", 1), ".")
+	if intPart == "" && fracPart == "" {
+		return "", "", false
+	}
+	for _, r := range intPart + fracPart {
+		if r < '0' || r > '9' {
+			return "", "", false
+		}
+	}
+	if len(intPart) > 1 && intPart[0] == '0' {
+		return "", "", false
+	}
+	intPart = strings.TrimLeft(intPart, "0")
+	return intPart, fracPart, true
+}
+
+func ehData(val string) bool {
+	if len(val) != 10 {
+		return false
+	}
+	_, err := time.Parse(layoutData, val)
+	if err != nil {
+		_, err = time.Parse(layoutDataBR, val)
+	}
+	return err == nil
+}
+
+func ehTimestamp(val string) bool {
+	for _, layout := range []string{layoutTimestamp, layoutTimestampT, layoutTimestampBR} {
+		if _, err := time.Parse(layout, val); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// inferirColunas lê o CSV padronizado (inteiro ou a amostra de amostraInferencia)
+// e devolve as colunas com o tipo inferido e quantas linhas foram examinadas
+func inferirColunas(csvFile string) ([]colunaTabela, int, error) {
+	f, reader, header, err := abrirCSVCarga(csvFile)
+	if err != nil {
+		return nil, 0, fmt.Errorf("erro ao abrir CSV: %w", err)
+	}
+	defer f.Close()
+
+	var tamanho int64
+	if info, err := f.Stat(); err == nil {
+		tamanho = info.Size()
+	}
+
+	inferidores := make([]*inferidorColuna, len(header))
+	for i := range inferidores {
+		inferidores[i] = novoInferidorColuna()
+	}
+
+	linha, examinadas, passo := 0, 0, 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, examinadas, fmt.Errorf("erro ao ler %s: %w", csvFile, err)
+		}
+		linha++
+		if linha%passo != 0 {
+			continue
+		}
+		examinadas++
+		for i, val := range record {
+			if i < len(inferidores) {
+				inferidores[i].observar(val)
+			}
+		}
+
+		// depois das primeiras linhas, estima quantas faltam pelo tamanho médio e
+		// espalha o restante da amostra pelo arquivo
+		if amostraInferencia > 0 && examinadas == amostraInferencia && passo == 1 {
+			lidos := reader.InputOffset()
+			if lidos <= 0 || tamanho <= lidos {
+				break
+			}
+			restantes := (tamanho - lidos) / (lidos / int64(linha))
+			passo = max(1, int(restantes/int64(amostraInferencia)))
+		} else if amostraInferencia > 0 && examinadas >= 2*amostraInferencia {
+			break
+		}
+	}
+
+	colunas := make([]colunaTabela, len(header))
+	for i, nome := range header {
+		tipo := inferidores[i].tipo()
+		// CNPJ é sempre texto, mesmo quando vem só com dígitos
+		if strings.HasPrefix(nome, "cnpj") {
+			tipo = "TEXT"
+		}
+		colunas[i] = colunaTabela{nome: nome, tipo: tipo, semAmostra: inferidores[i].validos == 0}
+	}
+	return colunas, examinadas, nil
+}
+
+// converterValor adapta um valor do CSV ao tipo da coluna na tabela: S/N para
+// booleano, datas DD/MM/AAAA para ISO e vírgula decimal para ponto
+func converterValor(tipo, val string) string {
+	tipo = normalizarTipo(tipo)
+	switch {
+	case tipo == "BOOLEAN":
+		switch strings.ToUpper(val) {
+		case "S":
+			return "true"
+		case "N":
+			return "false"
+		}
+	case tipo == "DATE" || tipo == "TIMESTAMP" || tipo == "TIMESTAMPTZ":
+		if t, err := time.Parse(layoutDataBR, val); err == nil {
+			return t.Format(layoutData)
+		}
+		if t, err := time.Parse(layoutTimestampBR, val); err == nil {
+			return t.Format(layoutTimestamp)
+		}
+	case tipo == "INTEGER" || tipo == "BIGINT" || strings.HasPrefix(tipo, "NUMERIC"):
+		if !strings.Contains(val, ".") {
+			return strings.Replace(val, ",", ".", 1)
+		}
+	}
+	return val
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestInferidorColuna(t *testing.T) {
+	casos := []struct {
+		nome    string
+		valores []string
+		tipo    string
+	}{
+		{"vazia", []string{"", "NA", "null"}, "TEXT"},
+		{"inteiro", []string{"1", "-20", "", "300"}, "INTEGER"},
+		{"inteiro grande", []string{"1", "2147483648"}, "BIGINT"},
+		{"decimal com vírgula", []string{"1,5", "123,25"}, "NUMERIC(5,2)"},
+		{"decimal e inteiro", []string{"10", "0.123456"}, "NUMERIC(8,6)"},
+		{"zeros à esquerda são código", []string{"0012", "13"}, "TEXT"},
+		{"S/N", []string{"S", "n", "N/A"}, "BOOLEAN"},
+		{"data ISO", []string{"2025-01-31", "2024-12-31"}, "DATE"},
+		{"data brasileira", []string{"31/01/2025"}, "DATE"},
+		{"data e hora", []string{"2025-01-31", "2025-01-31 10:00:00"}, "TIMESTAMP"},
+		{"texto", []string{"1", "FUNDO"}, "TEXT"},
+		{"dois separadores", []string{"1.234,56"}, "TEXT"},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			inf := novoInferidorColuna()
+			for _, v := range c.valores {
+				inf.observar(v)
+			}
+			if got := inf.tipo(); got != c.tipo {
+				t.Errorf("tipo de %q = %s, queria %s", c.valores, got, c.tipo)
+			}
+		})
+	}
+}
+
+func TestConverterValor(t *testing.T) {
+	casos := []struct {
+		tipo, valor, saida string
+	}{
+		{"BOOLEAN", "S", "true"},
+		{"boolean", "n", "false"},
+		{"DATE", "31/01/2025", "2025-01-31"},
+		{"DATE", "2025-01-31", "2025-01-31"},
+		{"TIMESTAMP", "31/01/2025 10:30:00", "2025-01-31 10:30:00"},
+		{"NUMERIC(10,2)", "1234,56", "1234.56"},
+		{"numeric", "1234.56", "1234.56"},
+		{"TEXT", "1234,56", "1234,56"},
+	}
+	for _, c := range casos {
+		if got := converterValor(c.tipo, c.valor); got != c.saida {
+			t.Errorf("converterValor(%q, %q) = %q, queria %q", c.tipo, c.valor, got, c.saida)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// loadTarget é um par tabela/arquivo a ser importado no banco
+type loadTarget struct {
+	table string
+	file  string
+}
+
+// loader mantém um pool de conexões aberto para várias cargas seguidas
+type loader struct {
+	db      *sql.DB
+	workers int // tabelas carregadas ao mesmo tempo
+
+	proveniencia bool   // acrescenta _source_file, _source_sha256, _loaded_at e _pipeline_run_id
+	runID        string // identifica a execução em etl_load_log e em _pipeline_run_id
+
+	chavesEstrangeiras bool            // liga cnpj_fundo_classe dos informes a cadastro_fundos
+	cadastro           map[string]bool // CNPJs de cadastro_fundos, lidos por prepararCadastro
+}
+
+// newLoader lê o .env, cria o banco se preciso e abre o pool (uma vez só)
+func newLoader(workers int) (*loader, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	godotenv.Load(".env")
+
+	host := os.Getenv("HOST")
+	port := os.Getenv("PORT")
+	user := os.Getenv("USER")
+	password := os.Getenv("PASSWORD")
+	dbName := os.Getenv("DATABASE")
+
+	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		valorConexao(host), valorConexao(port), valorConexao(user), valorConexao(password), valorConexao(dbName))
+	// 1. Cria o banco de dados se não existir
+	if err := createDatabase(connStr, dbName); err != nil {
+		return nil, err
+	}
+
+	// 2. Conecta ao banco criado
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+	// cada worker usa uma conexão por vez; sobra uma para consultas fora da carga
+	db.SetMaxOpenConns(workers + 1)
+	db.SetMaxIdleConns(workers + 1)
+	db.SetConnMaxLifetime(30 * time.Minute)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("erro ao conectar em %s: %w", dbName, err)
+	}
+	if err := criarTabelaMigracoes(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := criarTabelaLogCarga(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &loader{db: db, workers: workers, runID: novoRunID()}, nil
+}
+
+func (l *loader) Close() error {
+	return l.db.Close()
+}
+
+// carregar cria ou ajusta a tabela e importa o CSV conforme o modo de carga da
+// tabela; toda carga, com sucesso ou não, fica registrada em etl_load_log
+func (l *loader) carregar(tableName, csvFile string) error {
+	tableName, err := nomeTabela(tableName)
+	if err != nil {
+		return err
+	}
+	cfg := configCargaPara(tableName)
+	cfg.cadastro = l.cadastro
+	registro := registroCarga{tabela: tableName, arquivo: csvFile, modo: cfg.modo,
+		inicio: time.Now(), runID: l.runID}
+	// a linhagem traz as rejeitadas na padronização mesmo quando o arquivo
+	// carregado é derivado (ex: snapshots do inf_diario)
+	if lin, ok := lerLinhagem(csvFile); ok {
+		registro.rejeitadas = lin.Rejeitadas
+	} else {
+		registro.rejeitadas = linhasRejeitadas(csvFile)
+	}
+
+	var recusadas int
+	registro.linhas, registro.sha256, recusadas, registro.err = l.importar(tableName, csvFile, cfg)
+	registro.rejeitadas += recusadas
+	if err := registrarCarga(l.db, registro); err != nil {
+		fmt.Printf("Erro ao registrar a carga de %s em %s: %v\n", csvFile, tabelaLogCarga, err)
+	}
+	if registro.err != nil {
+		return fmt.Errorf("%s → %s: %w", csvFile, tableName, registro.err)
+	}
+
+	fmt.Printf("✓ %s carregado em '%s'\n", csvFile, tableName)
+	return nil
+}
+
+// importar carrega o arquivo e devolve linhas importadas, SHA-256 e linhas
+// recusadas na carga, gravadas em _rejects/<arquivo>_carga.jsonl
+func (l *loader) importar(tableName, csvFile string, cfg configCarga) (int, string, int, error) {
+	sha, err := sha256Arquivo(csvFile)
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	var prov *proveniencia
+	var extras []colunaTabela
+	if l.proveniencia {
+		// _source_* descrevem o download da CVM; sem linhagem (ou fora do
+		// manifesto), o próprio arquivo carregado
+		prov = &proveniencia{arquivo: csvFile, sha256: sha, carregadoEm: time.Now(), runID: l.runID}
+		if lin, ok := lerLinhagem(csvFile); ok {
+			if url, shaOrigem, ok := lin.download(); ok {
+				prov.arquivo, prov.sha256 = url, shaOrigem
+			}
+		}
+		extras = colunasProveniencia
+	}
+
+	// 3. Cria ou ajusta a tabela baseada no CSV
+	var tipos map[string]string
+	cfg.cnpjChave = "cnpj_fundo_classe"
+	if cfg.schema != "" {
+		schema, err := carregarSchema(cfg.schema)
+		if err != nil {
+			return 0, sha, 0, err
+		}
+		tipos = schema.tiposSQL(csvFile)
+		cfg.cnpjChave = schema.cnpjChave(csvFile)
+	}
+	if err := createTableFromCSV(l.db, csvFile, tableName, tipos, extras); err != nil {
+		return 0, sha, 0, err
+	}
+	if cfg.cadastro != nil {
+		if cfg.cadastro, err = l.chaveEstrangeira(tableName); err != nil {
+			return 0, sha, 0, err
+		}
+	}
+
+	// 4. Importa os dados, conforme o modo de carga da tabela
+	n, rejeitos, err := importCSV(l.db, csvFile, tableName, cfg, prov)
+	if err != nil {
+		return n, sha, 0, err
+	}
+	if err := gravarQuarentena(caminhoRejeitosCarga(csvFile), rejeitos); err != nil {
+		fmt.Printf("Erro ao gravar os rejeitos da carga de %s: %v\n", csvFile, err)
+	}
+	return n, sha, contarRejeitadas(rejeitos), nil
+}
+
+// carregarVarios importa os arquivos com no máximo l.workers tabelas em paralelo.
+// Arquivos da mesma tabela vão em sequência, na ordem recebida, porque o ALTER
+// TABLE de um não pode concorrer com a carga do outro. Um arquivo com erro não
+// interrompe os demais; os erros voltam juntos no final.
+func (l *loader) carregarVarios(alvos []loadTarget) error {
+	var erros errosConcorrentes
+	var tabelas []string
+	porTabela := map[string][]string{}
+	for _, a := range alvos {
+		// "cda_fi_BLC_1" e "cda_fi_blc_1" são a mesma tabela e não podem rodar em paralelo
+		tabela, err := nomeTabela(a.table)
+		if err != nil {
+			erros.add(err)
+			continue
+		}
+		if _, ok := porTabela[tabela]; !ok {
+			tabelas = append(tabelas, tabela)
+		}
+		porTabela[tabela] = append(porTabela[tabela], a.file)
+	}
+
+	if l.chavesEstrangeiras {
+		if err := l.prepararCadastro(); err != nil {
+			return err
+		}
+	}
+
+	sem := make(chan struct{}, l.workers)
+	var wg sync.WaitGroup
+	for _, tabela := range tabelas {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(tabela string, arquivos []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			for _, arquivo := range arquivos {
+				if err := l.carregar(tabela, arquivo); err != nil {
+					fmt.Println("Erro na carga:", err)
+					erros.add(err)
+				}
+			}
+		}(tabela, porTabela[tabela])
+	}
+
+	wg.Wait()
+	return erros.err()
+}
+
+// prepararCadastro sincroniza cadastro_fundos com os cadastros padronizados e
+// guarda os CNPJs para filtrar as cargas (--foreign-keys)
+func (l *loader) prepararCadastro() error {
+	fundos, err := lerCadastroFundos()
+	if err != nil {
+		return err
+	}
+	if err := sincronizarCadastroFundos(l.db, fundos); err != nil {
+		return err
+	}
+	l.cadastro = make(map[string]bool, len(fundos))
+	for c := range fundos {
+		l.cadastro[c] = true
+	}
+	return nil
+}
+
+// chaveEstrangeira cria a chave estrangeira nas tabelas de informe e devolve o
+// cadastro para filtrar a carga; cadastros e tabelas sem cnpj_fundo_classe ficam
+// de fora (nil)
+func (l *loader) chaveEstrangeira(tableName string) (map[string]bool, error) {
+	if strings.HasPrefix(tableName, "cadastro_") || strings.HasPrefix(tableName, "registro_") {
+		return nil, nil
+	}
+	colunas, err := colunasDaTabela(l.db, tableName)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := colunas["cnpj_fundo_classe"]; !ok {
+		return nil, nil
+	}
+	if err := garantirChaveEstrangeira(l.db, tableName); err != nil {
+		return nil, err
+	}
+	return l.cadastro, nil
+}
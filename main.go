@@ -1,196 +1,506 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const usoCLI = `Uso: dAndD <comando> [opções]
+
+Comandos:
+  download       baixa e descompacta os arquivos da CVM
+  padronize      padroniza os CSVs baixados
+  pick-last-day  seleciona o último dia útil de cada mês do inf_diario padronizado
+  snapshot       seleciona o primeiro ou último dia útil de cada semana, mês, trimestre ou ano do inf_diario
+  quality        verifica as regras de qualidade dos CSVs a carregar (relatório em _qualidade/)
+  reconcile      compara os CNPJs dos informes com o cadastro de fundos (relatório em csvs/_reconciliacao/)
+  metrics        calcula rentabilidade, risco e captação líquida por fundo (csvs/fund_metrics/)
+  load           importa os CSVs padronizados no banco
+  pipeline       download + padronize + pick-last-day + quality + reconcile + load
+  menu           menu numerado original (--opcao N executa sem prompt)
+
+Opções comuns:
+  --dataset     lista separada por vírgula (inf_diario, lamina, cda, fidc, fip, fii, cad_fi, cad_adm_fii, registro_fi, fund_metrics)
+  --years       anos, ex: 2021,2022 ou 2019-2025 (padrão: ano atual)
+  --months      meses, ex: 1-12 ou 9,10 (padrão: 1-12)
+  --table       nome da tabela de destino (load) ou de origem, diária (metrics --source db)
+  --mode        modo de carga: append, truncate ou replace (padrão: o de cada tabela)
+  --load-workers tabelas carregadas em paralelo (padrão: 4)
+  --infer-sample linhas examinadas na inferência de tipos, espalhadas pelo arquivo (padrão: 0 = todas)
+  --provenance  acrescenta _source_file, _source_sha256, _loaded_at e _pipeline_run_id em cada linha
+  --run-id      identificador da execução em etl_load_log (padrão: gerado)
+  --foreign-keys liga cnpj_fundo_classe dos informes a cadastro_fundos; linhas sem cadastro vão para os rejeitos
+  --file        CSV específico a importar (load, exige --table)
+  --period      período do snapshot: week, month, quarter ou year (padrão: month)
+  --position    dia do período no snapshot: first ou last (padrão: last)
+  --previous    acrescenta DT_COMPTC_ANTERIOR e VL_QUOTA_ANTERIOR do período anterior (snapshot)
+  --source      origem do metrics: files (inf_diario padronizado) ou db (padrão: files)
+  --hist        baixa os arquivos anuais da pasta HIST (download; erro nos datasets sem HIST)
+  --max-rejects falha a padronização se um arquivo passar desse número de linhas rejeitadas
+  --quality-threshold falha se um arquivo passar desse percentual de linhas com erro de qualidade
+  --scratch-dir diretório dos downloads temporários (padrão: temp do sistema)
+  --force       ignora o manifesto (csvs/_manifest.json) e baixa tudo de novo
+  --retries     tentativas por arquivo em erros 5xx/timeout (padrão: 5)
+  --timeout     tempo máximo de cada requisição (padrão: 10m)
+`
+
 func main() {
-	fmt.Println("Selecione uma opção:")
-	fmt.Println("1 - Iniciar downloads e descompactação")
-	fmt.Println("2 - Organizar inf_diario e selecionar último dia de cada mês")
-	fmt.Println("3 - Ligar pesquisa de info de fundos na porta 8080")
-	fmt.Println("4 - Iniciar downloads e descompactação FIDC")
-	fmt.Println("5 - Organizaar FIDC's")
-	fmt.Println("6 - Organizar inf_diario com goroutines (versão melhorada)")
-	fmt.Println("7 - Iniciar servidor com dados de AdmFii na porta 8080")
-	fmt.Print("Digite 1, 2, 3, 4, 5, 6 ou 7: ")
-
-	var escolha int
-	_, err := fmt.Scan(&escolha)
-	if err != nil {
-		fmt.Println("Erro ao ler opção:", err)
+	// sem argumentos mantém o comportamento antigo (menu interativo)
+	if len(os.Args) < 2 {
+		if err := runMenu(-1); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	for {
-		switch escolha {
-		// ...existing code...
-		case 1:
-			runDownloads([]int{2021, 2022, 2023, 2024, 2025}, []string{"inf_diario"}, false)
-			fmt.Println("Informes diários baixados com sucesso.")
-			runDownloads([]int{2019, 2020, 2021, 2022, 2023, 2024, 2025}, []string{"lamina"}, false)
-			fmt.Println("Lâminas baixadas com sucesso.")
-		case 2:
-			runDownloads([]int{2025}, []string{"inf_diario"}, false)
-			fmt.Println("Informes diários baixados com sucesso.")
-			csvPadronizationInfDiario([]int{2025}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
-			fmt.Println("Inf_diario organizado com sucesso!")
-			pickLastDayOfMonthInfDiario([]int{2025}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
-			fmt.Println("Último dia de cada mês selecionado com sucesso!")
-
-			for anoMes := 202509; anoMes >= 202501; {
-				database("inf_diario_ultimos_dias", fmt.Sprintf("csvs/inf_diario_ultimos_dias/inf_diario_fi_%d.csv", anoMes))
-				// decrementa anoMes corretamente
-				mes := anoMes % 100
-				ano := anoMes / 100
-				if mes == 1 {
-					ano--
-					mes = 12
-				} else {
-					mes--
-				}
-				anoMes = ano*100 + mes
+	if err := runCLI(os.Args[1], os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Erro:", err)
+		os.Exit(1)
+	}
+}
+
+// cliOptions agrupa as flags compartilhadas pelos subcomandos
+type cliOptions struct {
+	datasets  []string
+	anos      []int
+	meses     []int
+	table     string
+	file      string
+	historico bool
+
+	maxRejeitadas      int     // -1 desliga o limite
+	limiteQualidade    float64 // percentual de linhas com erro; negativo desliga o limite
+	loadWorkers        int     // tabelas carregadas em paralelo
+	proveniencia       bool
+	runID              string
+	chavesEstrangeiras bool
+	snapshot           configSnapshot
+	origemMetricas     string // files ou db
+}
+
+func runCLI(comando string, args []string) error {
+	if comando == "menu" {
+		fs := flag.NewFlagSet("menu", flag.ContinueOnError)
+		opcao := fs.Int("opcao", -1, "opção do menu a executar sem prompt")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		return runMenu(*opcao)
+	}
+
+	if comando == "-h" || comando == "--help" || comando == "help" {
+		fmt.Print(usoCLI)
+		return nil
+	}
+
+	opts, err := parseCLIOptions(comando, args)
+	if err != nil {
+		return err
+	}
+
+	switch comando {
+	case "download":
+		return cliDownload(opts)
+	case "padronize":
+		return cliPadronize(opts)
+	case "pick-last-day":
+		return pickLastDayOfMonthInfDiario(opts.anos, opts.meses)
+	case "snapshot":
+		return gerarSnapshots(opts.snapshot, opts.anos, opts.meses)
+	case "quality":
+		return cliQualidade(opts, true)
+	case "reconcile":
+		return cliReconciliar(opts)
+	case "metrics":
+		return cliMetricas(opts)
+	case "load":
+		return cliLoad(opts)
+	case "pipeline":
+		return cliPipeline(opts)
+	default:
+		fmt.Print(usoCLI)
+		return fmt.Errorf("comando desconhecido: %s", comando)
+	}
+}
+
+func parseCLIOptions(comando string, args []string) (cliOptions, error) {
+	var opts cliOptions
+
+	fs := flag.NewFlagSet(comando, flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), usoCLI) }
+	dataset := fs.String("dataset", "inf_diario", "datasets separados por vírgula")
+	years := fs.String("years", strconv.Itoa(time.Now().Year()), "anos (ex: 2021,2022 ou 2019-2025)")
+	months := fs.String("months", "1-12", "meses (ex: 1-12 ou 9,10)")
+	fs.StringVar(&opts.table, "table", "", "tabela de destino (load)")
+	fs.StringVar(&opts.file, "file", "", "CSV específico a importar (load)")
+	period := fs.String("period", string(periodoMes), "período do snapshot: week, month, quarter ou year")
+	position := fs.String("position", string(posicaoUltimo), "dia do período no snapshot: first ou last")
+	previous := fs.Bool("previous", false, "acrescenta os valores do período anterior ao snapshot")
+	fs.StringVar(&opts.origemMetricas, "source", "files", "origem do metrics: files ou db")
+	mode := fs.String("mode", "", "modo de carga: append, truncate ou replace")
+	fs.IntVar(&opts.loadWorkers, "load-workers", 4, "tabelas carregadas em paralelo (load)")
+	fs.IntVar(&amostraInferencia, "infer-sample", 0, "linhas examinadas na inferência de tipos (0 = arquivo inteiro)")
+	fs.BoolVar(&opts.proveniencia, "provenance", false, "acrescenta colunas de proveniência (_source_file, _loaded_at...) em cada linha")
+	fs.StringVar(&opts.runID, "run-id", "", "identificador da execução em etl_load_log (padrão: gerado)")
+	fs.BoolVar(&opts.chavesEstrangeiras, "foreign-keys", false, "cria chaves estrangeiras de cnpj_fundo_classe para cadastro_fundos (load)")
+	fs.BoolVar(&opts.historico, "hist", false, "baixa os arquivos anuais da pasta HIST")
+	fs.IntVar(&opts.maxRejeitadas, "max-rejects", -1, "falha se algum arquivo tiver mais linhas rejeitadas que isso")
+	fs.Float64Var(&opts.limiteQualidade, "quality-threshold", -1, "falha se algum arquivo passar desse percentual de linhas com erro de qualidade")
+	fs.StringVar(&scratchDir, "scratch-dir", scratchDir, "diretório para os downloads temporários")
+	fs.BoolVar(&forceDownload, "force", false, "ignora o manifesto e baixa tudo de novo")
+	fs.IntVar(&downloadRetry.tentativas, "retries", downloadRetry.tentativas, "tentativas por arquivo em erros 5xx/timeout")
+	fs.DurationVar(&downloadRetry.timeout, "timeout", downloadRetry.timeout, "tempo máximo de cada requisição")
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+
+	for _, d := range strings.Split(*dataset, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			opts.datasets = append(opts.datasets, d)
+		}
+	}
+	if len(opts.datasets) == 0 {
+		return opts, fmt.Errorf("--dataset não pode ser vazio")
+	}
+
+	var err error
+	if modoCargaForcado, err = parseModoCarga(*mode); err != nil {
+		return opts, err
+	}
+	if opts.table != "" {
+		if opts.table, err = nomeTabela(opts.table); err != nil {
+			return opts, fmt.Errorf("--table inválido: %w", err)
+		}
+	}
+	if opts.snapshot, err = novoConfigSnapshot(*period, *position, *previous); err != nil {
+		return opts, err
+	}
+	if opts.origemMetricas != "files" && opts.origemMetricas != "db" {
+		return opts, fmt.Errorf("--source inválido: %s (use files ou db)", opts.origemMetricas)
+	}
+	if opts.anos, err = parseIntList(*years); err != nil {
+		return opts, fmt.Errorf("--years inválido: %w", err)
+	}
+	if opts.meses, err = parseIntList(*months); err != nil {
+		return opts, fmt.Errorf("--months inválido: %w", err)
+	}
+	for _, mes := range opts.meses {
+		if mes < 1 || mes > 12 {
+			return opts, fmt.Errorf("--months inválido: mês %d fora de 1-12", mes)
+		}
+	}
+
+	return opts, nil
+}
+
+// parseIntList aceita "2021,2022", "2019-2025" ou combinações como "2019-2021,2025"
+func parseIntList(s string) ([]int, error) {
+	var out []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if ini, fim, ok := strings.Cut(part, "-"); ok {
+			a, err := strconv.Atoi(strings.TrimSpace(ini))
+			if err != nil {
+				return nil, err
 			}
-		case 3:
-			runDownloads([]int{2005, 2006, 2007, 2008, 2009, 2010, 2011, 2012, 2013, 2014, 2015, 2016, 2017, 2018}, []string{"inf_diario"}, true)
-			fmt.Println("Download Inf_diario realizado com sucesso!")
-			csvPadronizationInfDiario([]int{2005, 2006, 2007, 2008, 2009, 2010, 2011, 2012, 2013, 2014, 2015, 2016, 2017, 2018, 2019, 2020}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
-			fmt.Println("Inf_diario organizado com sucesso!")
-			pickLastDayOfMonthInfDiario([]int{2005, 2006, 2007, 2008, 2009, 2010, 2011, 2012, 2013, 2014, 2015, 2016, 2017, 2018, 2019, 2020}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
-			fmt.Println("Último dia de cada mês selecionado com sucesso!")
-		case 4:
-			runDownloadsFIDC([]int{2021, 2022, 2023, 2024, 2025}, []string{"fidc"})
-			fmt.Println("FIDC's baixados com sucesso.")
-		case 5:
-			csvPadronizationFidc([]string{"_IV_", "_X_1_", "_X_2_", "_X_3_"}, []int{2021, 2022, 2023, 2024, 2025}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
-			fmt.Println("FIDC's padronizados com sucesso.")
-		case 6:
-			err := csvPadronizationLamina(
-				[]string{"_", "_carteira_", "_rentab_ano_", "_rentab_mes_"},
-				[]int{2021, 2022, 2023, 2024, 2025},
-				[]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
-			)
+			b, err := strconv.Atoi(strings.TrimSpace(fim))
 			if err != nil {
-				fmt.Println("Erro ao organizar inf_diario (versão melhorada):", err)
+				return nil, err
 			}
-			fmt.Println("Inf_diario organizado com sucesso (versão melhorada)!")
-		case 7:
-			//startServerAdmFii()
-		case 8:
-			csvPadronizationFip([]string{"fip"}, []int{2019, 2020, 2021, 2022, 2023, 2024, 2025})
-			fmt.Println("FIP's padronizados com sucesso.")
-		case 9:
-			runDownloadsFIP([]int{2019, 2020, 2021, 2022, 2023, 2024, 2025}, []string{"fip"})
-			fmt.Println("FIP's baixados com sucesso.")
-		case 10:
-
-		case 11:
-			downloadCsvDescompactado([]string{"adm_fii"}, "cad")
-			fmt.Println("Cadastro de administradores de FII baixados com sucesso.")
-			simpleCsvPadronization([]string{"adm_fii"}, []string{""}, "cad", "")
-			fmt.Println("Cadastro de administradores de FII padronizados com sucesso.")
-		case 12:
-			downloadCsvDescompactado([]string{"fi"}, "cad")
-			fmt.Println("Cadastro de informações de fundos baixados com sucesso.")
-			simpleCsvPadronization([]string{"fi"}, []string{""}, "cad", "")
-			fmt.Println("Cadastro de informações de fundos padronizados com sucesso.")
-		case 13:
-			downloadCsvCompactado([]string{"fi"}, "cad", "registro_fundo_classe")
-			fmt.Println("Cadastro de informações de fundos (registro_fundo_classe) baixados com sucesso.")
-			simpleCsvPadronization([]string{"fi"}, []string{"classe", "fundo", "subclasse"}, "cad", "registro")
-		case 14:
-			runDownloads([]int{2023, 2024, 2025}, []string{"cda"}, false)
-		case 15:
-			csvPadronizationCda()
-		case 16:
-			// ex:
-			// tableName := "cadastro_adm_fii"
-			// csvFile := "adm_fii_padronized/cad_adm_fii.csv"
-			// database("cadastro_adm_fii", "adm_fii_padronized/cad_adm_fii.csv")
-			// database("cadastro_fi", "fi_padronized/cad_fi.csv")
-			//database("cadastro_adm_fii", "adm_fii_padronized/cad_adm_fii.csv")
-			//database("registro_classe", "fi_padronized/registro_classe.csv")
-			//database("registro_fundo", "csvs/fi_padronized/registro_fundo.csv")
-			// Adiciona todos os arquivos de csvs/cda_padronized no banco, nomeando pelo prefixo do arquivo
-			prefixos := []string{
-				// "cda_fi_BLC_1",
-				// "cda_fi_BLC_2",
-				// "cda_fi_BLC_3",
-				// "cda_fi_BLC_4",
-				// "cda_fi_BLC_5",
-				// "cda_fi_BLC_6",
-				"cda_fi_BLC_7",
-				"cda_fi_BLC_8",
-				"cda_fi_PL",
-				"cda_fiim",
+			if a > b {
+				return nil, fmt.Errorf("intervalo invertido: %s", part)
 			}
-			for _, prefixo := range prefixos {
-				for ano := 2025; ano <= 2025; ano++ {
-					for mes := 8; mes <= 8; mes++ {
-						anoMes := fmt.Sprintf("%04d%02d", ano, mes)
-						arquivos := []string{
-							fmt.Sprintf("csvs/cda_padronized/%s_%s.csv", prefixo, anoMes),
-						}
-						for _, arquivo := range arquivos {
-							// Extrai o nome do banco do prefixo do arquivo
-							var tableName string
-							if idx := len("csvs/cda_padronized/"); len(arquivo) > idx {
-								rest := arquivo[idx:]
-								if i := len(rest); i > 0 {
-									// pega até o primeiro "_AAAA" (ano)
-									for j := 0; j < i; j++ {
-										if rest[j] == '_' && j+5 < i && rest[j+1] >= '0' && rest[j+1] <= '9' {
-											tableName = rest[:j]
-											break
-										}
-									}
-								}
-							}
-							if tableName != "" {
-								database(prefixo, arquivo)
-							}
-						}
-					}
-				}
+			for v := a; v <= b; v++ {
+				out = append(out, v)
 			}
-			//database("registro_subclasse", "fi_padronized/registro_subclasse.csv")
-			//database("lamina_rentab_ano", "lamina_padronized/lamina_fi_rentab_ano_202508.csv")
-
-			// for anoMes := 202508; anoMes >= 202101; {
-			// 	database("inf_diario_ultimos_dias", fmt.Sprintf("inf_diario_ultimos_dias/inf_diario_fi_%d.csv", anoMes))
-			// 	// decrementa anoMes corretamente
-			// 	mes := anoMes % 100
-			// 	ano := anoMes / 100
-			// 	if mes == 1 {
-			// 		ano--
-			// 		mes = 12
-			// 	} else {
-			// 		mes--
-			// 	}
-			// 	anoMes = ano*100 + mes
-			// }
-		case 17:
-			// database("cadastro_adm_fii", "csvs/adm_fii_padronized/cad_adm_fii.csv")
-		case 0:
-			fmt.Println("Saindo...")
-			return
+			continue
+		}
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("lista vazia")
+	}
+	return out, nil
+}
+
+func cliDownload(opts cliOptions) error {
+	for _, dataset := range opts.datasets {
+		report, err := runDownloadsDataset(dataset, opts.anos, opts.meses, opts.historico)
+		if err != nil {
+			return err
+		}
+		if err := report.err(); err != nil {
+			return fmt.Errorf("%d downloads de %s falharam:\n%w", len(report.falhas()), dataset, err)
+		}
+		fmt.Printf("Download de %s concluído.\n", dataset)
+	}
+	return nil
+}
+
+func cliPadronize(opts cliOptions) error {
+	for _, dataset := range opts.datasets {
+		var relatorio relatorioPadronizacao
+		var err error
+		switch dataset {
+		case "inf_diario":
+			relatorio, err = csvPadronizationInfDiario(opts.anos, opts.meses)
+		case "lamina":
+			relatorio, err = csvPadronizationLamina([]string{"_", "_carteira_", "_rentab_ano_", "_rentab_mes_"}, opts.anos, opts.meses)
+		case "cda":
+			relatorio, err = csvPadronizationCda(opts.anos, opts.meses)
+		case "fidc":
+			relatorio, err = csvPadronizationFidc([]string{"_IV_", "_X_1_", "_X_2_", "_X_3_"}, opts.anos, opts.meses)
+		case "fip":
+			relatorio, err = csvPadronizationFip([]string{"fip"}, opts.anos)
+		case "cad_fi":
+			relatorio, err = simpleCsvPadronization([]string{"fi"}, []string{""}, "cad", "")
+		case "cad_adm_fii":
+			relatorio, err = simpleCsvPadronization([]string{"adm_fii"}, []string{""}, "cad", "")
+		case "registro_fi":
+			relatorio, err = simpleCsvPadronization([]string{"fi"}, []string{"classe", "fundo", "subclasse"}, "cad", "registro")
 		default:
-			fmt.Println("Opção inválida.")
+			return fmt.Errorf("dataset desconhecido: %s", dataset)
+		}
+		if err != nil {
+			return fmt.Errorf("erro ao padronizar %s: %w", dataset, err)
+		}
+		if opts.maxRejeitadas >= 0 {
+			if acima := relatorio.acimaDoLimite(opts.maxRejeitadas); len(acima) > 0 {
+				acima.imprimir()
+				return fmt.Errorf("%d arquivos de %s com mais de %d linhas rejeitadas", len(acima), dataset, opts.maxRejeitadas)
+			}
+		}
+		fmt.Printf("%s padronizado com sucesso.\n", dataset)
+	}
+	return nil
+}
+
+// loadTargets resolve quais CSVs padronizados de um dataset devem ser importados
+func loadTargets(dataset string, opts cliOptions) ([]loadTarget, error) {
+	var targets []loadTarget
+	table := func(def string) string {
+		if opts.table != "" {
+			return opts.table
 		}
+		return def
+	}
 
-		fmt.Println("\nSelecione uma opção:")
-		fmt.Println("1 - Iniciar downloads e descompactação")
-		fmt.Println("2 - Organizar inf_diario e selecionar último dia de cada mês")
-		fmt.Println("3 - Ligar pesquisa de info de fundos na porta 8080")
-		fmt.Println("4 - Iniciar downloads e descompactação FIDC")
-		fmt.Println("5 - Organizaar FIDC's")
-		fmt.Println("0 - Sair")
-		fmt.Print("Digite 1, 2, 3, 4, 5 ou 0: ")
+	switch dataset {
+	case "inf_diario":
+		for _, ano := range opts.anos {
+			for _, mes := range opts.meses {
+				targets = append(targets, loadTarget{table("inf_diario_ultimos_dias"),
+					fmt.Sprintf("csvs/inf_diario_ultimos_dias/inf_diario_fi_%d%02d.csv", ano, mes)})
+			}
+		}
+	case "lamina":
+		for _, tab := range []string{"_", "_carteira_", "_rentab_ano_", "_rentab_mes_"} {
+			nome := "lamina" + strings.TrimSuffix(tab, "_")
+			for _, ano := range opts.anos {
+				for _, mes := range opts.meses {
+					targets = append(targets, loadTarget{table(nome),
+						fmt.Sprintf("csvs/lamina_padronized/lamina_fi%s%d%02d.csv", tab, ano, mes)})
+				}
+			}
+		}
+	case "cda":
+		prefixos := []string{"cda_fi_BLC_1", "cda_fi_BLC_2", "cda_fi_BLC_3", "cda_fi_BLC_4",
+			"cda_fi_BLC_5", "cda_fi_BLC_6", "cda_fi_BLC_7", "cda_fi_BLC_8", "cda_fi_PL", "cda_fiim"}
+		for _, prefixo := range prefixos {
+			for _, ano := range opts.anos {
+				for _, mes := range opts.meses {
+					targets = append(targets, loadTarget{table(prefixo),
+						fmt.Sprintf("csvs/cda_padronized/%s_%d%02d.csv", prefixo, ano, mes)})
+				}
+			}
+		}
+	case "fidc":
+		for _, tab := range []string{"_IV_", "_X_1_", "_X_2_", "_X_3_"} {
+			nome := "fidc_tab" + strings.ToLower(strings.TrimSuffix(tab, "_"))
+			for _, ano := range opts.anos {
+				for _, mes := range opts.meses {
+					targets = append(targets, loadTarget{table(nome),
+						fmt.Sprintf("csvs/fidc_padronized/inf_mensal_fidc_tab%s%d%02d.csv", tab, ano, mes)})
+				}
+			}
+		}
+	case "fip":
+		for _, ano := range opts.anos {
+			targets = append(targets, loadTarget{table("fip"),
+				fmt.Sprintf("csvs/fip_padronized/inf_tri_quadri_fip_%d_.csv", ano)})
+		}
+	case "fund_metrics":
+		for _, ano := range opts.anos {
+			for _, mes := range opts.meses {
+				targets = append(targets, loadTarget{table("fund_metrics"),
+					filepath.Join(dirMetricas, fmt.Sprintf("fund_metrics_%d%02d.csv", ano, mes))})
+			}
+		}
+	case "cad_fi":
+		targets = append(targets, loadTarget{table("cadastro_fi"), "csvs/fi_padronized/cad_fi.csv"})
+	case "cad_adm_fii":
+		targets = append(targets, loadTarget{table("cadastro_adm_fii"), "csvs/adm_fii_padronized/cad_adm_fii.csv"})
+	case "registro_fi":
+		for _, aux := range []string{"classe", "fundo", "subclasse"} {
+			targets = append(targets, loadTarget{table("registro_" + aux),
+				fmt.Sprintf("csvs/fi_padronized/registro_%s.csv", aux)})
+		}
+	default:
+		return nil, fmt.Errorf("dataset desconhecido: %s", dataset)
+	}
 
-		_, err := fmt.Scan(&escolha)
+	return targets, nil
+}
+
+// cliQualidade aplica as regras de qualidade de cada dataset (alvosQualidade).
+// Pelo comando quality, pedir um dataset sem regras é erro; no pipeline
+// (exigirRegras false) ele só é pulado.
+func cliQualidade(opts cliOptions, exigirRegras bool) error {
+	for _, dataset := range opts.datasets {
+		if err := temRegrasQualidade(dataset); err != nil {
+			if exigirRegras {
+				return err
+			}
+			continue
+		}
+		alvos, err := alvosQualidade(dataset, opts)
+		if err != nil {
+			return err
+		}
+		if err := verificarQualidade(dataset, alvos, opts.limiteQualidade); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alvosQualidade devolve os arquivos avaliados pelas regras de um dataset: os
+// que o load carregaria, exceto no inf_diario, avaliado nos arquivos diários
+// padronizados — o snapshot do fim do mês esconderia os problemas dos outros dias
+func alvosQualidade(dataset string, opts cliOptions) ([]loadTarget, error) {
+	if dataset != "inf_diario" {
+		return loadTargets(dataset, opts)
+	}
+	var alvos []loadTarget
+	for _, ano := range opts.anos {
+		for _, mes := range opts.meses {
+			alvos = append(alvos, loadTarget{file: filepath.Join(snapshotUltimoDiaMes.entrada,
+				fmt.Sprintf("inf_diario_fi_%d%02d.csv", ano, mes))})
+		}
+	}
+	return alvos, nil
+}
+
+// cliReconciliar compara os informes que o load carregaria com o cadastro de fundos
+func cliReconciliar(opts cliOptions) error {
+	fundos, err := lerCadastroFundos()
+	if err != nil {
+		return err
+	}
+	for _, dataset := range opts.datasets {
+		if schemaDoDataset(dataset) == "cadastro" {
+			continue
+		}
+		alvos, err := loadTargets(dataset, opts)
 		if err != nil {
-			fmt.Println("Erro ao ler opção:", err)
-			return
+			return err
+		}
+		if err := reconciliar(dataset, alvos, fundos); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// cliMetricas gera fund_metrics dos meses pedidos; com --source db lê a tabela
+// --table, que precisa ter o inf_diario diário (não há padrão: a única tabela
+// que o pipeline carrega, inf_diario_ultimos_dias, só tem o fim de cada mês)
+func cliMetricas(opts cliOptions) error {
+	tabela := ""
+	if opts.origemMetricas == "db" {
+		if opts.table == "" {
+			return fmt.Errorf("--source db exige --table com o inf_diario diário (ex: load --table inf_diario --file csvs/inf_diario_padronized/inf_diario_fi_202401.csv)")
+		}
+		tabela = opts.table
+	}
+	return gerarMetricas(opts.anos, opts.meses, tabela)
+}
+
+func cliLoad(opts cliOptions) error {
+	var alvos []loadTarget
+	if opts.file != "" {
+		if opts.table == "" {
+			return fmt.Errorf("--table é obrigatório junto com --file")
+		}
+		alvos = append(alvos, loadTarget{opts.table, opts.file})
+	}
+
+	if opts.file == "" {
+		for _, dataset := range opts.datasets {
+			targets, err := loadTargets(dataset, opts)
+			if err != nil {
+				return err
+			}
+			for _, t := range targets {
+				if _, err := os.Stat(t.file); err != nil {
+					continue
+				}
+				alvos = append(alvos, t)
+			}
+		}
+	}
+	if len(alvos) == 0 {
+		fmt.Println("Nenhum arquivo para carregar.")
+		return nil
+	}
+
+	l, err := newLoader(opts.loadWorkers)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	l.proveniencia = opts.proveniencia
+	l.chavesEstrangeiras = opts.chavesEstrangeiras
+	if opts.runID != "" {
+		l.runID = opts.runID
+	}
+
+	return l.carregarVarios(alvos)
+}
+
+func cliPipeline(opts cliOptions) error {
+	if err := cliDownload(opts); err != nil {
+		return err
+	}
+	if err := cliPadronize(opts); err != nil {
+		return err
+	}
+	for _, dataset := range opts.datasets {
+		if dataset == "inf_diario" {
+			if err := pickLastDayOfMonthInfDiario(opts.anos, opts.meses); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cliQualidade(opts, false); err != nil {
+		return err
+	}
+	// a reconciliação só informa; sem cadastro padronizado o pipeline segue
+	if err := cliReconciliar(opts); err != nil {
+		fmt.Println("Reconciliação com o cadastro não executada:", err)
+	}
+	return cliLoad(opts)
 }
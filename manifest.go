@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// manifestPath guarda o que já foi baixado de cada URL
+const manifestPath = "csvs/_manifest.json"
+
+// forceDownload ignora o manifesto e baixa tudo de novo (--force na CLI)
+var forceDownload bool
+
+// errNaoModificado indica que o servidor respondeu 304 (ou o conteúdo é idêntico ao último download)
+var errNaoModificado = errors.New("arquivo não modificado desde o último download")
+
+// manifestEntry é o registro de um arquivo baixado
+type manifestEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Tamanho      int64     `json:"tamanho"`
+	SHA256       string    `json:"sha256"`
+	BaixadoEm    time.Time `json:"baixado_em"`
+	Membros      []string  `json:"membros,omitempty"` // arquivos gravados em job.dest por esse download
+}
+
+// downloadManifest é o manifesto persistido em JSON; seguro para uso concorrente
+type downloadManifest struct {
+	mu       sync.Mutex
+	path     string
+	entradas map[string]manifestEntry
+}
+
+// loadManifest lê o manifesto do disco; se o arquivo não existir devolve um manifesto vazio
+func loadManifest(path string) (*downloadManifest, error) {
+	m := &downloadManifest{path: path, entradas: map[string]manifestEntry{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+
+	var lista []manifestEntry
+	if err := json.Unmarshal(data, &lista); err != nil {
+		return m, fmt.Errorf("manifesto %s inválido: %w", path, err)
+	}
+	for _, e := range lista {
+		m.entradas[e.URL] = e
+	}
+	return m, nil
+}
+
+func (m *downloadManifest) get(url string) (manifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entradas[url]
+	return e, ok
+}
+
+func (m *downloadManifest) set(e manifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entradas[e.URL] = e
+}
+
+// save grava o manifesto ordenado por URL, via arquivo temporário + rename
+func (m *downloadManifest) save() error {
+	m.mu.Lock()
+	lista := make([]manifestEntry, 0, len(m.entradas))
+	for _, e := range m.entradas {
+		lista = append(lista, e)
+	}
+	m.mu.Unlock()
+	sort.Slice(lista, func(i, j int) bool { return lista[i].URL < lista[j].URL })
+
+	data, err := json.MarshalIndent(lista, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// anterior devolve a entrada do manifesto a ser usada na requisição condicional do job.
+// Não devolve nada com --force ou se algum arquivo esperado (os membros do job ou
+// os gravados pelo último download) sumiu de job.dest. Jobs sem membros conhecidos
+// (HIST) registrados por versões antigas do manifesto são baixados de novo uma vez.
+func (m *downloadManifest) anterior(job Job) *manifestEntry {
+	if forceDownload || m == nil {
+		return nil
+	}
+	e, ok := m.get(job.url)
+	if !ok {
+		return nil
+	}
+	if len(job.membros) == 0 && len(e.Membros) == 0 {
+		return nil
+	}
+	for _, membro := range append(job.membros[:len(job.membros):len(job.membros)], e.Membros...) {
+		if _, err := os.Stat(filepath.Join(job.dest, membro)); err != nil {
+			return nil
+		}
+	}
+	return &e
+}
+
+// origem devolve o download mais recente que gravou o arquivo (pelo nome, entre
+// os membros registrados); ok é false para manifestos sem membros ou arquivos
+// que não vieram de um download
+func (m *downloadManifest) origem(arquivo string) (manifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nome := filepath.Base(arquivo)
+	var melhor manifestEntry
+	achou := false
+	for _, e := range m.entradas {
+		for _, membro := range e.Membros {
+			if filepath.Base(membro) == nome && (!achou || e.BaixadoEm.After(melhor.BaixadoEm)) {
+				melhor, achou = e, true
+			}
+		}
+	}
+	return melhor, achou
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// runMenu mantém o menu numerado original. Com opcao >= 0 a opção é executada
+// diretamente, sem ler do stdin (útil para cron/containers); com opcao < 0 o
+// menu interativo é exibido.
+func runMenu(opcao int) error {
+	if opcao >= 0 {
+		executarOpcaoMenu(opcao)
+		return nil
+	}
+
+	fmt.Println("Selecione uma opção:")
+	fmt.Println("1 - Iniciar downloads e descompactação")
+	fmt.Println("2 - Organizar inf_diario e selecionar último dia de cada mês")
+	fmt.Println("3 - Ligar pesquisa de info de fundos na porta 8080")
+	fmt.Println("4 - Iniciar downloads e descompactação FIDC")
+	fmt.Println("5 - Organizaar FIDC's")
+	fmt.Println("6 - Organizar inf_diario com goroutines (versão melhorada)")
+	fmt.Println("7 - Iniciar servidor com dados de AdmFii na porta 8080")
+	fmt.Print("Digite 1, 2, 3, 4, 5, 6 ou 7: ")
+
+	var escolha int
+	_, err := fmt.Scan(&escolha)
+	if err != nil {
+		return fmt.Errorf("erro ao ler opção: %w", err)
+	}
+
+	for {
+		if !executarOpcaoMenu(escolha) {
+			return nil
+		}
+
+		fmt.Println("\nSelecione uma opção:")
+		fmt.Println("1 - Iniciar downloads e descompactação")
+		fmt.Println("2 - Organizar inf_diario e selecionar último dia de cada mês")
+		fmt.Println("3 - Ligar pesquisa de info de fundos na porta 8080")
+		fmt.Println("4 - Iniciar downloads e descompactação FIDC")
+		fmt.Println("5 - Organizaar FIDC's")
+		fmt.Println("0 - Sair")
+		fmt.Print("Digite 1, 2, 3, 4, 5 ou 0: ")
+
+		_, err := fmt.Scan(&escolha)
+		if err != nil {
+			return fmt.Errorf("erro ao ler opção: %w", err)
+		}
+	}
+}
+
+// executarOpcaoMenu executa uma opção do menu; retorna false quando a opção é "sair"
+func executarOpcaoMenu(escolha int) bool {
+	switch escolha {
+	case 1:
+		if etapaFalhou("download do inf_diario", runDownloads([]int{2021, 2022, 2023, 2024, 2025}, []string{"inf_diario"}).err()) {
+			break
+		}
+		fmt.Println("Informes diários baixados com sucesso.")
+		if etapaFalhou("download das lâminas", runDownloads([]int{2019, 2020, 2021, 2022, 2023, 2024, 2025}, []string{"lamina"}).err()) {
+			break
+		}
+		fmt.Println("Lâminas baixadas com sucesso.")
+	case 2:
+		if report := runDownloads([]int{2025}, []string{"inf_diario"}); len(report.falhas()) > 0 {
+			fmt.Println("Downloads com erro, padronização interrompida:", report.err())
+			break
+		}
+		fmt.Println("Informes diários baixados com sucesso.")
+		if _, err := csvPadronizationInfDiario([]int{2025}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}); etapaFalhou("padronização do inf_diario", err) {
+			break
+		}
+		fmt.Println("Inf_diario organizado com sucesso!")
+		if etapaFalhou("seleção do último dia", pickLastDayOfMonthInfDiario([]int{2025}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})) {
+			break
+		}
+		fmt.Println("Último dia de cada mês selecionado com sucesso!")
+
+		// as regras valem para os arquivos diários; o banco recebe o último dia
+		var alvos, diarios []loadTarget
+		for anoMes := 202509; anoMes >= 202501; {
+			alvos = append(alvos, loadTarget{"inf_diario_ultimos_dias", fmt.Sprintf("csvs/inf_diario_ultimos_dias/inf_diario_fi_%d.csv", anoMes)})
+			diarios = append(diarios, loadTarget{file: fmt.Sprintf("csvs/inf_diario_padronized/inf_diario_fi_%d.csv", anoMes)})
+			// decrementa anoMes corretamente
+			mes := anoMes % 100
+			ano := anoMes / 100
+			if mes == 1 {
+				ano--
+				mes = 12
+			} else {
+				mes--
+			}
+			anoMes = ano*100 + mes
+		}
+		if etapaFalhou("verificação de qualidade", verificarQualidade("inf_diario", diarios, -1)) {
+			break
+		}
+		carregarMenu(alvos)
+	case 3:
+		report, err := runDownloadsDataset("inf_diario", []int{2005, 2006, 2007, 2008, 2009, 2010, 2011, 2012, 2013, 2014, 2015, 2016, 2017, 2018}, todosOsMeses, true)
+		if etapaFalhou("download do inf_diario", errors.Join(err, report.err())) {
+			break
+		}
+		fmt.Println("Download Inf_diario realizado com sucesso!")
+		if _, err := csvPadronizationInfDiario([]int{2005, 2006, 2007, 2008, 2009, 2010, 2011, 2012, 2013, 2014, 2015, 2016, 2017, 2018, 2019, 2020}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}); etapaFalhou("padronização do inf_diario", err) {
+			break
+		}
+		fmt.Println("Inf_diario organizado com sucesso!")
+		if etapaFalhou("seleção do último dia", pickLastDayOfMonthInfDiario([]int{2005, 2006, 2007, 2008, 2009, 2010, 2011, 2012, 2013, 2014, 2015, 2016, 2017, 2018, 2019, 2020}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})) {
+			break
+		}
+		fmt.Println("Último dia de cada mês selecionado com sucesso!")
+	case 4:
+		if etapaFalhou("download dos FIDC's", runDownloadsFIDC([]int{2021, 2022, 2023, 2024, 2025}, []string{"fidc"}).err()) {
+			break
+		}
+		fmt.Println("FIDC's baixados com sucesso.")
+	case 5:
+		if _, err := csvPadronizationFidc([]string{"_IV_", "_X_1_", "_X_2_", "_X_3_"}, []int{2021, 2022, 2023, 2024, 2025}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}); etapaFalhou("padronização dos FIDC's", err) {
+			break
+		}
+		fmt.Println("FIDC's padronizados com sucesso.")
+	case 6:
+		_, err := csvPadronizationLamina(
+			[]string{"_", "_carteira_", "_rentab_ano_", "_rentab_mes_"},
+			[]int{2021, 2022, 2023, 2024, 2025},
+			[]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+		)
+		if etapaFalhou("padronização das lâminas", err) {
+			break
+		}
+		fmt.Println("Lâminas organizadas com sucesso!")
+	case 7:
+		//startServerAdmFii()
+	case 8:
+		if _, err := csvPadronizationFip([]string{"fip"}, []int{2019, 2020, 2021, 2022, 2023, 2024, 2025}); etapaFalhou("padronização dos FIP's", err) {
+			break
+		}
+		fmt.Println("FIP's padronizados com sucesso.")
+	case 9:
+		if etapaFalhou("download dos FIP's", runDownloadsFIP([]int{2019, 2020, 2021, 2022, 2023, 2024, 2025}, []string{"fip"}).err()) {
+			break
+		}
+		fmt.Println("FIP's baixados com sucesso.")
+	case 10:
+
+	case 11:
+		if etapaFalhou("download do cadastro de administradores de FII", downloadCsvDescompactado([]string{"adm_fii"}, "cad").err()) {
+			break
+		}
+		fmt.Println("Cadastro de administradores de FII baixados com sucesso.")
+		if _, err := simpleCsvPadronization([]string{"adm_fii"}, []string{""}, "cad", ""); etapaFalhou("padronização do cadastro de administradores de FII", err) {
+			break
+		}
+		fmt.Println("Cadastro de administradores de FII padronizados com sucesso.")
+	case 12:
+		if etapaFalhou("download do cadastro de fundos", downloadCsvDescompactado([]string{"fi"}, "cad").err()) {
+			break
+		}
+		fmt.Println("Cadastro de informações de fundos baixados com sucesso.")
+		if _, err := simpleCsvPadronization([]string{"fi"}, []string{""}, "cad", ""); etapaFalhou("padronização do cadastro de fundos", err) {
+			break
+		}
+		fmt.Println("Cadastro de informações de fundos padronizados com sucesso.")
+	case 13:
+		if etapaFalhou("download do registro_fundo_classe", downloadCsvCompactado([]string{"fi"}, "cad", "registro_fundo_classe").err()) {
+			break
+		}
+		fmt.Println("Cadastro de informações de fundos (registro_fundo_classe) baixados com sucesso.")
+		if _, err := simpleCsvPadronization([]string{"fi"}, []string{"classe", "fundo", "subclasse"}, "cad", "registro"); etapaFalhou("padronização do registro_fundo_classe", err) {
+			break
+		}
+	case 14:
+		etapaFalhou("download da CDA", runDownloads([]int{2023, 2024, 2025}, []string{"cda"}).err())
+	case 15:
+		_, err := csvPadronizationCda([]int{2023, 2024, 2025}, todosOsMeses)
+		etapaFalhou("padronização da CDA", err)
+	case 16:
+		// ex:
+		// tableName := "cadastro_adm_fii"
+		// csvFile := "adm_fii_padronized/cad_adm_fii.csv"
+		// database("cadastro_adm_fii", "adm_fii_padronized/cad_adm_fii.csv")
+		// database("cadastro_fi", "fi_padronized/cad_fi.csv")
+		//database("cadastro_adm_fii", "adm_fii_padronized/cad_adm_fii.csv")
+		//database("registro_classe", "fi_padronized/registro_classe.csv")
+		//database("registro_fundo", "csvs/fi_padronized/registro_fundo.csv")
+		// Adiciona todos os arquivos de csvs/cda_padronized no banco, nomeando pelo prefixo do arquivo
+		prefixos := []string{
+			// "cda_fi_BLC_1",
+			// "cda_fi_BLC_2",
+			// "cda_fi_BLC_3",
+			// "cda_fi_BLC_4",
+			// "cda_fi_BLC_5",
+			// "cda_fi_BLC_6",
+			"cda_fi_BLC_7",
+			"cda_fi_BLC_8",
+			"cda_fi_PL",
+			"cda_fiim",
+		}
+		var alvos []loadTarget
+		for _, prefixo := range prefixos {
+			for ano := 2025; ano <= 2025; ano++ {
+				for mes := 8; mes <= 8; mes++ {
+					anoMes := fmt.Sprintf("%04d%02d", ano, mes)
+					arquivos := []string{
+						fmt.Sprintf("csvs/cda_padronized/%s_%s.csv", prefixo, anoMes),
+					}
+					for _, arquivo := range arquivos {
+						// Extrai o nome do banco do prefixo do arquivo
+						var tableName string
+						if idx := len("csvs/cda_padronized/"); len(arquivo) > idx {
+							rest := arquivo[idx:]
+							if i := len(rest); i > 0 {
+								// pega até o primeiro "_AAAA" (ano)
+								for j := 0; j < i; j++ {
+									if rest[j] == '_' && j+5 < i && rest[j+1] >= '0' && rest[j+1] <= '9' {
+										tableName = rest[:j]
+										break
+									}
+								}
+							}
+						}
+						if tableName != "" {
+							alvos = append(alvos, loadTarget{prefixo, arquivo})
+						}
+					}
+				}
+			}
+		}
+		carregarMenu(alvos)
+		//database("registro_subclasse", "fi_padronized/registro_subclasse.csv")
+		//database("lamina_rentab_ano", "lamina_padronized/lamina_fi_rentab_ano_202508.csv")
+
+		// for anoMes := 202508; anoMes >= 202101; {
+		// 	database("inf_diario_ultimos_dias", fmt.Sprintf("inf_diario_ultimos_dias/inf_diario_fi_%d.csv", anoMes))
+		// 	// decrementa anoMes corretamente
+		// 	mes := anoMes % 100
+		// 	ano := anoMes / 100
+		// 	if mes == 1 {
+		// 		ano--
+		// 		mes = 12
+		// 	} else {
+		// 		mes--
+		// 	}
+		// 	anoMes = ano*100 + mes
+		// }
+	case 17:
+		// database("cadastro_adm_fii", "csvs/adm_fii_padronized/cad_adm_fii.csv")
+	case 0:
+		fmt.Println("Saindo...")
+		return false
+	default:
+		fmt.Println("Opção inválida.")
+	}
+
+	return true
+}
+
+// etapaFalhou mostra o erro de uma etapa do menu; quem chama interrompe as
+// etapas seguintes, para não carregar arquivos parciais
+func etapaFalhou(etapa string, err error) bool {
+	if err == nil {
+		return false
+	}
+	fmt.Printf("Erro em %s, etapas seguintes interrompidas: %v\n", etapa, err)
+	return true
+}
+
+// carregarMenu carrega os arquivos com uma única conexão e só mostra os erros,
+// sem derrubar o menu
+func carregarMenu(alvos []loadTarget) {
+	l, err := newLoader(4)
+	if err != nil {
+		fmt.Println("Erro ao conectar no banco:", err)
+		return
+	}
+	defer l.Close()
+
+	if err := l.carregarVarios(alvos); err != nil {
+		fmt.Println("Arquivos com erro na carga:", err)
+	}
+}
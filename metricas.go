@@ -0,0 +1,516 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dirMetricas guarda um arquivo por mês de referência (fund_metrics_AAAAMM.csv),
+// carregado na tabela fund_metrics por load --dataset fund_metrics
+var dirMetricas = "csvs/fund_metrics"
+
+// colunasMetricas é o cabeçalho de fund_metrics. Retornos, volatilidade,
+// drawdown e CDI são frações (0.0123 = 1,23%); vazios quando não há histórico.
+var colunasMetricas = []string{
+	"CNPJ_FUNDO_CLASSE", "ID_SUBCLASSE", "DT_REFERENCIA", "DT_COMPTC", "VL_QUOTA", "VL_PATRIM_LIQ",
+	"RENTAB_DIA", "RENTAB_MES", "RENTAB_ANO", "RENTAB_12M", "RENTAB_24M", "RENTAB_36M",
+	"VOLATILIDADE_12M", "DRAWDOWN_MAX_12M", "CDI_12M", "SHARPE_12M",
+	"CAPTACAO_LIQUIDA_MES", "CAPTACAO_LIQUIDA_12M", "DESDOBRAMENTOS_36M",
+}
+
+const (
+	// limiteDesdobramento: cota que varia mais que esse fator (ou menos que o
+	// inverso) num dia em que o PL não acompanha é desdobramento ou grupamento
+	limiteDesdobramento = 1.5
+	// toleranciaBase: dias corridos que a última cota antes de uma data-base pode
+	// estar defasada; acima disso o retorno daquele período fica vazio
+	toleranciaBase = 7
+	// minimoVolatilidade: retornos mínimos na janela de 12 meses para volatilidade e Sharpe
+	minimoVolatilidade = 10
+	// maxDiasRetornoDia: dias úteis entre as duas últimas cotas para ainda haver RENTAB_DIA
+	maxDiasRetornoDia = 5
+)
+
+// bases de cada mês de referência, na ordem de referenciaMetricas.bases
+const (
+	baseMes = iota
+	baseAno
+	base12M
+	base24M
+	base36M
+	totalBases
+)
+
+// observacaoCota é uma linha do inf_diario reduzida ao que as métricas usam
+type observacaoCota struct {
+	data  time.Time
+	quota float64 // 0 quando ausente ou inválida
+	pl    float64
+	fluxo float64 // CAPTC_DIA - RESG_DIA
+}
+
+// referenciaMetricas é um mês pedido e as datas-base dos retornos
+type referenciaMetricas struct {
+	mes   time.Time // primeiro dia do mês
+	fim   time.Time // último dia do mês: DT_REFERENCIA
+	bases [totalBases]time.Time
+}
+
+func novaReferenciaMetricas(mes time.Time) referenciaMetricas {
+	r := referenciaMetricas{mes: mes, fim: mes.AddDate(0, 1, -1)}
+	r.bases[baseMes] = mes.AddDate(0, 0, -1)
+	r.bases[baseAno] = time.Date(mes.Year()-1, time.December, 31, 0, 0, 0, 0, time.UTC)
+	// último dia do mesmo mês 1, 2 e 3 anos antes (fevereiro incluso)
+	r.bases[base12M] = mes.AddDate(-1, 1, -1)
+	r.bases[base24M] = mes.AddDate(-2, 1, -1)
+	r.bases[base36M] = mes.AddDate(-3, 1, -1)
+	return r
+}
+
+// pontoCota é uma cota já ajustada por desdobramentos
+type pontoCota struct {
+	data time.Time
+	cota float64
+}
+
+// acumuladorMetricas junta as métricas de um fundo num mês de referência,
+// observação a observação e em ordem cronológica
+type acumuladorMetricas struct {
+	ref               *referenciaMetricas
+	bases             [totalBases]pontoCota // última cota até cada data-base
+	ultima, penultima pontoCota
+	quota, pl         float64 // valores informados (sem ajuste) da última observação
+
+	// log-retornos por dia útil na janela de 12 meses (Welford)
+	n           int
+	media, m2   float64
+	pico, queda float64
+
+	fluxoMes, fluxo12M float64
+}
+
+func (a *acumuladorMetricas) adicionar(o observacaoCota, ajustada float64) {
+	if o.data.After(a.ref.fim) {
+		return
+	}
+	janela := o.data.After(a.ref.bases[base12M])
+	if ajustada > 0 {
+		for i, b := range a.ref.bases {
+			if !o.data.After(b) {
+				a.bases[i] = pontoCota{o.data, ajustada}
+			}
+		}
+		if janela && a.ultima.cota > 0 {
+			// um retorno que cobre k dias úteis (dias sem informe) pesa como k dias
+			k := max(1, diasUteisEntre(a.ultima.data, o.data))
+			x := math.Log(ajustada/a.ultima.cota) / math.Sqrt(float64(k))
+			a.n++
+			d := x - a.media
+			a.media += d / float64(a.n)
+			a.m2 += d * (x - a.media)
+
+			if a.pico == 0 {
+				a.pico = a.ultima.cota
+			}
+			a.pico = max(a.pico, ajustada)
+			a.queda = min(a.queda, ajustada/a.pico-1)
+		}
+		a.penultima, a.ultima = a.ultima, pontoCota{o.data, ajustada}
+		a.quota = o.quota
+	}
+	if o.pl > 0 {
+		a.pl = o.pl
+	}
+	if janela {
+		a.fluxo12M += o.fluxo
+	}
+	if !o.data.Before(a.ref.mes) {
+		a.fluxoMes += o.fluxo
+	}
+}
+
+// retorno da última cota sobre a base i; NaN se a base não existe ou está defasada
+func (a *acumuladorMetricas) retorno(i int) float64 {
+	b := a.bases[i]
+	if b.cota <= 0 || b.data.Before(a.ref.bases[i].AddDate(0, 0, -toleranciaBase)) {
+		return math.NaN()
+	}
+	return a.ultima.cota/b.cota - 1
+}
+
+// serieFundo acompanha a cota de um fundo (CNPJ_FUNDO_CLASSE|ID_SUBCLASSE) e a
+// ajusta pelos desdobramentos, alimentando um acumulador por mês de referência
+type serieFundo struct {
+	vista          time.Time      // última data processada, com ou sem cota
+	ultima         observacaoCota // última observação com cota
+	fator          float64        // cota ajustada = cota informada * fator
+	desdobramentos []time.Time
+	metricas       []*acumuladorMetricas
+}
+
+// adicionar ignora datas repetidas ou fora de ordem (a primeira vence)
+func (s *serieFundo) adicionar(o observacaoCota) bool {
+	if !o.data.After(s.vista) {
+		return false
+	}
+	s.vista = o.data
+
+	ajustada := 0.0
+	if o.quota > 0 {
+		switch {
+		case s.ultima.quota == 0:
+			s.fator = 1
+		case ehDesdobramento(s.ultima, o):
+			// a série continua pelo retorno implícito no PL, descontado o fluxo do dia
+			retornoPL := (o.pl - o.fluxo) / s.ultima.pl
+			s.fator = s.ultima.quota * s.fator * retornoPL / o.quota
+			s.desdobramentos = append(s.desdobramentos, o.data)
+		}
+		ajustada = o.quota * s.fator
+		s.ultima = o
+	}
+	for _, a := range s.metricas {
+		a.adicionar(o, ajustada)
+	}
+	return true
+}
+
+// ehDesdobramento: a cota saltou além de limiteDesdobramento mas o PL, sem o
+// fluxo do dia, ficou dentro da faixa
+func ehDesdobramento(anterior, o observacaoCota) bool {
+	r := o.quota / anterior.quota
+	if r < limiteDesdobramento && r > 1/limiteDesdobramento {
+		return false
+	}
+	if anterior.pl <= 0 || o.pl <= 0 {
+		return false
+	}
+	p := (o.pl - o.fluxo) / anterior.pl
+	return p < limiteDesdobramento && p > 1/limiteDesdobramento
+}
+
+// motorMetricas recebe as observações de todos os fundos; cada fundo precisa
+// chegar em ordem cronológica, mas fundos diferentes podem se intercalar
+type motorMetricas struct {
+	refs      []referenciaMetricas
+	fundos    map[string]*serieFundo
+	ignoradas int
+}
+
+func novoMotorMetricas(meses []time.Time) *motorMetricas {
+	m := &motorMetricas{fundos: map[string]*serieFundo{}}
+	for _, mes := range meses {
+		m.refs = append(m.refs, novaReferenciaMetricas(mes))
+	}
+	return m
+}
+
+// inicio é a primeira data que influencia algum mês pedido: o mês da base de 36 meses
+func (m *motorMetricas) inicio() time.Time {
+	ini := m.refs[0].bases[base36M]
+	for _, r := range m.refs {
+		if r.bases[base36M].Before(ini) {
+			ini = r.bases[base36M]
+		}
+	}
+	return time.Date(ini.Year(), ini.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (m *motorMetricas) fim() time.Time {
+	fim := m.refs[0].fim
+	for _, r := range m.refs {
+		if r.fim.After(fim) {
+			fim = r.fim
+		}
+	}
+	return fim
+}
+
+func (m *motorMetricas) adicionar(fundo string, o observacaoCota) {
+	s, ok := m.fundos[fundo]
+	if !ok {
+		s = &serieFundo{}
+		for i := range m.refs {
+			s.metricas = append(s.metricas, &acumuladorMetricas{ref: &m.refs[i]})
+		}
+		m.fundos[fundo] = s
+	}
+	if !s.adicionar(o) {
+		m.ignoradas++
+	}
+}
+
+// lerArquivo alimenta o motor com um arquivo diário padronizado; as linhas são
+// ordenadas por fundo e data antes, porque o arquivo não garante ordem
+func (m *motorMetricas) lerArquivo(arquivo string) error {
+	f, reader, header, err := abrirCSVCarga(arquivo)
+	if err != nil {
+		return fmt.Errorf("erro ao abrir %s: %w", arquivo, err)
+	}
+	defer f.Close()
+
+	idx := map[string]int{}
+	for i, h := range header {
+		idx[h] = i
+	}
+	for _, col := range []string{"cnpj_fundo_classe", "dt_comptc", "vl_quota"} {
+		if _, ok := idx[col]; !ok {
+			return fmt.Errorf("coluna %s ausente em %s", strings.ToUpper(col), arquivo)
+		}
+	}
+	campo := func(record []string, coluna string) string {
+		if i, ok := idx[coluna]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	porFundo := map[string][]observacaoCota{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("erro ao ler %s: %w", arquivo, err)
+		}
+		fundo, o, ok := observacaoDe(campo(record, "cnpj_fundo_classe"), campo(record, "id_subclasse"),
+			campo(record, "dt_comptc"), campo(record, "vl_quota"), campo(record, "vl_patrim_liq"),
+			campo(record, "captc_dia"), campo(record, "resg_dia"))
+		if !ok {
+			m.ignoradas++
+			continue
+		}
+		porFundo[fundo] = append(porFundo[fundo], o)
+	}
+
+	for fundo, obs := range porFundo {
+		sort.SliceStable(obs, func(i, j int) bool { return obs[i].data.Before(obs[j].data) })
+		for _, o := range obs {
+			m.adicionar(fundo, o)
+		}
+	}
+	return nil
+}
+
+// lerBanco alimenta o motor com uma tabela já carregada (colunas do inf_diario,
+// com nomes limpos), ordenada por fundo e data pelo próprio banco
+func (m *motorMetricas) lerBanco(db *sql.DB, tabela string) error {
+	rows, err := db.Query(fmt.Sprintf(`SELECT cnpj_fundo_classe::text, COALESCE(id_subclasse::text, ''), dt_comptc::text,
+		COALESCE(vl_quota::text, ''), COALESCE(vl_patrim_liq::text, ''), COALESCE(captc_dia::text, ''), COALESCE(resg_dia::text, '')
+		FROM %s WHERE dt_comptc::date BETWEEN $1 AND $2 ORDER BY 1, 2, 3`, qi(tabela)),
+		m.inicio().Format(layoutData), m.fim().Format(layoutData))
+	if err != nil {
+		return fmt.Errorf("erro ao consultar %s: %w", tabela, err)
+	}
+	defer rows.Close()
+
+	var c [7]string
+	for rows.Next() {
+		if err := rows.Scan(&c[0], &c[1], &c[2], &c[3], &c[4], &c[5], &c[6]); err != nil {
+			return err
+		}
+		fundo, o, ok := observacaoDe(c[0], c[1], c[2], c[3], c[4], c[5], c[6])
+		if !ok {
+			m.ignoradas++
+			continue
+		}
+		m.adicionar(fundo, o)
+	}
+	return rows.Err()
+}
+
+// exigirDiario recusa uma tabela com no máximo uma data por fundo e mês (ex:
+// inf_diario_ultimos_dias): com ela, rentabilidade do dia, volatilidade,
+// drawdown e Sharpe sairiam de retornos mensais tratados como diários
+func (m *motorMetricas) exigirDiario(db *sql.DB, tabela string) error {
+	var maximo sql.NullInt64
+	err := db.QueryRow(fmt.Sprintf(`SELECT max(n) FROM (SELECT count(DISTINCT dt_comptc::date) AS n
+		FROM %s WHERE dt_comptc::date BETWEEN $1 AND $2
+		GROUP BY cnpj_fundo_classe, id_subclasse, date_trunc('month', dt_comptc::date)) t`, qi(tabela)),
+		m.inicio().Format(layoutData), m.fim().Format(layoutData)).Scan(&maximo)
+	if err != nil {
+		return fmt.Errorf("erro ao consultar %s: %w", tabela, err)
+	}
+	if maximo.Valid && maximo.Int64 <= 1 {
+		return fmt.Errorf("%s tem no máximo uma data por fundo e mês (snapshot mensal); as métricas precisam do inf_diario diário", tabela)
+	}
+	return nil
+}
+
+// observacaoDe interpreta os campos de uma linha; ok é false sem CNPJ ou data válidos
+func observacaoDe(cnpjFundo, subclasse, data, quota, pl, captc, resg string) (string, observacaoCota, bool) {
+	c, err := parseCNPJ(cnpjFundo)
+	if err != nil {
+		return "", observacaoCota{}, false
+	}
+	t, err := time.Parse(layoutData, strings.TrimSpace(data))
+	if err != nil {
+		return "", observacaoCota{}, false
+	}
+	o := observacaoCota{data: t, quota: numeroMetrica(quota), pl: numeroMetrica(pl),
+		fluxo: numeroMetrica(captc) - numeroMetrica(resg)}
+	return c.formatado() + "|" + strings.TrimSpace(subclasse), o, true
+}
+
+// numeroMetrica converte um valor do CSV; vazio ou inválido vira 0
+func numeroMetrica(s string) float64 {
+	n, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(s), ",", ".", 1), 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0
+	}
+	return n
+}
+
+// linhaMetricas monta a linha de saída de um fundo num mês; ok é false se o
+// fundo não informou cota no mês
+func linhaMetricas(fundo string, s *serieFundo, a *acumuladorMetricas, cdi serieCDI) ([]string, bool) {
+	if a.ultima.cota <= 0 || a.ultima.data.Before(a.ref.mes) {
+		return nil, false
+	}
+	cnpjFundo, subclasse, _ := strings.Cut(fundo, "|")
+
+	retDia := math.NaN()
+	if a.penultima.cota > 0 && diasUteisEntre(a.penultima.data, a.ultima.data) <= maxDiasRetornoDia {
+		retDia = a.ultima.cota/a.penultima.cota - 1
+	}
+	ret12M := a.retorno(base12M)
+	vol, queda, cdi12M, sharpe := math.NaN(), math.NaN(), math.NaN(), math.NaN()
+	if !math.IsNaN(ret12M) {
+		queda = a.queda
+		if a.n >= minimoVolatilidade {
+			vol = math.Sqrt(a.m2/float64(a.n-1)) * math.Sqrt(252)
+		}
+		if c, ok := cdi.acumuladoEntre(a.bases[base12M].data, a.ultima.data); ok {
+			cdi12M = c
+			if vol > 0 {
+				sharpe = (ret12M - cdi12M) / vol
+			}
+		}
+	}
+	desdobramentos := 0
+	for _, d := range s.desdobramentos {
+		if d.After(a.ref.bases[base36M]) && !d.After(a.ref.fim) {
+			desdobramentos++
+		}
+	}
+
+	fracao := func(v float64) string {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', 8, 64)
+	}
+	reais := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+
+	return []string{
+		cnpjFundo, subclasse, a.ref.fim.Format(layoutData), a.ultima.data.Format(layoutData),
+		strconv.FormatFloat(a.quota, 'f', -1, 64), reais(a.pl),
+		fracao(retDia), fracao(a.retorno(baseMes)), fracao(a.retorno(baseAno)),
+		fracao(ret12M), fracao(a.retorno(base24M)), fracao(a.retorno(base36M)),
+		fracao(vol), fracao(queda), fracao(cdi12M), fracao(sharpe),
+		reais(a.fluxoMes), reais(a.fluxo12M), strconv.Itoa(desdobramentos),
+	}, true
+}
+
+// gravar escreve um arquivo por mês de referência, ordenado por fundo
+func (m *motorMetricas) gravar(cdi serieCDI) error {
+	fundos := make([]string, 0, len(m.fundos))
+	for f := range m.fundos {
+		fundos = append(fundos, f)
+	}
+	sort.Strings(fundos)
+
+	for i, ref := range m.refs {
+		saida := filepath.Join(dirMetricas, fmt.Sprintf("fund_metrics_%s.csv", ref.mes.Format("200601")))
+		n := 0
+		err := writeAtomic(saida, 0644, func(w io.Writer) error {
+			cw := csv.NewWriter(w)
+			if err := cw.Write(colunasMetricas); err != nil {
+				return err
+			}
+			for _, f := range fundos {
+				s := m.fundos[f]
+				linha, ok := linhaMetricas(f, s, s.metricas[i], cdi)
+				if !ok {
+					continue
+				}
+				if err := cw.Write(linha); err != nil {
+					return err
+				}
+				n++
+			}
+			cw.Flush()
+			return cw.Error()
+		})
+		if err != nil {
+			return fmt.Errorf("erro ao gravar %s: %w", saida, err)
+		}
+		fmt.Printf("  %s: %d fundos (%s)\n", ref.mes.Format("2006-01"), n, saida)
+	}
+	return nil
+}
+
+// gerarMetricas calcula fund_metrics dos meses pedidos a partir dos arquivos
+// diários padronizados (tabela vazia) ou de uma tabela com as colunas do inf_diario
+func gerarMetricas(anos, meses []int, tabela string) error {
+	var pedidos []time.Time
+	for _, ano := range anos {
+		for _, mes := range meses {
+			pedidos = append(pedidos, time.Date(ano, time.Month(mes), 1, 0, 0, 0, 0, time.UTC))
+		}
+	}
+	sort.Slice(pedidos, func(i, j int) bool { return pedidos[i].Before(pedidos[j]) })
+	m := novoMotorMetricas(pedidos)
+
+	if tabela == "" {
+		lidos := 0
+		for mes := m.inicio(); !mes.After(m.fim()); mes = mes.AddDate(0, 1, 0) {
+			arquivo := filepath.Join(snapshotUltimoDiaMes.entrada, fmt.Sprintf("inf_diario_fi_%s.csv", mes.Format("200601")))
+			if _, err := os.Stat(arquivo); err != nil {
+				continue
+			}
+			if err := m.lerArquivo(arquivo); err != nil {
+				return err
+			}
+			lidos++
+		}
+		if lidos == 0 {
+			return fmt.Errorf("nenhum inf_diario padronizado em %s (rode padronize --dataset inf_diario)", snapshotUltimoDiaMes.entrada)
+		}
+	} else {
+		l, err := newLoader(1)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		if err := m.exigirDiario(l.db, tabela); err != nil {
+			return err
+		}
+		if err := m.lerBanco(l.db, tabela); err != nil {
+			return err
+		}
+	}
+
+	// sem o CDI as métricas saem do mesmo jeito, só sem CDI_12M e SHARPE_12M
+	cdi, err := carregarCDI(m.inicio(), m.fim())
+	if err != nil {
+		fmt.Printf("Aviso: %v; CDI_12M e SHARPE_12M ficarão vazios\n", err)
+	}
+	if err := m.gravar(cdi); err != nil {
+		return err
+	}
+	if m.ignoradas > 0 {
+		fmt.Printf("%d linhas sem CNPJ/DT_COMPTC válidos ou com data repetida foram ignoradas\n", m.ignoradas)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const fundoTeste = "11.222.333/0001-81|"
+
+// diasUteisTeste lista os dias úteis de desde até ate, inclusive
+func diasUteisTeste(t *testing.T, desde, ate string) []time.Time {
+	var dias []time.Time
+	for d := dataTeste(t, desde); !d.After(dataTeste(t, ate)); d = d.AddDate(0, 0, 1) {
+		if ehDiaUtil(d) {
+			dias = append(dias, d)
+		}
+	}
+	return dias
+}
+
+// indiceAte devolve o índice do último dia de dias até a data, inclusive
+func indiceAte(t *testing.T, dias []time.Time, data string) int {
+	limite := dataTeste(t, data)
+	i := -1
+	for j, d := range dias {
+		if !d.After(limite) {
+			i = j
+		}
+	}
+	return i
+}
+
+// metricasTeste roda o motor sobre as observações de um fundo e devolve a linha
+// de fund_metrics do mês de referência, indexada pelo nome da coluna
+func metricasTeste(t *testing.T, mes string, obs []observacaoCota, cdi serieCDI) map[string]string {
+	t.Helper()
+	m := novoMotorMetricas([]time.Time{dataTeste(t, mes)})
+	for _, o := range obs {
+		m.adicionar(fundoTeste, o)
+	}
+	s := m.fundos[fundoTeste]
+	linha, ok := linhaMetricas(fundoTeste, s, s.metricas[0], cdi)
+	if !ok {
+		t.Fatalf("linhaMetricas não gerou linha")
+	}
+	out := map[string]string{}
+	for i, col := range colunasMetricas {
+		out[col] = linha[i]
+	}
+	return out
+}
+
+func conferir(t *testing.T, linha map[string]string, coluna string, queria float64) {
+	t.Helper()
+	if math.IsNaN(queria) {
+		if linha[coluna] != "" {
+			t.Errorf("%s = %q, queria vazio", coluna, linha[coluna])
+		}
+		return
+	}
+	got, err := strconv.ParseFloat(linha[coluna], 64)
+	if err != nil || math.Abs(got-queria) > 1e-7 {
+		t.Errorf("%s = %q, queria %.8f", coluna, linha[coluna], queria)
+	}
+}
+
+func TestMetricasRetornos(t *testing.T) {
+	dias := diasUteisTeste(t, "2023-12-01", "2025-01-31")
+	ultimo := len(dias) - 1
+	cota := func(i int) float64 { return math.Pow(1.001, float64(i)) }
+	retorno := func(base string) float64 { return cota(ultimo)/cota(indiceAte(t, dias, base)) - 1 }
+
+	var obs []observacaoCota
+	for i, d := range dias {
+		obs = append(obs, observacaoCota{data: d, quota: cota(i), pl: 1000 * cota(i), fluxo: 10})
+	}
+	linha := metricasTeste(t, "2025-01-01", obs, serieCDI{})
+
+	conferir(t, linha, "RENTAB_DIA", 0.001)
+	conferir(t, linha, "RENTAB_MES", retorno("2024-12-31"))
+	conferir(t, linha, "RENTAB_ANO", retorno("2024-12-31"))
+	conferir(t, linha, "RENTAB_12M", retorno("2024-01-31"))
+	conferir(t, linha, "RENTAB_24M", math.NaN())
+	conferir(t, linha, "RENTAB_36M", math.NaN())
+	// retornos iguais todo dia: sem volatilidade nem queda; sem CDI, sem Sharpe
+	conferir(t, linha, "VOLATILIDADE_12M", 0)
+	conferir(t, linha, "DRAWDOWN_MAX_12M", 0)
+	conferir(t, linha, "CDI_12M", math.NaN())
+	conferir(t, linha, "SHARPE_12M", math.NaN())
+	conferir(t, linha, "CAPTACAO_LIQUIDA_MES", 10*float64(len(diasUteisTeste(t, "2025-01-01", "2025-01-31"))))
+	conferir(t, linha, "CAPTACAO_LIQUIDA_12M", 10*float64(len(diasUteisTeste(t, "2024-02-01", "2025-01-31"))))
+	if linha["DT_REFERENCIA"] != "2025-01-31" || linha["DT_COMPTC"] != "2025-01-31" || linha["DESDOBRAMENTOS_36M"] != "0" {
+		t.Errorf("linha = %v", linha)
+	}
+}
+
+func TestMetricasSemHistorico(t *testing.T) {
+	var obs []observacaoCota
+	for i, d := range diasUteisTeste(t, "2024-08-01", "2025-01-31") {
+		obs = append(obs, observacaoCota{data: d, quota: 1 + float64(i)/1000})
+	}
+	linha := metricasTeste(t, "2025-01-01", obs, serieCDI{})
+	for _, col := range []string{"RENTAB_12M", "RENTAB_24M", "RENTAB_36M", "VOLATILIDADE_12M", "DRAWDOWN_MAX_12M", "SHARPE_12M"} {
+		conferir(t, linha, col, math.NaN())
+	}
+	if linha["RENTAB_MES"] == "" || linha["RENTAB_ANO"] == "" {
+		t.Errorf("retornos do mês e do ano deveriam existir: %v", linha)
+	}
+}
+
+func TestMetricasRentabDiaComLacuna(t *testing.T) {
+	var obs []observacaoCota
+	for i, d := range diasUteisTeste(t, "2024-12-01", "2025-01-31") {
+		// sem informe de 20 a 30/01: a última cota cobre 10 dias úteis
+		if d.After(dataTeste(t, "2025-01-17")) && d.Before(dataTeste(t, "2025-01-31")) {
+			continue
+		}
+		obs = append(obs, observacaoCota{data: d, quota: 1 + float64(i)/1000})
+	}
+	linha := metricasTeste(t, "2025-01-01", obs, serieCDI{})
+	conferir(t, linha, "RENTAB_DIA", math.NaN())
+}
+
+func TestMetricasDrawdown(t *testing.T) {
+	dias := diasUteisTeste(t, "2023-12-01", "2025-01-31")
+	pico := indiceAte(t, dias, "2024-06-28")
+	var obs []observacaoCota
+	c := 1.0
+	for i, d := range dias {
+		if i > 0 && i <= pico {
+			c *= 1.001
+		} else if i > pico {
+			c *= 0.999
+		}
+		obs = append(obs, observacaoCota{data: d, quota: c})
+	}
+	linha := metricasTeste(t, "2025-01-01", obs, serieCDI{})
+	conferir(t, linha, "DRAWDOWN_MAX_12M", math.Pow(0.999, float64(len(dias)-1-pico))-1)
+}
+
+func TestMetricasVolatilidadeESharpe(t *testing.T) {
+	dias := diasUteisTeste(t, "2023-12-01", "2025-01-31")
+	var obs []observacaoCota
+	cdi := serieCDI{}
+	c, fatorCDI := 1.0, 1.0
+	for i, d := range dias {
+		if i > 0 {
+			c *= []float64{1.002, 0.9995, 1.0007}[i%3]
+		}
+		fatorCDI *= 1.0004
+		obs = append(obs, observacaoCota{data: d, quota: c})
+		cdi.datas = append(cdi.datas, d)
+		cdi.acumulado = append(cdi.acumulado, fatorCDI)
+	}
+
+	// desvio-padrão amostral dos log-retornos diários da janela, em duas passadas
+	base := indiceAte(t, dias, "2024-01-31")
+	var retornos []float64
+	for i := base + 1; i < len(dias); i++ {
+		retornos = append(retornos, math.Log(obs[i].quota/obs[i-1].quota))
+	}
+	media := 0.0
+	for _, r := range retornos {
+		media += r / float64(len(retornos))
+	}
+	soma := 0.0
+	for _, r := range retornos {
+		soma += (r - media) * (r - media)
+	}
+	vol := math.Sqrt(soma/float64(len(retornos)-1)) * math.Sqrt(252)
+	ret12M := obs[len(obs)-1].quota/obs[base].quota - 1
+	cdi12M := math.Pow(1.0004, float64(len(dias)-1-base)) - 1
+
+	linha := metricasTeste(t, "2025-01-01", obs, cdi)
+	conferir(t, linha, "RENTAB_12M", ret12M)
+	conferir(t, linha, "VOLATILIDADE_12M", vol)
+	conferir(t, linha, "CDI_12M", cdi12M)
+	conferir(t, linha, "SHARPE_12M", (ret12M-cdi12M)/vol)
+}
+
+func TestMetricasDesdobramento(t *testing.T) {
+	dias := diasUteisTeste(t, "2023-12-01", "2025-01-31")
+	desdobramento := dataTeste(t, "2024-09-02")
+	cota := func(i int) float64 { return math.Pow(1.001, float64(i)) }
+	var obs []observacaoCota
+	for i, d := range dias {
+		// desdobramento 2:1 — a cota informada cai à metade e o PL segue a série real
+		q := cota(i)
+		if !d.Before(desdobramento) {
+			q /= 2
+		}
+		obs = append(obs, observacaoCota{data: d, quota: q, pl: 1000 * cota(i)})
+	}
+	linha := metricasTeste(t, "2025-01-01", obs, serieCDI{})
+
+	ultimo := len(dias) - 1
+	conferir(t, linha, "RENTAB_12M", cota(ultimo)/cota(indiceAte(t, dias, "2024-01-31"))-1)
+	conferir(t, linha, "DRAWDOWN_MAX_12M", 0)
+	conferir(t, linha, "VL_QUOTA", cota(ultimo)/2)
+	if linha["DESDOBRAMENTOS_36M"] != "1" {
+		t.Errorf("DESDOBRAMENTOS_36M = %s, queria 1", linha["DESDOBRAMENTOS_36M"])
+	}
+}
+
+func TestEhDesdobramento(t *testing.T) {
+	anterior := observacaoCota{quota: 10, pl: 1000}
+	casos := []struct {
+		nome string
+		o    observacaoCota
+		eh   bool
+	}{
+		{"variação normal", observacaoCota{quota: 10.1, pl: 1010}, false},
+		{"desdobramento", observacaoCota{quota: 5, pl: 1001}, true},
+		{"grupamento", observacaoCota{quota: 100, pl: 1002}, true},
+		{"PL acompanha a cota", observacaoCota{quota: 5, pl: 500}, false},
+		{"PL cai por resgate, não por desdobramento", observacaoCota{quota: 5, pl: 500, fluxo: -500}, true},
+		{"sem PL", observacaoCota{quota: 5}, false},
+	}
+	for _, c := range casos {
+		if got := ehDesdobramento(anterior, c.o); got != c.eh {
+			t.Errorf("%s: ehDesdobramento = %v, queria %v", c.nome, got, c.eh)
+		}
+	}
+}
+
+func TestSerieFundoIgnoraDatasRepetidas(t *testing.T) {
+	m := novoMotorMetricas([]time.Time{dataTeste(t, "2025-01-01")})
+	for _, d := range []string{"2025-01-02", "2025-01-03", "2025-01-03", "2025-01-02", "2025-01-06"} {
+		m.adicionar(fundoTeste, observacaoCota{data: dataTeste(t, d), quota: 1})
+	}
+	if m.ignoradas != 2 {
+		t.Errorf("ignoradas = %d, queria 2", m.ignoradas)
+	}
+	if got := m.fundos[fundoTeste].metricas[0].ultima.data.Format(layoutData); got != "2025-01-06" {
+		t.Errorf("última data = %s, queria 2025-01-06", got)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tabelaLogCarga registra cada carga feita pelo loader, com sucesso ou erro
+const tabelaLogCarga = "etl_load_log"
+
+// colunasProveniencia são acrescentadas a cada linha quando o loader tem proveniencia ligada;
+// semAmostra evita que evoluirTabela tente alargar o tipo delas
+var colunasProveniencia = []colunaTabela{
+	{nome: "_source_file", tipo: "TEXT", semAmostra: true},
+	{nome: "_source_sha256", tipo: "TEXT", semAmostra: true},
+	{nome: "_loaded_at", tipo: "TIMESTAMPTZ", semAmostra: true},
+	{nome: "_pipeline_run_id", tipo: "TEXT", semAmostra: true},
+}
+
+// proveniencia são os valores das colunas de proveniência de uma carga
+type proveniencia struct {
+	arquivo     string
+	sha256      string
+	carregadoEm time.Time
+	runID       string
+}
+
+// nomes devolve os nomes das colunas, na ordem de valores
+func (p *proveniencia) nomes() []string {
+	if p == nil {
+		return nil
+	}
+	nomes := make([]string, len(colunasProveniencia))
+	for i, c := range colunasProveniencia {
+		nomes[i] = c.nome
+	}
+	return nomes
+}
+
+// valores devolve os valores na ordem de colunasProveniencia
+func (p *proveniencia) valores() []interface{} {
+	if p == nil {
+		return nil
+	}
+	return []interface{}{p.arquivo, p.sha256, p.carregadoEm, p.runID}
+}
+
+// novoRunID identifica uma execução do pipeline, ex: 20250901T101500-3fa2c1d4
+func novoRunID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
+
+// sha256Arquivo calcula o SHA-256 do CSV que está sendo carregado
+func sha256Arquivo(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// linhasRejeitadas conta as linhas rejeitadas na padronização do arquivo
+// (sidecar de quarentena); usado quando o arquivo não tem linhagem
+func linhasRejeitadas(csvFile string) int {
+	f, err := os.Open(caminhoQuarentena(csvFile))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		// linhas reparadas e avisos também vão para o sidecar
+		var l linhaQuarentena
+		if json.Unmarshal(scanner.Bytes(), &l) == nil && l.rejeitada() {
+			n++
+		}
+	}
+	return n
+}
+
+// origemArquivo é um arquivo baixado da CVM e o download (URL e SHA-256 do
+// arquivo baixado, zip inclusive, como no manifesto) que o gravou
+type origemArquivo struct {
+	Arquivo string `json:"arquivo"`
+	URL     string `json:"url,omitempty"` // vazio se o manifesto não conhece o arquivo
+	SHA256  string `json:"sha256,omitempty"`
+}
+
+// linhagemArquivo liga um CSV gerado pelo pipeline (padronizado ou snapshot)
+// às suas origens; fica em _linhagem/<arquivo>.json, ao lado do CSV
+type linhagemArquivo struct {
+	Origens    []origemArquivo `json:"origens"`
+	Rejeitadas int             `json:"rejeitadas"` // linhas rejeitadas na padronização das origens
+}
+
+// caminhoLinhagem devolve o arquivo de linhagem de um CSV gerado,
+// ex: csvs/inf_diario_ultimos_dias/_linhagem/inf_diario_fi_202401.json
+func caminhoLinhagem(csvFile string) string {
+	nome := strings.TrimSuffix(filepath.Base(csvFile), filepath.Ext(csvFile)) + ".json"
+	return filepath.Join(filepath.Dir(csvFile), "_linhagem", nome)
+}
+
+func gravarLinhagem(csvFile string, l linhagemArquivo) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeAtomic(caminhoLinhagem(csvFile), 0644, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+}
+
+// lerLinhagem lê a linhagem de um CSV; ok é false se ele não tem (ex: gerado
+// antes da linhagem existir, ou fora do pipeline)
+func lerLinhagem(csvFile string) (linhagemArquivo, bool) {
+	var l linhagemArquivo
+	data, err := os.ReadFile(caminhoLinhagem(csvFile))
+	if err != nil || json.Unmarshal(data, &l) != nil {
+		return l, false
+	}
+	return l, true
+}
+
+// juntar soma linhagens, sem repetir origens; usado pelos snapshots, que
+// juntam vários arquivos diários
+func (l *linhagemArquivo) juntar(outra linhagemArquivo) {
+	for _, o := range outra.Origens {
+		if !slices.Contains(l.Origens, o) {
+			l.Origens = append(l.Origens, o)
+		}
+	}
+	l.Rejeitadas += outra.Rejeitadas
+}
+
+// download devolve URL e SHA-256 dos downloads de origem (separados por
+// vírgula quando há mais de um); ok é false se alguma origem não está no manifesto
+func (l linhagemArquivo) download() (url, sha string, ok bool) {
+	var urls, shas []string
+	for _, o := range l.Origens {
+		if o.URL == "" {
+			return "", "", false
+		}
+		if !slices.Contains(urls, o.URL) {
+			urls = append(urls, o.URL)
+			shas = append(shas, o.SHA256)
+		}
+	}
+	return strings.Join(urls, ","), strings.Join(shas, ","), len(urls) > 0
+}
+
+// criarTabelaLogCarga cria o log de cargas; chamado uma vez por conexão (newLoader)
+func criarTabelaLogCarga(db *sql.DB) error {
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id SERIAL PRIMARY KEY,
+		tabela TEXT NOT NULL,
+		arquivo TEXT NOT NULL,
+		sha256 TEXT,
+		modo TEXT,
+		linhas BIGINT,
+		rejeitadas BIGINT,
+		duracao_ms BIGINT,
+		status TEXT NOT NULL,
+		erro TEXT,
+		pipeline_run_id TEXT,
+		iniciado_em TIMESTAMPTZ NOT NULL,
+		concluido_em TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`, qi(tabelaLogCarga))); err != nil {
+		return fmt.Errorf("erro ao criar %s: %w", tabelaLogCarga, err)
+	}
+	return nil
+}
+
+// registroCarga é uma linha de etl_load_log
+type registroCarga struct {
+	tabela     string
+	arquivo    string
+	sha256     string
+	modo       modoCarga
+	linhas     int
+	rejeitadas int
+	inicio     time.Time
+	runID      string
+	err        error
+}
+
+// registrarCarga grava a carga no log, fora da transação da carga para que as falhas também fiquem registradas
+func registrarCarga(db *sql.DB, r registroCarga) error {
+	status, erro := "ok", ""
+	if r.err != nil {
+		status, erro = "erro", r.err.Error()
+	}
+	_, err := db.Exec(fmt.Sprintf(`INSERT INTO %s
+		(tabela, arquivo, sha256, modo, linhas, rejeitadas, duracao_ms, status, erro, pipeline_run_id, iniciado_em)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, NULLIF($9, ''), $10, $11)`, qi(tabelaLogCarga)),
+		r.tabela, r.arquivo, r.sha256, string(r.modo), r.linhas, r.rejeitadas,
+		time.Since(r.inicio).Milliseconds(), status, erro, r.runID, r.inicio)
+	return err
+}
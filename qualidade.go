@@ -0,0 +1,548 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tipos aceitos em regraQualidade.Tipo
+var tiposRegra = map[string]bool{
+	"nao_nulo": true, "intervalo": true, "competencia": true,
+	"unico": true, "referencia": true, "variacao": true,
+}
+
+// regraQualidade é uma verificação declarativa sobre o conteúdo de um arquivo
+// padronizado, declarada em "regras" no schema do dataset:
+//
+//	nao_nulo     Coluna não pode ser vazia
+//	intervalo    Coluna numérica entre Min e Max (qualquer um pode faltar)
+//	competencia  data de Coluna dentro do mês do arquivo (_AAAAMM.csv)
+//	unico        combinação de Colunas não se repete no arquivo
+//	referencia   Coluna existe em ColunaRef do CSV Arquivo (ex: cad_fi)
+//	variacao     Coluna de uma mesma Chave não muda mais que FatorMax vezes, nem
+//	             cai de positivo para zero (ParaZero), de uma linha para a próxima
+//	             e em relação ao arquivo do mês anterior
+type regraQualidade struct {
+	Tipo      string   `json:"tipo"`
+	Coluna    string   `json:"coluna,omitempty"`
+	Colunas   []string `json:"colunas,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	Arquivo   string   `json:"arquivo,omitempty"`
+	ColunaRef string   `json:"coluna_ref,omitempty"`
+	Chave     []string `json:"chave,omitempty"`
+	FatorMax  float64  `json:"fator_max,omitempty"`
+	ParaZero  bool     `json:"para_zero,omitempty"`
+	Nivel     string   `json:"nivel,omitempty"` // erro (padrão) ou aviso; só erros contam para o limite
+}
+
+// validar confere o tipo da regra e os campos que ele exige
+func (r regraQualidade) validar() error {
+	if !tiposRegra[r.Tipo] {
+		return fmt.Errorf("regra de tipo %q desconhecido", r.Tipo)
+	}
+	if r.Nivel != "" && r.Nivel != "erro" && r.Nivel != "aviso" {
+		return fmt.Errorf("regra %s: nível %q inválido (use erro ou aviso)", r.Tipo, r.Nivel)
+	}
+	switch {
+	case r.Tipo == "unico" && len(r.Colunas) == 0:
+		return fmt.Errorf("regra unico sem colunas")
+	case r.Tipo != "unico" && r.Coluna == "":
+		return fmt.Errorf("regra %s sem coluna", r.Tipo)
+	case r.Tipo == "referencia" && (r.Arquivo == "" || r.ColunaRef == ""):
+		return fmt.Errorf("regra referencia de %s sem arquivo ou coluna_ref", r.Coluna)
+	case r.Tipo == "variacao" && r.FatorMax <= 1 && !r.ParaZero:
+		return fmt.Errorf("regra variacao de %s sem fator_max (> 1) nem para_zero", r.Coluna)
+	}
+	return nil
+}
+
+func (r regraQualidade) nivel() string {
+	if r.Nivel == "" {
+		return "erro"
+	}
+	return r.Nivel
+}
+
+// descricao identifica a regra no relatório, ex: "intervalo VL_PATRIM_LIQ"
+func (r regraQualidade) descricao() string {
+	if r.Tipo == "unico" {
+		return "unico " + strings.Join(r.Colunas, ",")
+	}
+	return r.Tipo + " " + r.Coluna
+}
+
+// exemploViolacao é uma das linhas que violaram a regra
+type exemploViolacao struct {
+	Linha  int    `json:"linha"`
+	Motivo string `json:"motivo"`
+}
+
+// resultadoRegra é o resultado de uma regra num arquivo
+type resultadoRegra struct {
+	Regra     string            `json:"regra"`
+	Nivel     string            `json:"nivel"`
+	Violacoes int               `json:"violacoes"`
+	Exemplos  []exemploViolacao `json:"exemplos,omitempty"`
+	Ignorada  string            `json:"ignorada,omitempty"` // por que a regra não foi aplicada
+}
+
+// maxExemplos limita quantas linhas de cada regra vão para o relatório
+const maxExemplos = 20
+
+// relatorioQualidade é o relatório JSON de um arquivo (_qualidade/<arquivo>.json)
+type relatorioQualidade struct {
+	Arquivo        string           `json:"arquivo"`
+	Dataset        string           `json:"dataset"`
+	Linhas         int              `json:"linhas"`
+	LinhasComErro  int              `json:"linhas_com_erro"`
+	PercentualErro float64          `json:"percentual_erro"`
+	Regras         []resultadoRegra `json:"regras"`
+	GeradoEm       time.Time        `json:"gerado_em"`
+}
+
+// caminhoRelatorioQualidade devolve o relatório de um CSV padronizado,
+// ex: csvs/inf_diario_ultimos_dias/_qualidade/inf_diario_fi_202401.json
+func caminhoRelatorioQualidade(csvFile string) string {
+	nome := strings.TrimSuffix(filepath.Base(csvFile), filepath.Ext(csvFile)) + ".json"
+	return filepath.Join(filepath.Dir(csvFile), "_qualidade", nome)
+}
+
+// competenciaArquivo extrai o AAAAMM do nome do arquivo (ex: cda_fi_PL_202401.csv)
+var competenciaArquivo = regexp.MustCompile(`_(\d{4})(\d{2})\.csv$`)
+
+// mesDoArquivo devolve o primeiro dia do mês do arquivo
+func mesDoArquivo(csvFile string) (time.Time, bool) {
+	m := competenciaArquivo.FindStringSubmatch(filepath.Base(csvFile))
+	if m == nil {
+		return time.Time{}, false
+	}
+	ano, _ := strconv.Atoi(m[1])
+	mes, _ := strconv.Atoi(m[2])
+	if mes < 1 || mes > 12 {
+		return time.Time{}, false
+	}
+	return time.Date(ano, time.Month(mes), 1, 0, 0, 0, 0, time.UTC), true
+}
+
+// arquivoMesAnterior devolve o mesmo arquivo com a competência do mês anterior
+func arquivoMesAnterior(csvFile string) (string, bool) {
+	mes, ok := mesDoArquivo(csvFile)
+	if !ok {
+		return "", false
+	}
+	anterior := mes.AddDate(0, -1, 0).Format("200601")
+	nome := competenciaArquivo.ReplaceAllString(filepath.Base(csvFile), "_"+anterior+".csv")
+	return filepath.Join(filepath.Dir(csvFile), nome), true
+}
+
+// verificacao é uma regra pronta para um arquivo: checar devolve o motivo da
+// violação, ou "" quando a linha passa
+type verificacao struct {
+	regra    regraQualidade
+	checar   func(row []string) string
+	ignorada string
+}
+
+// avaliadorQualidade aplica as regras de um dataset; os arquivos de referência
+// são lidos uma vez só
+type avaliadorQualidade struct {
+	schema      schemaDataset
+	referencias map[string]map[string]bool // arquivo|coluna -> valores
+}
+
+func novoAvaliadorQualidade(schema schemaDataset) *avaliadorQualidade {
+	return &avaliadorQualidade{schema: schema, referencias: map[string]map[string]bool{}}
+}
+
+// avaliar lê o arquivo inteiro uma vez e aplica todas as regras
+func (a *avaliadorQualidade) avaliar(csvFile string) (relatorioQualidade, error) {
+	rel := relatorioQualidade{Arquivo: csvFile, Dataset: a.schema.Dataset, GeradoEm: time.Now()}
+
+	f, reader, header, err := abrirCSVCarga(csvFile)
+	if err != nil {
+		return rel, fmt.Errorf("erro ao abrir %s: %w", csvFile, err)
+	}
+	defer f.Close()
+
+	verificacoes := make([]verificacao, len(a.schema.Regras))
+	rel.Regras = make([]resultadoRegra, len(a.schema.Regras))
+	for i, r := range a.schema.Regras {
+		verificacoes[i] = a.preparar(r, header, csvFile)
+		rel.Regras[i] = resultadoRegra{Regra: r.descricao(), Nivel: r.nivel(), Ignorada: verificacoes[i].ignorada}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return rel, fmt.Errorf("erro ao ler %s: %w", csvFile, err)
+		}
+		linha, _ := reader.FieldPos(0)
+		rel.Linhas++
+
+		comErro := false
+		for i, v := range verificacoes {
+			if v.checar == nil {
+				continue
+			}
+			motivo := v.checar(record)
+			if motivo == "" {
+				continue
+			}
+			res := &rel.Regras[i]
+			res.Violacoes++
+			if len(res.Exemplos) < maxExemplos {
+				res.Exemplos = append(res.Exemplos, exemploViolacao{Linha: linha, Motivo: motivo})
+			}
+			if res.Nivel == "erro" {
+				comErro = true
+			}
+		}
+		if comErro {
+			rel.LinhasComErro++
+		}
+	}
+
+	if rel.Linhas > 0 {
+		rel.PercentualErro = 100 * float64(rel.LinhasComErro) / float64(rel.Linhas)
+	}
+	return rel, nil
+}
+
+// preparar resolve as colunas da regra no cabeçalho (já limpo por
+// cleanColumnName) e monta a verificação
+func (a *avaliadorQualidade) preparar(r regraQualidade, header []string, csvFile string) verificacao {
+	v := verificacao{regra: r}
+	indice := func(nome string) int {
+		nome = cleanColumnName(nome)
+		for i, h := range header {
+			if h == nome {
+				return i
+			}
+		}
+		return -1
+	}
+	indices := func(nomes []string) ([]int, bool) {
+		out := make([]int, len(nomes))
+		for i, n := range nomes {
+			if out[i] = indice(n); out[i] < 0 {
+				return nil, false
+			}
+		}
+		return out, true
+	}
+
+	col := -1
+	if r.Coluna != "" {
+		if col = indice(r.Coluna); col < 0 {
+			v.ignorada = fmt.Sprintf("coluna %s ausente", r.Coluna)
+			return v
+		}
+	}
+	valor := func(row []string) string {
+		if col >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[col])
+	}
+
+	switch r.Tipo {
+	case "nao_nulo":
+		v.checar = func(row []string) string {
+			if ehNulo(valor(row)) {
+				return r.Coluna + " vazio"
+			}
+			return ""
+		}
+
+	case "intervalo":
+		v.checar = func(row []string) string {
+			s := valor(row)
+			if ehNulo(s) {
+				return ""
+			}
+			n, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+			switch {
+			case err != nil:
+				return fmt.Sprintf("%s=%q não é número", r.Coluna, s)
+			case r.Min != nil && n < *r.Min:
+				return fmt.Sprintf("%s=%s abaixo de %g", r.Coluna, s, *r.Min)
+			case r.Max != nil && n > *r.Max:
+				return fmt.Sprintf("%s=%s acima de %g", r.Coluna, s, *r.Max)
+			}
+			return ""
+		}
+
+	case "competencia":
+		mes, ok := mesDoArquivo(csvFile)
+		if !ok {
+			v.ignorada = "nome do arquivo sem competência AAAAMM"
+			return v
+		}
+		v.checar = func(row []string) string {
+			s := valor(row)
+			if ehNulo(s) {
+				return ""
+			}
+			t, err := time.Parse(layoutData, s)
+			if err != nil {
+				return fmt.Sprintf("%s=%q não é data", r.Coluna, s)
+			}
+			if t.Year() != mes.Year() || t.Month() != mes.Month() {
+				return fmt.Sprintf("%s=%s fora da competência %s", r.Coluna, s, mes.Format("01/2006"))
+			}
+			return ""
+		}
+
+	case "unico":
+		idx, ok := indices(r.Colunas)
+		if !ok {
+			v.ignorada = "colunas ausentes: " + strings.Join(r.Colunas, ",")
+			return v
+		}
+		vistos := map[string]bool{}
+		v.checar = func(row []string) string {
+			k := chaveLinha(row, idx)
+			if vistos[k] {
+				return fmt.Sprintf("%s repetido: %s", strings.Join(r.Colunas, ","), k)
+			}
+			vistos[k] = true
+			return ""
+		}
+
+	case "referencia":
+		ref, err := a.referencia(r.Arquivo, r.ColunaRef)
+		if err != nil {
+			v.ignorada = err.Error()
+			return v
+		}
+		v.checar = func(row []string) string {
+			s := valor(row)
+			if ehNulo(s) || ref[valorReferencia(s)] {
+				return ""
+			}
+			return fmt.Sprintf("%s=%s ausente de %s", r.Coluna, s, r.Arquivo)
+		}
+
+	case "variacao":
+		idx, ok := indices(r.Chave)
+		if !ok {
+			v.ignorada = "colunas da chave ausentes: " + strings.Join(r.Chave, ",")
+			return v
+		}
+		anteriores := ultimosValores(csvFile, r.Coluna, r.Chave)
+		v.checar = func(row []string) string {
+			n, err := strconv.ParseFloat(strings.Replace(valor(row), ",", ".", 1), 64)
+			if err != nil {
+				return ""
+			}
+			k := chaveLinha(row, idx)
+			antes, ok := anteriores[k]
+			anteriores[k] = n
+			if !ok {
+				return ""
+			}
+			if r.ParaZero && antes > 0 && n == 0 {
+				return fmt.Sprintf("%s caiu de %g para zero (%s)", r.Coluna, antes, k)
+			}
+			if r.FatorMax > 1 && antes > 0 && n > 0 && (n/antes > r.FatorMax || antes/n > r.FatorMax) {
+				return fmt.Sprintf("%s passou de %g para %g (%s)", r.Coluna, antes, n, k)
+			}
+			return ""
+		}
+	}
+	return v
+}
+
+// chaveLinha junta os valores das colunas de uma chave
+func chaveLinha(row []string, idx []int) string {
+	partes := make([]string, len(idx))
+	for i, j := range idx {
+		if j < len(row) {
+			partes[i] = strings.TrimSpace(row[j])
+		}
+	}
+	return strings.Join(partes, "|")
+}
+
+// valorReferencia compara CNPJs pela forma canônica, com ou sem máscara
+func valorReferencia(s string) string {
+	if c, err := parseCNPJ(s); err == nil {
+		return c.semMascara()
+	}
+	return strings.TrimSpace(s)
+}
+
+// referencia lê (uma vez) os valores de uma coluna do arquivo de referência
+func (a *avaliadorQualidade) referencia(arquivo, coluna string) (map[string]bool, error) {
+	if ref, ok := a.referencias[arquivo+"|"+coluna]; ok {
+		return ref, nil
+	}
+	f, reader, header, err := abrirCSVCarga(arquivo)
+	if err != nil {
+		return nil, fmt.Errorf("referência %s indisponível: %v", arquivo, err)
+	}
+	defer f.Close()
+
+	idx := -1
+	for i, h := range header {
+		if h == cleanColumnName(coluna) {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("coluna %s ausente em %s", coluna, arquivo)
+	}
+	ref := map[string]bool{}
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			break
+		}
+		ref[valorReferencia(record[idx])] = true
+	}
+	a.referencias[arquivo+"|"+coluna] = ref
+	return ref, nil
+}
+
+// ultimosValores lê do arquivo do mês anterior o último valor numérico de
+// coluna por chave; sem o arquivo, a variação só é medida dentro do mês
+func ultimosValores(csvFile, coluna string, chave []string) map[string]float64 {
+	valores := map[string]float64{}
+	anterior, ok := arquivoMesAnterior(csvFile)
+	if !ok {
+		return valores
+	}
+	f, reader, header, err := abrirCSVCarga(anterior)
+	if err != nil {
+		return valores
+	}
+	defer f.Close()
+
+	col, idx := -1, make([]int, len(chave))
+	for i := range idx {
+		idx[i] = -1
+	}
+	for i, h := range header {
+		if h == cleanColumnName(coluna) {
+			col = i
+		}
+		for j, c := range chave {
+			if h == cleanColumnName(c) {
+				idx[j] = i
+			}
+		}
+	}
+	if col < 0 {
+		return valores
+	}
+	for _, i := range idx {
+		if i < 0 {
+			return valores
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			break
+		}
+		if n, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(record[col]), ",", ".", 1), 64); err == nil {
+			valores[chaveLinha(record, idx)] = n
+		}
+	}
+	return valores
+}
+
+// gravarRelatorioQualidade grava o relatório JSON ao lado do arquivo avaliado
+func gravarRelatorioQualidade(rel relatorioQualidade) error {
+	return writeAtomic(caminhoRelatorioQualidade(rel.Arquivo), 0644, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rel)
+	})
+}
+
+// verificarQualidade avalia os arquivos a carregar de um dataset, grava um
+// relatório por arquivo e falha se algum passar de limite (percentual de linhas
+// com erro; negativo só gera os relatórios)
+func verificarQualidade(dataset string, alvos []loadTarget, limite float64) error {
+	schema, err := carregarSchema(schemaDoDataset(dataset))
+	if err != nil {
+		return err
+	}
+	if len(schema.Regras) == 0 {
+		return nil
+	}
+
+	avaliador := novoAvaliadorQualidade(schema)
+	var acima []string
+	avaliados := 0
+	for _, alvo := range alvos {
+		if _, err := os.Stat(alvo.file); err != nil {
+			continue
+		}
+		avaliados++
+		rel, err := avaliador.avaliar(alvo.file)
+		if err != nil {
+			return err
+		}
+		if err := gravarRelatorioQualidade(rel); err != nil {
+			return fmt.Errorf("erro ao gravar relatório de qualidade de %s: %w", alvo.file, err)
+		}
+
+		var violacoes []string
+		for _, r := range rel.Regras {
+			if r.Violacoes > 0 {
+				violacoes = append(violacoes, fmt.Sprintf("%s: %d", r.Regra, r.Violacoes))
+			}
+		}
+		if len(violacoes) > 0 {
+			fmt.Printf("  %s: %d linhas, %d com erro (%.2f%%) — %s\n", alvo.file, rel.Linhas,
+				rel.LinhasComErro, rel.PercentualErro, strings.Join(violacoes, "; "))
+		}
+		if limite >= 0 && rel.PercentualErro > limite {
+			acima = append(acima, fmt.Sprintf("%s (%.2f%%)", alvo.file, rel.PercentualErro))
+		}
+	}
+
+	if len(acima) > 0 {
+		return fmt.Errorf("%d arquivos de %s acima do limite de qualidade de %g%%: %s",
+			len(acima), dataset, limite, strings.Join(acima, ", "))
+	}
+	fmt.Printf("Qualidade de %s verificada (%d arquivos).\n", dataset, avaliados)
+	return nil
+}
+
+// temRegrasQualidade explica por que um dataset não pode ser verificado: sem
+// schema (ex: fii, que não é padronizado) ou com um schema sem regras
+func temRegrasQualidade(dataset string) error {
+	nome := schemaDoDataset(dataset)
+	schema, err := carregarSchema(nome)
+	if err != nil {
+		return fmt.Errorf("o dataset %s não tem schema (não é padronizado), então não tem regras de qualidade: %w", dataset, err)
+	}
+	if len(schema.Regras) == 0 {
+		return fmt.Errorf("o schema %s.json (dataset %s) não declara regras de qualidade", nome, dataset)
+	}
+	return nil
+}
+
+// schemaDoDataset devolve o schema usado pela padronização de um dataset da CLI
+func schemaDoDataset(dataset string) string {
+	switch dataset {
+	case "cad_fi", "cad_adm_fii", "registro_fi":
+		return "cadastro"
+	}
+	return dataset
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resumoPadronizacao é o resultado da padronização de um arquivo
+type resumoPadronizacao struct {
+	entrada    string
+	saida      string
+	quarentena string // vazio quando nenhuma linha foi para a quarentena
+	linhas     int
+	reparadas  int
+	rejeitadas int
+	avisos     int // ex: colunas de origem fora do schema
+}
+
+// relatorioPadronizacao junta os resumos de todos os arquivos de uma padronização
+type relatorioPadronizacao []resumoPadronizacao
+
+// imprimir mostra uma linha por arquivo que teve linhas reparadas, rejeitadas ou avisos
+func (r relatorioPadronizacao) imprimir() {
+	var linhas, reparadas, rejeitadas, avisos int
+	for _, res := range r {
+		linhas += res.linhas
+		reparadas += res.reparadas
+		rejeitadas += res.rejeitadas
+		avisos += res.avisos
+		if res.reparadas > 0 || res.rejeitadas > 0 || res.avisos > 0 {
+			fmt.Printf("  %s: %d linhas, %d reparadas, %d rejeitadas, %d avisos (%s)\n",
+				res.entrada, res.linhas, res.reparadas, res.rejeitadas, res.avisos, res.quarentena)
+		}
+	}
+	fmt.Printf("Padronização: %d arquivos, %d linhas, %d reparadas, %d rejeitadas, %d avisos\n",
+		len(r), linhas, reparadas, rejeitadas, avisos)
+}
+
+// acimaDoLimite devolve os arquivos com mais linhas rejeitadas que max
+func (r relatorioPadronizacao) acimaDoLimite(max int) relatorioPadronizacao {
+	var out relatorioPadronizacao
+	for _, res := range r {
+		if res.rejeitadas > max {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+// contarRejeitadas conta as linhas que ficaram fora da saída, sem reparos e avisos
+func contarRejeitadas(linhas []linhaQuarentena) int {
+	n := 0
+	for _, l := range linhas {
+		if l.rejeitada() {
+			n++
+		}
+	}
+	return n
+}
+
+// caminhoQuarentena devolve o sidecar de uma saída padronizada,
+// ex: csvs/cda_padronized/_rejects/cda_fi_BLC_1_202401.jsonl
+func caminhoQuarentena(saida string) string {
+	nome := strings.TrimSuffix(filepath.Base(saida), filepath.Ext(saida)) + ".jsonl"
+	return filepath.Join(filepath.Dir(saida), "_rejects", nome)
+}
+
+// caminhoRejeitosCarga devolve o sidecar das linhas recusadas na carga no banco,
+// ex: csvs/cda_padronized/_rejects/cda_fi_BLC_1_202401_carga.jsonl
+func caminhoRejeitosCarga(csvFile string) string {
+	return strings.TrimSuffix(caminhoQuarentena(csvFile), ".jsonl") + "_carga.jsonl"
+}
+
+// sidecarQuarentena grava as linhas da quarentena em JSON Lines à medida que
+// aparecem, sem acumulá-las em memória. O temporário só é criado na primeira
+// linha; fechar publica o sidecar (rename) ou, sem linhas, remove um sidecar antigo.
+type sidecarQuarentena struct {
+	path   string
+	tmp    *os.File
+	buf    *bufio.Writer
+	enc    *json.Encoder
+	linhas int
+	err    error // primeiro erro de gravação, devolvido por fechar
+}
+
+func novoSidecarQuarentena(path string) *sidecarQuarentena {
+	return &sidecarQuarentena{path: path}
+}
+
+// gravar acrescenta uma linha; depois de um erro as demais são descartadas
+func (s *sidecarQuarentena) gravar(l linhaQuarentena) {
+	if s.err != nil {
+		return
+	}
+	if s.tmp == nil {
+		if s.err = os.MkdirAll(filepath.Dir(s.path), 0755); s.err != nil {
+			return
+		}
+		if s.tmp, s.err = os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*.tmp"); s.err != nil {
+			return
+		}
+		s.buf = bufio.NewWriter(s.tmp)
+		s.enc = json.NewEncoder(s.buf)
+	}
+	s.err = s.enc.Encode(l)
+	s.linhas++
+}
+
+// fechar publica o sidecar de forma atômica, como writeAtomic
+func (s *sidecarQuarentena) fechar() error {
+	if s.tmp == nil {
+		if s.err != nil {
+			return s.err
+		}
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	err := s.err
+	if err == nil {
+		err = s.buf.Flush()
+	}
+	if err == nil {
+		err = s.tmp.Sync()
+	}
+	if errClose := s.tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Chmod(s.tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(s.tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(s.tmp.Name())
+	}
+	s.tmp = nil
+	return err
+}
+
+// gravarQuarentena grava as linhas em JSON Lines; sem linhas, remove um sidecar antigo
+func gravarQuarentena(path string, linhas []linhaQuarentena) error {
+	s := novoSidecarQuarentena(path)
+	for _, l := range linhas {
+		s.gravar(l)
+	}
+	return s.fechar()
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fonteCadastro é um CSV padronizado do cadastro de fundos e as colunas
+// (já limpas por cleanColumnName) de onde saem CNPJ, nome, tipo e situação
+type fonteCadastro struct {
+	arquivo  string
+	cnpj     string
+	nome     string
+	tipo     string
+	situacao string
+}
+
+// fontesCadastro em ordem de prioridade crescente: o registro de classes (CVM 175)
+// prevalece sobre o cad_fi, que deixou de ser atualizado
+var fontesCadastro = []fonteCadastro{
+	{"csvs/fi_padronized/cad_fi.csv", "cnpj_fundo_classe", "denom_social", "tp_fundo_classe", "sit"},
+	{"csvs/fi_padronized/registro_fundo.csv", "cnpj_fundo", "denominacao_social", "tipo_fundo", "situacao"},
+	{"csvs/fi_padronized/registro_classe.csv", "cnpj_classe", "denominacao_social", "tipo_classe", "situacao"},
+}
+
+// tiposInformeObrigatorio são os tipos do cadastro que devem entregar o informe de
+// cada dataset todo mês; datasets fora daqui não têm a checagem de ativos sem informe
+var tiposInformeObrigatorio = map[string][]string{
+	"inf_diario": {"FI", "FIF"},
+	"cda":        {"FI", "FIF"},
+	"fidc":       {"FIDC", "FIDC-NP"},
+}
+
+// tabelaCadastroFundos é a tabela referenciada pelas chaves estrangeiras (--foreign-keys)
+const tabelaCadastroFundos = "cadastro_fundos"
+
+// fundoCadastro é um CNPJ do cadastro, já na forma formatada
+type fundoCadastro struct {
+	CNPJ     string `json:"cnpj"`
+	Nome     string `json:"nome,omitempty"`
+	Tipo     string `json:"tipo,omitempty"`
+	Situacao string `json:"situacao,omitempty"`
+	Fonte    string `json:"fonte"`
+	ativo    bool
+}
+
+// lerCadastroFundos junta as fontes de cadastro disponíveis; fontes ausentes são ignoradas
+func lerCadastroFundos() (map[string]fundoCadastro, error) {
+	fundos := map[string]fundoCadastro{}
+	lidas := 0
+	for _, fonte := range fontesCadastro {
+		if _, err := os.Stat(fonte.arquivo); err != nil {
+			continue
+		}
+		if err := fonte.ler(fundos); err != nil {
+			return nil, err
+		}
+		lidas++
+	}
+	if lidas == 0 {
+		return nil, fmt.Errorf("nenhum cadastro padronizado encontrado (rode padronize com --dataset cad_fi,registro_fi)")
+	}
+	return fundos, nil
+}
+
+func (fonte fonteCadastro) ler(fundos map[string]fundoCadastro) error {
+	f, reader, header, err := abrirCSVCarga(fonte.arquivo)
+	if err != nil {
+		return fmt.Errorf("erro ao abrir %s: %w", fonte.arquivo, err)
+	}
+	defer f.Close()
+
+	idx := map[string]int{}
+	for i, h := range header {
+		idx[h] = i
+	}
+	iCNPJ, ok := idx[fonte.cnpj]
+	if !ok {
+		return fmt.Errorf("coluna %s ausente em %s", fonte.cnpj, fonte.arquivo)
+	}
+	campo := func(record []string, coluna string) string {
+		if i, ok := idx[coluna]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("erro ao ler %s: %w", fonte.arquivo, err)
+		}
+		c, err := parseCNPJ(record[iCNPJ])
+		if err != nil {
+			continue
+		}
+		situacao := campo(record, fonte.situacao)
+		fundos[c.formatado()] = fundoCadastro{
+			CNPJ:     c.formatado(),
+			Nome:     campo(record, fonte.nome),
+			Tipo:     strings.ToUpper(campo(record, fonte.tipo)),
+			Situacao: situacao,
+			Fonte:    fonte.arquivo,
+			ativo:    strings.Contains(strings.ToUpper(situacao), "FUNCIONAMENTO NORMAL"),
+		}
+	}
+	return nil
+}
+
+// orfaoInforme é um CNPJ de um arquivo de informe que não está no cadastro
+type orfaoInforme struct {
+	CNPJ    string `json:"cnpj"`
+	Arquivo string `json:"arquivo"`
+	Linhas  int    `json:"linhas"`
+}
+
+// relatorioReconciliacao é o resultado de um dataset numa competência
+// (csvs/_reconciliacao/<dataset>_<AAAAMM>.json)
+type relatorioReconciliacao struct {
+	Dataset          string          `json:"dataset"`
+	Competencia      string          `json:"competencia"`
+	Arquivos         []string        `json:"arquivos"`
+	CNPJsInforme     int             `json:"cnpjs_informe"`
+	SemCadastro      []orfaoInforme  `json:"sem_cadastro"`
+	AtivosSemInforme []fundoCadastro `json:"ativos_sem_informe,omitempty"`
+	GeradoEm         time.Time       `json:"gerado_em"`
+}
+
+// dirReconciliacao guarda os relatórios de reconciliação
+var dirReconciliacao = "csvs/_reconciliacao"
+
+// reconciliar compara os CNPJ_FUNDO_CLASSE dos arquivos a carregar de um dataset
+// com o cadastro, por competência: CNPJs sem cadastro e, para os tipos de
+// tiposInformeObrigatorio, fundos ativos sem nenhum informe no mês
+func reconciliar(dataset string, alvos []loadTarget, fundos map[string]fundoCadastro) error {
+	porMes := map[string][]string{}
+	var meses []string
+	for _, alvo := range alvos {
+		if _, err := os.Stat(alvo.file); err != nil {
+			continue
+		}
+		competencia := "sem_competencia"
+		if mes, ok := mesDoArquivo(alvo.file); ok {
+			competencia = mes.Format("200601")
+		}
+		if _, ok := porMes[competencia]; !ok {
+			meses = append(meses, competencia)
+		}
+		porMes[competencia] = append(porMes[competencia], alvo.file)
+	}
+	sort.Strings(meses)
+
+	for _, competencia := range meses {
+		rel := relatorioReconciliacao{Dataset: dataset, Competencia: competencia,
+			Arquivos: porMes[competencia], SemCadastro: []orfaoInforme{}, GeradoEm: time.Now()}
+
+		vistos := map[string]bool{}
+		for _, arquivo := range porMes[competencia] {
+			contagem, err := cnpjsDoInforme(arquivo)
+			if err != nil {
+				return err
+			}
+			var cnpjs []string
+			for c := range contagem {
+				cnpjs = append(cnpjs, c)
+			}
+			sort.Strings(cnpjs)
+			for _, c := range cnpjs {
+				vistos[c] = true
+				if _, ok := fundos[c]; !ok {
+					rel.SemCadastro = append(rel.SemCadastro, orfaoInforme{CNPJ: c, Arquivo: arquivo, Linhas: contagem[c]})
+				}
+			}
+		}
+		rel.CNPJsInforme = len(vistos)
+
+		if tipos := tiposInformeObrigatorio[dataset]; len(tipos) > 0 && competencia != "sem_competencia" {
+			for _, f := range fundos {
+				if f.ativo && slices.Contains(tipos, f.Tipo) && !vistos[f.CNPJ] {
+					rel.AtivosSemInforme = append(rel.AtivosSemInforme, f)
+				}
+			}
+			sort.Slice(rel.AtivosSemInforme, func(i, j int) bool {
+				return rel.AtivosSemInforme[i].CNPJ < rel.AtivosSemInforme[j].CNPJ
+			})
+		}
+
+		path := filepath.Join(dirReconciliacao, fmt.Sprintf("%s_%s.json", dataset, competencia))
+		if err := writeAtomic(path, 0644, func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(rel)
+		}); err != nil {
+			return fmt.Errorf("erro ao gravar %s: %w", path, err)
+		}
+		fmt.Printf("  %s %s: %d CNPJs, %d sem cadastro, %d ativos sem informe (%s)\n",
+			dataset, competencia, rel.CNPJsInforme, len(rel.SemCadastro), len(rel.AtivosSemInforme), path)
+	}
+	return nil
+}
+
+// cnpjsDoInforme conta as linhas de cada CNPJ_FUNDO_CLASSE (formatado) de um arquivo
+func cnpjsDoInforme(arquivo string) (map[string]int, error) {
+	f, reader, header, err := abrirCSVCarga(arquivo)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir %s: %w", arquivo, err)
+	}
+	defer f.Close()
+
+	idx := -1
+	for i, h := range header {
+		if h == "cnpj_fundo_classe" {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("coluna CNPJ_FUNDO_CLASSE ausente em %s", arquivo)
+	}
+
+	contagem := map[string]int{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler %s: %w", arquivo, err)
+		}
+		if c, err := parseCNPJ(record[idx]); err == nil {
+			contagem[c.formatado()]++
+		}
+	}
+	return contagem, nil
+}
+
+// sincronizarCadastroFundos grava o cadastro em cadastro_fundos, a tabela
+// referenciada pelas chaves estrangeiras. Linhas nunca são apagadas: um fundo
+// que saiu do cadastro continua referenciado pelos informes antigos.
+func sincronizarCadastroFundos(db *sql.DB, fundos map[string]fundoCadastro) error {
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		cnpj TEXT PRIMARY KEY,
+		nome TEXT,
+		tipo TEXT,
+		situacao TEXT,
+		atualizado_em TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`, qi(tabelaCadastroFundos))); err != nil {
+		return fmt.Errorf("erro ao criar %s: %w", tabelaCadastroFundos, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf(`INSERT INTO %s (cnpj, nome, tipo, situacao) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (cnpj) DO UPDATE SET nome = EXCLUDED.nome, tipo = EXCLUDED.tipo,
+		situacao = EXCLUDED.situacao, atualizado_em = now()`, qi(tabelaCadastroFundos)))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, f := range fundos {
+		if _, err := stmt.Exec(f.CNPJ, f.Nome, f.Tipo, f.Situacao); err != nil {
+			return fmt.Errorf("erro ao gravar %s em %s: %w", f.CNPJ, tabelaCadastroFundos, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Printf("✓ %d fundos sincronizados em '%s'\n", len(fundos), tabelaCadastroFundos)
+	return nil
+}
+
+// garantirChaveEstrangeira liga cnpj_fundo_classe da tabela a cadastro_fundos.
+// NOT VALID não confere as linhas antigas; as novas já chegam filtradas pela
+// carga (configCarga.cadastro).
+func garantirChaveEstrangeira(db *sql.DB, tableName string) error {
+	nome := truncarIdentificador(tableName, "_cnpj_fk")
+	var existe bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = $1 AND conrelid = $2::regclass)`,
+		nome, qi(tableName)).Scan(&existe); err != nil {
+		return err
+	}
+	if existe {
+		return nil
+	}
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (cnpj_fundo_classe) REFERENCES %s (cnpj) NOT VALID",
+		qi(tableName), qi(nome), qi(tabelaCadastroFundos)))
+	if err != nil {
+		return fmt.Errorf("erro ao criar a chave estrangeira de %s: %w", tableName, err)
+	}
+	fmt.Printf("✓ Chave estrangeira %s criada em '%s'\n", nome, tableName)
+	return nil
+}
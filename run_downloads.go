@@ -1,444 +1,279 @@
 package main
 
 import (
+	"archive/zip"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
 type Job struct {
-	ano  int
-	mes  int
-	url  string
-	file string
-	dest string
-	aux  string
+	dataset string
+	tipo    tipoArquivo
+	ano     int
+	mes     int
+	url     string
+	file    string
+	dest    string
+	aux     string
+	membros []string
 }
 
-func runDownloads(anos []int, objetoBuscado []string, historico bool) {
-	var jobs []Job
-
+// runDownloadsDataset baixa um dataset do registro para os anos/meses pedidos
+func runDownloadsDataset(nome string, anos, meses []int, historico bool) (downloadReport, error) {
+	ds, err := datasetPorNome(nome)
+	if err != nil {
+		return downloadReport{}, err
+	}
 	if historico {
-		for _, objeto := range objetoBuscado {
-			for _, ano := range anos {
-				url := fmt.Sprintf("https://dados.cvm.gov.br/dados/FI/DOC/%s/DADOS/HIST/%s_fi_%d.zip", objeto, objeto, ano)
-				output := fmt.Sprintf("%s_fi_%d.zip", objeto, ano)
-				dest := "csvs/" + objeto
-
-				jobs = append(jobs, Job{
-					ano:  ano,
-					url:  url,
-					file: output,
-					dest: dest,
-				})
-			}
-		}
-	} else {
-		for _, objeto := range objetoBuscado {
-			for _, ano := range anos {
-				for mes := 12; mes >= 1; mes-- {
-					url := fmt.Sprintf("https://dados.cvm.gov.br/dados/FI/DOC/%s/DADOS/%s_fi_%d%02d.zip", objeto, objeto, ano, mes)
-					output := fmt.Sprintf("%s_fi_%d%02d.zip", objeto, ano, mes)
-					dest := "csvs/" + objeto
-
-					jobs = append(jobs, Job{
-						ano:  ano,
-						mes:  mes,
-						url:  url,
-						file: output,
-						dest: dest,
-					})
-				}
-			}
+		jobs, err := ds.jobsHistorico(anos)
+		if err != nil {
+			return downloadReport{}, err
 		}
+		return executeJobs(jobs), nil
 	}
+	return executeJobs(buildJobs(ds, anos, meses)), nil
+}
 
-	const maxWorkers = 12
-	sem := make(chan struct{}, maxWorkers)
-	var wg sync.WaitGroup
-
-	for _, job := range jobs {
-		wg.Add(1)
-		go func(job Job) {
-			defer wg.Done()
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			err := downloadFile(job.url, job.file)
-			if err != nil {
-				fmt.Println("Erro download:", err)
-				if err := os.Remove(job.file); err != nil {
-					fmt.Printf("Erro ao excluir o arquivo %s: %v, tentando novamente...\n", job.file, err)
-					if err := os.Remove(job.file); err != nil {
-						fmt.Printf("Erro ao excluir o arquivo %s: %v\n", job.file, err)
-					} else {
-						fmt.Printf("Arquivo %s excluído com sucesso.\n", job.file)
-					}
-				} else {
-					fmt.Printf("Arquivo %s excluído com sucesso.\n", job.file)
-				}
-				return
-			}
+// jobStatus é o resultado final de um job de download
+type jobStatus int
 
-			err = unzip(job.file, job.dest)
-			if err != nil {
-				fmt.Println("Erro unzip:", err)
-				return
-			}
-			fmt.Printf("Arquivo %s descompactado em: %s\n", job.file, job.dest)
+const (
+	jobSucesso       jobStatus = iota
+	jobNaoPublicado            // 404: o mês ainda não foi publicado, não é erro
+	jobNaoModificado           // 304: o arquivo local já está atualizado
+	jobFalhou
+)
 
-			if err := os.Remove(job.file); err != nil {
-				fmt.Printf("Erro ao excluir o arquivo %s: %v\n", job.file, err)
-			} else {
-				fmt.Printf("Arquivo %s excluído com sucesso.\n", job.file)
-			}
-		}(job)
+func (s jobStatus) String() string {
+	switch s {
+	case jobSucesso:
+		return "sucesso"
+	case jobNaoPublicado:
+		return "não publicado"
+	case jobNaoModificado:
+		return "não modificado"
+	default:
+		return "falhou"
 	}
-
-	wg.Wait()
-	fmt.Println("Todos os downloads concluídos.")
 }
 
-// Download de arquivos na aba "HIST"
-func runDownloadsHistorico(anos []int, objetoBuscado []string) {
-	var jobs []Job
+// jobResult guarda o que aconteceu com um job
+type jobResult struct {
+	job     Job
+	status  jobStatus
+	err     error
+	bytes   int64
+	duracao time.Duration
+}
 
-	for _, objeto := range objetoBuscado {
-		for _, ano := range anos {
-			url := fmt.Sprintf("https://dados.cvm.gov.br/dados/FI/DOC/%s/DADOS/HIST/%s_fi_%d.zip", objeto, objeto, ano)
-			output := fmt.Sprintf("%s_fi_%d.zip", objeto, ano)
-			dest := "csvs/" + objeto
+// downloadReport agrega os resultados de uma execução de jobs
+type downloadReport struct {
+	resultados []jobResult
+	duracao    time.Duration
+}
 
-			jobs = append(jobs, Job{
-				ano:  ano,
-				url:  url,
-				file: output,
-				dest: dest,
-			})
+// porStatus filtra os resultados com o status informado
+func (r downloadReport) porStatus(status jobStatus) []jobResult {
+	var out []jobResult
+	for _, res := range r.resultados {
+		if res.status == status {
+			out = append(out, res)
 		}
 	}
+	return out
+}
 
-	const maxWorkers = 12
-	sem := make(chan struct{}, maxWorkers)
-	var wg sync.WaitGroup
-
-	for _, job := range jobs {
-		wg.Add(1)
-		go func(job Job) {
-			defer wg.Done()
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			err := downloadFile(job.url, job.file)
-			if err != nil {
-				fmt.Println("Erro download:", err)
-				if err := os.Remove(job.file); err != nil {
-					fmt.Printf("Erro ao excluir o arquivo %s: %v, tentando novamente...\n", job.file, err)
-					if err := os.Remove(job.file); err != nil {
-						fmt.Printf("Erro ao excluir o arquivo %s: %v\n", job.file, err)
-					} else {
-						fmt.Printf("Arquivo %s excluído com sucesso.\n", job.file)
-					}
-				} else {
-					fmt.Printf("Arquivo %s excluído com sucesso.\n", job.file)
-				}
-				return
-			}
-
-			err = unzip(job.file, job.dest)
-			if err != nil {
-				fmt.Println("Erro unzip:", err)
-				return
-			}
-			fmt.Printf("Arquivo %s descompactado em: %s\n", job.file, job.dest)
+func (r downloadReport) sucessos() []jobResult       { return r.porStatus(jobSucesso) }
+func (r downloadReport) naoPublicados() []jobResult  { return r.porStatus(jobNaoPublicado) }
+func (r downloadReport) naoModificados() []jobResult { return r.porStatus(jobNaoModificado) }
+func (r downloadReport) falhas() []jobResult         { return r.porStatus(jobFalhou) }
 
-			if err := os.Remove(job.file); err != nil {
-				fmt.Printf("Erro ao excluir o arquivo %s: %v\n", job.file, err)
-			} else {
-				fmt.Printf("Arquivo %s excluído com sucesso.\n", job.file)
-			}
-		}(job)
+// totalBytes soma os bytes baixados por todos os jobs
+func (r downloadReport) totalBytes() int64 {
+	var total int64
+	for _, res := range r.resultados {
+		total += res.bytes
 	}
-
-	wg.Wait()
-	fmt.Println("Todos os downloads concluídos.")
+	return total
 }
 
-func runDownloadsFIDC(anos []int, objetoBuscado []string) {
-	var jobs []Job
-
-	for _, objeto := range objetoBuscado {
-		for _, ano := range anos {
-			for mes := 12; mes >= 1; mes-- {
-				url := fmt.Sprintf("https://dados.cvm.gov.br/dados/%s/DOC/INF_MENSAL/DADOS/inf_mensal_%s_%d%02d.zip", objeto, objeto, ano, mes)
-				output := fmt.Sprintf("%s_fi_%d%02d.zip", objeto, ano, mes)
-				dest := "csvs/" + objeto
+// err junta os erros de todos os jobs que falharam (nil se nenhum falhou)
+func (r downloadReport) err() error {
+	var errs []error
+	for _, res := range r.falhas() {
+		errs = append(errs, fmt.Errorf("%s: %w", res.job.url, res.err))
+	}
+	return errors.Join(errs...)
+}
 
-				jobs = append(jobs, Job{
-					ano:  ano,
-					mes:  mes,
-					url:  url,
-					file: output,
-					dest: dest,
-				})
-			}
-		}
+// resumo imprime as contagens do relatório e as falhas, se houver
+func (r downloadReport) resumo() {
+	fmt.Printf("Downloads: %d concluídos, %d sem alterações, %d ainda não publicados, %d com erro (%.1f MB em %s)\n",
+		len(r.sucessos()), len(r.naoModificados()), len(r.naoPublicados()), len(r.falhas()),
+		float64(r.totalBytes())/(1<<20), r.duracao.Round(time.Second))
+	for _, res := range r.falhas() {
+		fmt.Printf("  ✗ %s: %v\n", res.job.url, res.err)
 	}
+}
 
+// executeJobs executa os jobs com no máximo 12 downloads simultâneos e devolve
+// o relatório; cabe a quem chamou decidir se as falhas interrompem o pipeline
+func executeJobs(jobs []Job) downloadReport {
 	const maxWorkers = 12
 	sem := make(chan struct{}, maxWorkers)
 	var wg sync.WaitGroup
 
-	for _, job := range jobs {
+	manifest, err := loadManifest(manifestPath)
+	if err != nil {
+		fmt.Println("Erro ao ler manifesto, baixando tudo:", err)
+	}
+
+	inicio := time.Now()
+	resultados := make([]jobResult, len(jobs))
+	for i, job := range jobs {
 		wg.Add(1)
-		go func(job Job) {
+		go func(i int, job Job) {
 			defer wg.Done()
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			err := downloadFile(job.url, job.file)
-			if err != nil {
-				fmt.Println("Erro download:", err)
-				if err := os.Remove(job.file); err != nil {
-					fmt.Printf("Erro ao excluir o arquivo %s: %v\n", job.file, err)
-				} else {
-					fmt.Printf("Arquivo %s excluído com sucesso.\n", job.file)
+			ini := time.Now()
+			// um zip corrompido não pode derrubar o pool inteiro
+			defer func() {
+				if r := recover(); r != nil {
+					resultados[i] = jobResult{job: job, status: jobFalhou,
+						err: fmt.Errorf("panic: %v", r), duracao: time.Since(ini)}
 				}
-				return
-			}
-
-			err = unzip(job.file, job.dest)
-			if err != nil {
-				fmt.Println("Erro unzip:", err)
-				return
+			}()
+			n, err := processJob(job, manifest)
+			res := jobResult{job: job, bytes: n, err: err, duracao: time.Since(ini)}
+			switch {
+			case err == nil:
+				res.status = jobSucesso
+			case errors.Is(err, errNaoPublicado):
+				res.status = jobNaoPublicado
+			case errors.Is(err, errNaoModificado):
+				res.status = jobNaoModificado
+			default:
+				res.status = jobFalhou
 			}
-			fmt.Printf("Arquivo %s descompactado em: %s\n", job.file, job.dest)
-
-			if err := os.Remove(job.file); err != nil {
-				fmt.Printf("Erro ao excluir o arquivo %s: %v\n", job.file, err)
-			} else {
-				fmt.Printf("Arquivo %s excluído com sucesso.\n", job.file)
-			}
-		}(job)
+			resultados[i] = res
+		}(i, job)
 	}
 
 	wg.Wait()
-	fmt.Println("Todos os downloads concluídos.")
+	if err := manifest.save(); err != nil {
+		fmt.Println("Erro ao gravar manifesto:", err)
+	}
+	report := downloadReport{resultados: resultados, duracao: time.Since(inicio)}
+	report.resumo()
+	return report
 }
 
-func runDownloadsFIP(anos []int, objetoBuscado []string) {
-	var jobs []Job
+// processJob baixa o arquivo do job e descompacta (zip) ou grava (csv) em job.dest.
+// Nada é gravado no diretório de trabalho: o download fica em memória ou em
+// scratchDir e é removido ao final, mesmo em caso de panic.
+// Se o manifesto indicar que nada mudou, devolve errNaoModificado sem mexer em job.dest.
+func processJob(job Job, manifest *downloadManifest) (int64, error) {
+	spool := newSpoolBuffer()
+	defer func() {
+		if err := spool.Close(); err != nil {
+			fmt.Printf("Erro ao limpar o temporário de %s: %v\n", job.file, err)
+		}
+	}()
 
-	for _, objeto := range objetoBuscado {
-		for _, ano := range anos {
-			periodicidade_informe := "inf_trimestral"
-			if ano > 2023 {
-				periodicidade_informe = "inf_quadrimestral"
-			}
-			url := fmt.Sprintf("https://dados.cvm.gov.br/dados/%s/DOC/%s/DADOS/%s_%s_%d.csv", objeto, periodicidade_informe, periodicidade_informe, objeto, ano)
-			output := fmt.Sprintf("%s_%s_%d.csv", periodicidade_informe, objeto, ano)
-			dest := "csvs/" + objeto
+	entry, err := downloadFile(job.url, spool, manifest.anterior(job))
+	n := entry.Tamanho
+	if err != nil {
+		return n, err
+	}
 
-			jobs = append(jobs, Job{
-				ano:  ano,
-				mes:  01,
-				url:  url,
-				file: output,
-				dest: dest,
-				aux:  fmt.Sprintf("%s_%s_%d.csv", "inf_tri_quadri", objeto, ano),
-			})
+	ra, size := spool.ReaderAt()
+	if job.tipo == arquivoCsv {
+		destino := filepath.Join(job.dest, job.aux)
+		if err := writeFileAtomic(destino, io.NewSectionReader(ra, 0, size), 0644); err != nil {
+			return n, fmt.Errorf("erro ao gravar %s: %w", destino, err)
 		}
+		fmt.Printf("Arquivo %s gravado em: %s\n", job.file, job.dest)
+		entry.Membros = []string{job.aux}
+		manifest.set(entry)
+		return n, nil
 	}
 
-	const maxWorkers = 12
-	sem := make(chan struct{}, maxWorkers)
-	var wg sync.WaitGroup
-
-	for _, job := range jobs {
-		wg.Add(1)
-		go func(job Job) {
-			defer wg.Done()
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			err := downloadFile(job.url, job.file)
-			if err != nil {
-				fmt.Println("Erro download:", err)
-				if err := os.Remove(job.file); err != nil {
-					fmt.Printf("Erro ao excluir o arquivo %s: %v\n", job.file, err)
-				} else {
-					fmt.Printf("Arquivo %s excluído com sucesso.\n", job.file)
-				}
-				return
-			}
-
-			// err = unzip(job.file, job.dest)
-			// if err != nil {
-			// 	fmt.Println("Erro unzip:", err)
-			// 	return
-			// }
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return n, fmt.Errorf("erro unzip %s: %w", job.file, err)
+	}
+	if entry.Membros, err = extrairZip(zr, job.dest); err != nil {
+		return n, fmt.Errorf("erro unzip %s: %w", job.file, err)
+	}
+	fmt.Printf("Arquivo %s descompactado em: %s\n", job.file, job.dest)
+	manifest.set(entry)
 
-			if _, err := os.Stat(job.dest); os.IsNotExist(err) {
-				err = os.MkdirAll(job.dest, 0755)
-				if err != nil {
-					fmt.Println("Erro ao criar diretório:", err)
-					return
-				}
-			}
+	return n, nil
+}
 
-			err = os.Rename(job.file, fmt.Sprintf("%s/%s", job.dest, job.aux))
-			if err != nil {
-				fmt.Println("Erro ao mover arquivo:", err)
-				return
-			}
+// runDownloads baixa datasets de FI/DOC (inf_diario, lamina, cda...) mês a mês
+func runDownloads(anos []int, objetoBuscado []string) downloadReport {
+	var jobs []Job
+	for _, objeto := range objetoBuscado {
+		ds, ok := cvmDatasets[objeto]
+		if !ok {
+			ds = fiDoc(objeto)
+		}
+		jobs = append(jobs, buildJobs(ds, anos, todosOsMeses)...)
+	}
+	return executeJobs(jobs)
+}
 
-			// err = os.Rename(fmt.Sprintf("%s/%s", job.dest, job.file), job.aux)
-			// if err != nil {
-			// 	fmt.Println("Erro ao mover arquivo:", err)
-			// 	return
-			// }
-			// os.MkdirAll(job.dest, 0755)
+func runDownloadsFIDC(anos []int, objetoBuscado []string) downloadReport {
+	return runDownloadsRegistro(anos, objetoBuscado)
+}
 
-			// os.Mkdir(job.dest, 0755)
-			fmt.Printf("Arquivo %s descompactado em: %s\n", job.file, job.dest)
+func runDownloadsFIP(anos []int, objetoBuscado []string) downloadReport {
+	return runDownloadsRegistro(anos, objetoBuscado)
+}
 
-			// if err := os.Remove(job.file); err != nil {
-			// 	fmt.Printf("Erro ao excluir o arquivo %s: %v\n", job.file, err)
-			// } else {
-			// 	fmt.Printf("Arquivo %s excluído com sucesso.\n", job.file)
-			// }
-		}(job)
+// runDownloadsRegistro junta os jobs de vários datasets do registro num único relatório
+func runDownloadsRegistro(anos []int, nomes []string) downloadReport {
+	var jobs []Job
+	for _, nome := range nomes {
+		ds, err := datasetPorNome(nome)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		jobs = append(jobs, buildJobs(ds, anos, todosOsMeses)...)
 	}
-
-	wg.Wait()
-	fmt.Println("Todos os downloads concluídos.")
+	return executeJobs(jobs)
 }
 
 // CNPJ	DENOM_SOCIAL	DENOM_COMERC	DT_REG	DT_CANCEL	MOTIVO_CANCEL	SIT	DT_INI_SIT	TP_ENDER	LOGRADOURO	COMPL	BAIRRO	MUN	UF	CEP	DDD	TEL	EMAIL
 // Informações sobre o cadastro dos ADM's dos FII (disponível em https://dados.cvm.gov.br/dados/ADM_FII/CAD/META/meta_cad_adm_fii.txt)
 // >> Modelo à ser utilizado quando for necessário baixar arquivos que não estejam compactados; Diretamente como .csv
 // tabs := []string{"adm_fii"} cadOuDoc := "cad"
-func downloadCsvDescompactado(tabs []string, cadOuDoc string) {
+func downloadCsvDescompactado(tabs []string, cadOuDoc string) downloadReport {
 	var jobs []Job
-
 	for _, tab := range tabs {
-		url := fmt.Sprintf("https://dados.cvm.gov.br/dados/%s/%s/DADOS/%s_%s.csv", tab, cadOuDoc, cadOuDoc, tab)
-		output := fmt.Sprintf("%s_%s.csv", cadOuDoc, tab)
-		dest := "csvs/" + tab
-
-		jobs = append(jobs, Job{
-			ano:  0000,
-			mes:  00,
-			url:  url,
-			file: output,
-			dest: dest,
-			aux:  fmt.Sprintf("%s_%s.csv", cadOuDoc, tab),
-		})
-	}
-
-	const maxWorkers = 12
-	sem := make(chan struct{}, maxWorkers)
-	var wg sync.WaitGroup
-
-	for _, job := range jobs {
-		wg.Add(1)
-		go func(job Job) {
-			defer wg.Done()
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			err := downloadFile(job.url, job.file)
-			if err != nil {
-				fmt.Println("Erro download:", err)
-				if err := os.Remove(job.file); err != nil {
-					fmt.Printf("Erro ao excluir o arquivo %s: %v\n", job.file, err)
-				} else {
-					fmt.Printf("Arquivo %s excluído com sucesso.\n", job.file)
-				}
-				return
-			}
-
-			if _, err := os.Stat(job.dest); os.IsNotExist(err) {
-				err = os.MkdirAll(job.dest, 0755)
-				if err != nil {
-					fmt.Println("Erro ao criar diretório:", err)
-					return
-				}
-			}
-
-			err = os.Rename(job.file, fmt.Sprintf("%s/%s", job.dest, job.aux))
-			if err != nil {
-				fmt.Println("Erro ao mover arquivo:", err)
-				return
-			}
-			fmt.Printf("Arquivo %s movido para: %s\n", job.file, job.dest)
-
-		}(job)
+		jobs = append(jobs, buildJobs(cadCsv(tab, cadOuDoc), nil, nil)...)
 	}
-
-	wg.Wait()
-	fmt.Println("Todos os downloads concluídos.")
+	return executeJobs(jobs)
 }
 
 // Download de CSV compactado, para arquivos que não possuem variação mensal ou anual
 // tabs := []string{"adm_fii"} cadOuDoc := "cad" aux := "cad_adm_fii"
-func downloadCsvCompactado(tabs []string, cadOuDoc string, aux string) {
-	var jobs []Job
+func downloadCsvCompactado(tabs []string, cadOuDoc string, aux string) downloadReport {
 	if aux == "" {
 		aux = cadOuDoc
 	}
 
+	var jobs []Job
 	for _, tab := range tabs {
-		url := fmt.Sprintf("https://dados.cvm.gov.br/dados/%s/%s/DADOS/%s.zip", tab, cadOuDoc, aux)
-		output := fmt.Sprintf("%s.zip", aux)
-		dest := "csvs/" + tab
-
-		jobs = append(jobs, Job{
-			ano:  0000,
-			mes:  00,
-			url:  url,
-			file: output,
-			dest: dest,
-		})
+		jobs = append(jobs, buildJobs(cadZip(aux, tab, cadOuDoc, aux), nil, nil)...)
 	}
-
-	const maxWorkers = 12
-	sem := make(chan struct{}, maxWorkers)
-	var wg sync.WaitGroup
-
-	for _, job := range jobs {
-		wg.Add(1)
-		go func(job Job) {
-			defer wg.Done()
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			err := downloadFile(job.url, job.file)
-			if err != nil {
-				fmt.Println("Erro download:", err)
-				if err := os.Remove(job.file); err != nil {
-					fmt.Printf("Erro ao excluir o arquivo %s: %v\n", job.file, err)
-				} else {
-					fmt.Printf("Arquivo %s excluído com sucesso.\n", job.file)
-				}
-				return
-			}
-
-			err = unzip(job.file, job.dest)
-			if err != nil {
-				fmt.Println("Erro unzip:", err)
-				return
-			}
-			fmt.Printf("Arquivo %s descompactado em: %s\n", job.file, job.dest)
-
-			if err := os.Remove(job.file); err != nil {
-				fmt.Printf("Erro ao excluir o arquivo %s: %v\n", job.file, err)
-			} else {
-				fmt.Printf("Arquivo %s excluído com sucesso.\n", job.file)
-			}
-		}(job)
-	}
-
-	wg.Wait()
-	fmt.Println("Todos os downloads concluídos.")
+	return executeJobs(jobs)
 }
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schemasEmbutidos são os schemas compilados no binário; um arquivo com o mesmo
+// nome em schemaDir tem precedência, para ajustar um layout sem recompilar
+//
+//go:embed schemas/*.json
+var schemasEmbutidos embed.FS
+
+// schemaDir é onde os schemas editáveis ficam (ex: schemas/inf_diario.json)
+var schemaDir = "schemas"
+
+// tipos aceitos em colunaSchema.Tipo; vazio não valida o valor (o tipo da
+// coluna no banco é inferido na carga)
+var tiposColuna = map[string]bool{"": true, "texto": true, "inteiro": true, "decimal": true, "data": true, "cnpj": true}
+
+// colunaSchema descreve uma coluna padronizada de um dataset
+type colunaSchema struct {
+	Nome   string  `json:"nome"`
+	Tipo   string  `json:"tipo,omitempty"`  // texto, inteiro, decimal, data (AAAA-MM-DD), cnpj ou vazio
+	Chave  bool    `json:"chave,omitempty"` // CNPJ que identifica o fundo: inválido recusa a linha
+	Nulo   bool    `json:"nulo"`
+	Padrao *string `json:"padrao,omitempty"` // valor usado quando o arquivo não traz a coluna
+	SQL    string  `json:"sql,omitempty"`    // tipo da coluna no banco, no lugar do que vem de Tipo
+}
+
+// varianteSchema ajusta o schema para os arquivos que começam com Prefixo:
+// Renomear soma-se ao do dataset e Colunas, se informado, substitui o do dataset
+type varianteSchema struct {
+	Prefixo  string            `json:"prefixo"`
+	Renomear map[string]string `json:"renomear"`
+	Colunas  []colunaSchema    `json:"colunas"`
+}
+
+// schemaDataset é o mapeamento declarativo de colunas de um dataset: renomeações,
+// valores padrão, tipo e nulidade. Colunas é a lista canônica: todo arquivo
+// padronizado do dataset sai com essas colunas, nessa ordem. Datasets com mais de
+// um tipo de arquivo (blocos do CDA, tabelas do FIDC) declaram uma lista por
+// variante. Regras são as verificações de qualidade aplicadas antes da carga
+// (ver qualidade.go).
+type schemaDataset struct {
+	Dataset   string            `json:"dataset"`
+	Renomear  map[string]string `json:"renomear"`
+	Colunas   []colunaSchema    `json:"colunas"`
+	Variantes []varianteSchema  `json:"variantes,omitempty"`
+	Regras    []regraQualidade  `json:"regras,omitempty"`
+}
+
+// carregarSchema lê schemas/<nome>.json do disco ou, se não existir, a cópia embutida
+func carregarSchema(nome string) (schemaDataset, error) {
+	var s schemaDataset
+
+	path := filepath.Join(schemaDir, nome+".json")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		data, err = schemasEmbutidos.ReadFile("schemas/" + nome + ".json")
+		if err != nil {
+			return s, fmt.Errorf("schema %q não encontrado", nome)
+		}
+	} else if err != nil {
+		return s, fmt.Errorf("erro ao ler schema %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("schema %s inválido: %w", path, err)
+	}
+	if err := s.validar(); err != nil {
+		return s, fmt.Errorf("schema %s inválido: %w", path, err)
+	}
+	return s, nil
+}
+
+// validar confere tipos conhecidos, colunas sem nome repetido e as regras
+func (s schemaDataset) validar() error {
+	for _, r := range s.Regras {
+		if err := r.validar(); err != nil {
+			return err
+		}
+	}
+	if len(s.Colunas) == 0 && len(s.Variantes) == 0 {
+		return fmt.Errorf("nenhuma coluna declarada")
+	}
+	listas := [][]colunaSchema{s.Colunas}
+	for _, v := range s.Variantes {
+		if v.Prefixo == "" {
+			return fmt.Errorf("variante sem prefixo")
+		}
+		if len(v.Colunas) == 0 && len(s.Colunas) == 0 {
+			return fmt.Errorf("variante %s sem colunas", v.Prefixo)
+		}
+		listas = append(listas, v.Colunas)
+	}
+	for _, colunas := range listas {
+		vistas := map[string]bool{}
+		for _, c := range colunas {
+			if c.Nome == "" {
+				return fmt.Errorf("coluna sem nome")
+			}
+			if vistas[c.Nome] {
+				return fmt.Errorf("coluna %s repetida", c.Nome)
+			}
+			vistas[c.Nome] = true
+			if !tiposColuna[c.Tipo] {
+				return fmt.Errorf("coluna %s: tipo %q desconhecido", c.Nome, c.Tipo)
+			}
+			if c.Chave && c.Tipo != "cnpj" {
+				return fmt.Errorf("coluna %s: chave só vale para colunas cnpj", c.Nome)
+			}
+			if c.SQL != "" {
+				if err := validarTipoSQL(c.SQL); err != nil {
+					return fmt.Errorf("coluna %s: %w", c.Nome, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// paraArquivo aplica a variante cujo prefixo casa com o nome do arquivo, se houver
+func (s schemaDataset) paraArquivo(arquivo string) schemaDataset {
+	nome := filepath.Base(arquivo)
+	for _, v := range s.Variantes {
+		if !strings.HasPrefix(nome, v.Prefixo) {
+			continue
+		}
+		out := schemaDataset{Dataset: s.Dataset, Renomear: map[string]string{}, Colunas: s.Colunas, Regras: s.Regras}
+		for k, val := range s.Renomear {
+			out.Renomear[k] = val
+		}
+		for k, val := range v.Renomear {
+			out.Renomear[k] = val
+		}
+		if v.Colunas != nil {
+			out.Colunas = v.Colunas
+		}
+		return out
+	}
+	return s
+}
+
+// temLayout diz se o schema declara as colunas do arquivo, direto ou por uma variante
+func (s schemaDataset) temLayout(arquivo string) bool {
+	return len(s.paraArquivo(arquivo).Colunas) > 0
+}
+
+// tipoSQL é o tipo da coluna no banco: SQL, se declarado, ou o equivalente de
+// Tipo; vazio deixa a carga inferir (decimal sem SQL vira NUMERIC sem limites)
+func (c colunaSchema) tipoSQL() string {
+	if c.SQL != "" {
+		return strings.ToUpper(c.SQL)
+	}
+	switch c.Tipo {
+	case "texto", "cnpj":
+		return "TEXT"
+	case "inteiro":
+		return "BIGINT"
+	case "decimal":
+		return "NUMERIC"
+	case "data":
+		return "DATE"
+	}
+	return ""
+}
+
+// tiposSQL devolve os tipos fixos das colunas do arquivo na carga, pelos nomes
+// já limpos (cleanColumnName); colunas sem tipo ficam de fora e são inferidas
+func (s schemaDataset) tiposSQL(arquivo string) map[string]string {
+	tipos := map[string]string{}
+	for _, c := range s.paraArquivo(arquivo).Colunas {
+		if t := c.tipoSQL(); t != "" {
+			tipos[cleanColumnName(c.Nome)] = t
+		}
+	}
+	return tipos
+}
+
+// cnpjChave devolve o nome limpo (cleanColumnName) da coluna chave do arquivo;
+// vazio se o schema não declara nenhuma
+func (s schemaDataset) cnpjChave(arquivo string) string {
+	for _, c := range s.paraArquivo(arquivo).Colunas {
+		if c.Chave {
+			return cleanColumnName(c.Nome)
+		}
+	}
+	return ""
+}
+
+// rejeicaoLinha é devolvida pelo callback do forEach para mandar a linha para a quarentena
+type rejeicaoLinha struct {
+	motivo string
+}
+
+func (e rejeicaoLinha) Error() string { return e.motivo }
+
+// avisoLinha é devolvido pelo callback do forEach quando a linha seguiu, mas com
+// valores anulados (ex: CNPJ do administrador inválido); vai para a quarentena como aviso
+type avisoLinha struct {
+	aviso  string
+	motivo string
+}
+
+func (e avisoLinha) Error() string { return e.motivo }
+
+// avisoCNPJInvalido marca, na quarentena, CNPJs fora da chave que foram anulados
+const avisoCNPJInvalido = "cnpj_invalido_anulado"
+
+// transformadorColunas aplica um schema linha a linha, sem carregar o arquivo
+type transformadorColunas struct {
+	header  []string
+	origem  []int    // índice da coluna na linha de origem; -1 usa padroes
+	padroes []string // valor das colunas que o arquivo não trouxe ("" se não houver padrão)
+	colunas []*colunaSchema
+
+	// extras são as colunas de origem fora do schema, mantidas no fim da saída;
+	// duplicadas são as repetidas no cabeçalho, das quais só a primeira é lida
+	extras     []string
+	duplicadas []string
+}
+
+// preparar monta o cabeçalho de saída na ordem canônica do dataset: as colunas do
+// schema, na ordem do schema (as que o arquivo não traz saem com o valor padrão
+// ou vazias), de modo que o layout de um mês é o mesmo antes ou depois da CVM 175.
+// Colunas de origem fora do schema não são descartadas: vão para o fim, em ordem
+// alfabética e sem validação, e ficam em extras para irem ao relatório de
+// quarentena até serem declaradas no schema.
+func (s schemaDataset) preparar(header []string) (*transformadorColunas, error) {
+	if len(s.Colunas) == 0 {
+		return nil, fmt.Errorf("nenhum layout declarado no schema %s para o arquivo", s.Dataset)
+	}
+
+	indices := map[string]int{}
+	for i, colName := range header {
+		if newName, ok := s.Renomear[colName]; ok {
+			colName = newName
+		}
+		if _, dup := indices[colName]; !dup {
+			indices[colName] = i
+		}
+	}
+
+	t := &transformadorColunas{}
+	usadas := map[int]bool{}
+	for i := range s.Colunas {
+		c := &s.Colunas[i]
+		idx, ok := indices[c.Nome]
+		switch {
+		case ok:
+			usadas[idx] = true
+		case c.Padrao == nil && !c.Nulo:
+			return nil, fmt.Errorf("coluna obrigatória %s ausente", c.Nome)
+		default:
+			idx = -1
+		}
+		t.header = append(t.header, c.Nome)
+		t.origem = append(t.origem, idx)
+		t.padroes = append(t.padroes, c.valorPadrao())
+		t.colunas = append(t.colunas, c)
+	}
+
+	for colName, i := range indices {
+		if !usadas[i] {
+			t.extras = append(t.extras, colName)
+		}
+	}
+	sort.Strings(t.extras)
+	for _, colName := range t.extras {
+		t.header = append(t.header, colName)
+		t.origem = append(t.origem, indices[colName])
+		t.padroes = append(t.padroes, "")
+		t.colunas = append(t.colunas, &colunaSchema{Nome: colName, Nulo: true})
+	}
+	for i, colName := range header {
+		if newName, ok := s.Renomear[colName]; ok {
+			colName = newName
+		}
+		if indices[colName] != i {
+			t.duplicadas = append(t.duplicadas, header[i])
+		}
+	}
+	return t, nil
+}
+
+func (c *colunaSchema) valorPadrao() string {
+	if c.Padrao == nil {
+		return ""
+	}
+	return *c.Padrao
+}
+
+// linha devolve a linha de saída correspondente a uma linha de origem, ou
+// rejeicaoLinha se algum valor violar o tipo ou a nulidade do schema. avisos
+// são os valores anulados (CNPJs inválidos fora da chave) de uma linha aceita.
+func (t *transformadorColunas) linha(row []string) ([]string, []string, error) {
+	out := make([]string, len(t.header))
+	var avisos []string
+	for i, idx := range t.origem {
+		if idx < 0 {
+			out[i] = t.padroes[i]
+		} else {
+			out[i] = row[idx]
+		}
+		v, aviso, err := t.colunas[i].aplicar(out[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if aviso != "" {
+			avisos = append(avisos, aviso)
+		}
+		out[i] = v
+	}
+	return out, avisos, nil
+}
+
+// aplicar confere um valor contra o tipo e a nulidade da coluna e devolve o
+// valor canônico: CNPJs saem formatados, os demais tipos como vieram. Um CNPJ
+// inválido recusa a linha só na coluna chave; nas demais o valor sai vazio e o
+// problema volta em aviso.
+func (c *colunaSchema) aplicar(valor string) (string, string, error) {
+	limpo := strings.TrimSpace(valor)
+	if limpo == "" {
+		if !c.Nulo {
+			return "", "", rejeicaoLinha{fmt.Sprintf("%s vazio", c.Nome)}
+		}
+		return valor, "", nil
+	}
+
+	var err error
+	switch c.Tipo {
+	case "inteiro":
+		_, err = strconv.ParseInt(limpo, 10, 64)
+	case "decimal":
+		_, err = strconv.ParseFloat(strings.Replace(limpo, ",", ".", 1), 64)
+	case "data":
+		_, err = time.Parse("2006-01-02", limpo)
+	case "cnpj":
+		var n cnpj
+		if n, err = parseCNPJ(limpo); err != nil {
+			if !c.Chave {
+				return "", fmt.Sprintf("%s: %v", c.Nome, err), nil
+			}
+			return "", "", rejeicaoLinha{fmt.Sprintf("%s: %v", c.Nome, err)}
+		}
+		return n.formatado(), "", nil
+	}
+	if err != nil {
+		return "", "", rejeicaoLinha{fmt.Sprintf("%s=%q não é %s", c.Nome, valor, c.Tipo)}
+	}
+	return valor, "", nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// tabelaMigracoes guarda o histórico de toda mudança de estrutura feita pelo database()
+const tabelaMigracoes = "etl_schema_migrations"
+
+// colunaTabela é uma coluna como deveria existir no banco para receber um CSV
+type colunaTabela struct {
+	nome       string
+	tipo       string
+	semAmostra bool // a amostra do CSV não tinha nenhum valor; o tipo é só um palpite
+}
+
+// ordemTipos é a ordem de alargamento: todo valor de um tipo cabe nos seguintes
+var ordemTipos = map[string]int{"INTEGER": 0, "BIGINT": 1, "NUMERIC": 2, "TEXT": 3}
+
+// digitosInteiros é quantos dígitos cada tipo inteiro precisa num NUMERIC(p,s)
+var digitosInteiros = map[string]int{"INTEGER": 10, "BIGINT": 19}
+
+// normalizarTipo converte o data_type do information_schema (ou o tipo inferido)
+// para os nomes usados em ordemTipos; NUMERIC(p,s) mantém precisão e escala
+func normalizarTipo(tipo string) string {
+	switch strings.ToLower(tipo) {
+	case "integer", "int", "int4":
+		return "INTEGER"
+	case "bigint", "int8":
+		return "BIGINT"
+	case "numeric", "decimal":
+		return "NUMERIC"
+	case "text", "character varying", "varchar":
+		return "TEXT"
+	case "boolean", "bool":
+		return "BOOLEAN"
+	case "timestamp with time zone", "timestamptz":
+		return "TIMESTAMPTZ"
+	case "timestamp without time zone", "timestamp":
+		return "TIMESTAMP"
+	}
+	return strings.ToUpper(strings.ReplaceAll(tipo, " ", ""))
+}
+
+// precisaoNumeric lê p e s de "NUMERIC(p,s)"; ok=false para NUMERIC sem limites
+func precisaoNumeric(tipo string) (p, s int, ok bool) {
+	if _, err := fmt.Sscanf(tipo, "NUMERIC(%d,%d)", &p, &s); err != nil {
+		return 0, 0, false
+	}
+	return p, s, true
+}
+
+// familia devolve o tipo sem precisão, ex: NUMERIC(18,6) → NUMERIC
+func familia(tipo string) string {
+	base, _, _ := strings.Cut(tipo, "(")
+	return base
+}
+
+// tipoMaisLargo devolve o menor tipo que comporta os valores dos dois:
+// INTEGER → BIGINT → NUMERIC → TEXT, DATE → TIMESTAMP e, entre NUMERICs, a
+// maior parte inteira com a maior escala. O resto só convive com TEXT.
+func tipoMaisLargo(atual, novo string) string {
+	atual, novo = normalizarTipo(atual), normalizarTipo(novo)
+	if atual == novo {
+		return atual
+	}
+	fa, fn := familia(atual), familia(novo)
+
+	if (fa == "DATE" && fn == "TIMESTAMP") || (fa == "TIMESTAMP" && fn == "DATE") {
+		return "TIMESTAMP"
+	}
+	if fa == "TIMESTAMPTZ" && (fn == "DATE" || fn == "TIMESTAMP") {
+		return atual
+	}
+
+	a, okA := ordemTipos[fa]
+	n, okN := ordemTipos[fn]
+	if !okA || !okN {
+		return "TEXT"
+	}
+	if fa != "NUMERIC" && fn != "NUMERIC" {
+		if n > a {
+			return novo
+		}
+		return atual
+	}
+	if fa == "TEXT" || fn == "TEXT" {
+		return "TEXT"
+	}
+
+	// ao menos um é NUMERIC: junta parte inteira e escala dos dois
+	inteiros, escala := 0, 0
+	for _, t := range []string{atual, novo} {
+		if d, ok := digitosInteiros[t]; ok {
+			inteiros = max(inteiros, d)
+			continue
+		}
+		p, s, ok := precisaoNumeric(t)
+		if !ok {
+			return "NUMERIC" // NUMERIC sem limites já comporta tudo
+		}
+		inteiros = max(inteiros, p-s)
+		escala = max(escala, s)
+	}
+	return fmt.Sprintf("NUMERIC(%d,%d)", max(1, inteiros+escala), escala)
+}
+
+// expressaoConversao é o USING do ALTER COLUMN TYPE. BOOLEAN só vira BOOLEAN
+// porque a coluna era S/N no CSV: ao alargar para TEXT volta a S/N, senão a
+// coluna misturaria 'true'/'false' das cargas antigas com 'S'/'N' das novas.
+func expressaoConversao(coluna, atual, novo string) string {
+	if familia(normalizarTipo(atual)) == "BOOLEAN" && novo == "TEXT" {
+		return fmt.Sprintf("CASE WHEN %s THEN 'S' WHEN NOT %s THEN 'N' END", qi(coluna), qi(coluna))
+	}
+	return fmt.Sprintf("%s::%s", qi(coluna), novo)
+}
+
+// consultor é o que *sql.DB e *sql.Tx têm em comum para consultas
+type consultor interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// colunasDaTabela lê as colunas existentes da tabela no schema atual; vazio se a tabela não existe
+func colunasDaTabela(db consultor, tableName string) (map[string]string, error) {
+	rows, err := db.Query(`SELECT column_name, data_type, numeric_precision, numeric_scale
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1`, strings.ToLower(tableName))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler colunas de %s: %w", tableName, err)
+	}
+	defer rows.Close()
+
+	colunas := map[string]string{}
+	for rows.Next() {
+		var nome, tipo string
+		var precisao, escala sql.NullInt64
+		if err := rows.Scan(&nome, &tipo, &precisao, &escala); err != nil {
+			return nil, err
+		}
+		tipo = normalizarTipo(tipo)
+		if tipo == "NUMERIC" && precisao.Valid {
+			tipo = fmt.Sprintf("NUMERIC(%d,%d)", precisao.Int64, escala.Int64)
+		}
+		colunas[nome] = tipo
+	}
+	return colunas, rows.Err()
+}
+
+// registrarMigracao grava uma mudança de estrutura no histórico
+func registrarMigracao(tx *sql.Tx, tabela, coluna, operacao, tipoAnterior, tipoNovo, arquivo string) error {
+	_, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (tabela, coluna, operacao, tipo_anterior, tipo_novo, arquivo)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)`, qi(tabelaMigracoes)),
+		tabela, coluna, operacao, tipoAnterior, tipoNovo, arquivo)
+	return err
+}
+
+// criarTabelaMigracoes cria o histórico de migrações; chamado uma vez por conexão (newLoader)
+func criarTabelaMigracoes(db *sql.DB) error {
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id SERIAL PRIMARY KEY,
+		tabela TEXT NOT NULL,
+		coluna TEXT,
+		operacao TEXT NOT NULL,
+		tipo_anterior TEXT,
+		tipo_novo TEXT,
+		arquivo TEXT,
+		aplicado_em TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`, qi(tabelaMigracoes))); err != nil {
+		return fmt.Errorf("erro ao criar %s: %w", tabelaMigracoes, err)
+	}
+	return nil
+}
+
+// evoluirTabela cria a tabela ou ajusta a existente para receber o CSV: colunas
+// novas são adicionadas e tipos que não comportam os valores são alargados
+// (INTEGER → BIGINT → NUMERIC → TEXT). Tudo numa transação, com cada mudança
+// registrada em etl_schema_migrations.
+func evoluirTabela(db *sql.DB, tableName, csvFile string, colunas []colunaTabela) error {
+	existentes, err := colunasDaTabela(db, tableName)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(existentes) == 0 {
+		var defs []string
+		for _, c := range colunas {
+			defs = append(defs, fmt.Sprintf("%s %s", qi(c.nome), c.tipo))
+		}
+		createSQL := fmt.Sprintf("CREATE TABLE %s (\n  %s\n)", qi(tableName), strings.Join(defs, ",\n  "))
+		if _, err := tx.Exec(createSQL); err != nil {
+			return fmt.Errorf("erro ao criar tabela %s: %w", tableName, err)
+		}
+		if err := registrarMigracao(tx, tableName, "", "create_table", "", "", csvFile); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Tabela '%s' criada com %d colunas\n", tableName, len(colunas))
+		fmt.Println("\nEstrutura da tabela:")
+		fmt.Println(createSQL)
+		fmt.Println()
+		return tx.Commit()
+	}
+
+	mudancas := 0
+	for _, c := range colunas {
+		atual, ok := existentes[c.nome]
+		if !ok {
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", qi(tableName), qi(c.nome), c.tipo)); err != nil {
+				return fmt.Errorf("erro ao adicionar coluna %s em %s: %w", c.nome, tableName, err)
+			}
+			if err := registrarMigracao(tx, tableName, c.nome, "add_column", "", c.tipo, csvFile); err != nil {
+				return err
+			}
+			fmt.Printf("✓ Coluna '%s' (%s) adicionada em '%s'\n", c.nome, c.tipo, tableName)
+			existentes[c.nome] = normalizarTipo(c.tipo)
+			mudancas++
+			continue
+		}
+		if c.semAmostra {
+			continue
+		}
+
+		novo := tipoMaisLargo(atual, c.tipo)
+		if novo == atual {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s",
+			qi(tableName), qi(c.nome), novo, expressaoConversao(c.nome, atual, novo))); err != nil {
+			return fmt.Errorf("erro ao alterar tipo de %s.%s para %s: %w", tableName, c.nome, novo, err)
+		}
+		if err := registrarMigracao(tx, tableName, c.nome, "alter_type", atual, novo, csvFile); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Coluna '%s' de '%s' alargada de %s para %s\n", c.nome, tableName, atual, novo)
+		existentes[c.nome] = novo
+		mudancas++
+	}
+
+	if mudancas == 0 {
+		fmt.Printf("✓ Tabela '%s' já comporta %s\n", tableName, csvFile)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestNormalizarTipo(t *testing.T) {
+	casos := map[string]string{
+		"integer":                     "INTEGER",
+		"int8":                        "BIGINT",
+		"numeric":                     "NUMERIC",
+		"character varying":           "TEXT",
+		"timestamp without time zone": "TIMESTAMP",
+		"timestamp with time zone":    "TIMESTAMPTZ",
+		"date":                        "DATE",
+		"NUMERIC(18, 6)":              "NUMERIC(18,6)",
+	}
+	for tipo, queria := range casos {
+		if got := normalizarTipo(tipo); got != queria {
+			t.Errorf("normalizarTipo(%q) = %q, queria %q", tipo, got, queria)
+		}
+	}
+}
+
+func TestTipoMaisLargo(t *testing.T) {
+	casos := []struct {
+		atual, novo, largo string
+	}{
+		{"INTEGER", "INTEGER", "INTEGER"},
+		{"integer", "BIGINT", "BIGINT"},
+		{"BIGINT", "INTEGER", "BIGINT"},
+		{"INTEGER", "NUMERIC(5,2)", "NUMERIC(12,2)"},
+		{"BIGINT", "NUMERIC(5,2)", "NUMERIC(21,2)"},
+		{"NUMERIC(10,2)", "NUMERIC(8,6)", "NUMERIC(14,6)"},
+		{"NUMERIC(4,0)", "NUMERIC(10,0)", "NUMERIC(10,0)"},
+		{"numeric", "NUMERIC(10,2)", "NUMERIC"},
+		{"NUMERIC(10,2)", "TEXT", "TEXT"},
+		{"INTEGER", "TEXT", "TEXT"},
+		{"DATE", "TIMESTAMP", "TIMESTAMP"},
+		{"timestamp without time zone", "DATE", "TIMESTAMP"},
+		{"timestamp with time zone", "DATE", "TIMESTAMPTZ"},
+		{"DATE", "INTEGER", "TEXT"},
+		{"BOOLEAN", "INTEGER", "TEXT"},
+		{"BOOLEAN", "BOOLEAN", "BOOLEAN"},
+	}
+	for _, c := range casos {
+		if got := tipoMaisLargo(c.atual, c.novo); got != c.largo {
+			t.Errorf("tipoMaisLargo(%q, %q) = %q, queria %q", c.atual, c.novo, got, c.largo)
+		}
+	}
+}
+
+func TestExpressaoConversao(t *testing.T) {
+	casos := []struct {
+		atual, novo, expressao string
+	}{
+		{"boolean", "TEXT", `CASE WHEN "st_ativo" THEN 'S' WHEN NOT "st_ativo" THEN 'N' END`},
+		{"integer", "BIGINT", `"st_ativo"::BIGINT`},
+		{"numeric", "TEXT", `"st_ativo"::TEXT`},
+	}
+	for _, c := range casos {
+		if got := expressaoConversao("st_ativo", c.atual, c.novo); got != c.expressao {
+			t.Errorf("expressaoConversao(%q, %q) = %s, queria %s", c.atual, c.novo, got, c.expressao)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaDatasetPreparar(t *testing.T) {
+	schema := schemaDataset{
+		Dataset:  "teste",
+		Renomear: map[string]string{"CNPJ_FUNDO": "CNPJ_FUNDO_CLASSE"},
+		Colunas: []colunaSchema{
+			{Nome: "CNPJ_FUNDO_CLASSE"},
+			{Nome: "ID_SUBCLASSE", Nulo: true},
+			{Nome: "VL_QUOTA", Tipo: "decimal", Nulo: true},
+		},
+	}
+	casos := []struct {
+		nome       string
+		header     []string
+		linha      []string
+		saida      []string
+		extras     []string
+		duplicadas []string
+		erro       string
+	}{
+		{"layout canônico", []string{"CNPJ_FUNDO_CLASSE", "ID_SUBCLASSE", "VL_QUOTA"}, []string{"a", "b", "1.5"},
+			[]string{"a", "b", "1.5"}, nil, nil, ""},
+		{"renomeada e ausente", []string{"VL_QUOTA", "CNPJ_FUNDO"}, []string{"1.5", "a"},
+			[]string{"a", "", "1.5"}, nil, nil, ""},
+		{"colunas novas vão para o fim em ordem alfabética", []string{"ZETA", "CNPJ_FUNDO_CLASSE", "ALFA", "VL_QUOTA"}, []string{"z", "a", "x", "1.5"},
+			[]string{"a", "", "1.5", "x", "z"}, []string{"ALFA", "ZETA"}, nil, ""},
+		{"coluna repetida", []string{"CNPJ_FUNDO_CLASSE", "VL_QUOTA", "VL_QUOTA"}, []string{"a", "1.5", "2.5"},
+			[]string{"a", "", "1.5"}, nil, []string{"VL_QUOTA"}, ""},
+		{"obrigatória ausente", []string{"VL_QUOTA"}, nil, nil, nil, nil, "CNPJ_FUNDO_CLASSE ausente"},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			tr, err := schema.preparar(c.header)
+			if c.erro != "" {
+				if err == nil || !strings.Contains(err.Error(), c.erro) {
+					t.Fatalf("preparar(%q) = %v; queria erro com %q", c.header, err, c.erro)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			queriaHeader := append([]string{"CNPJ_FUNDO_CLASSE", "ID_SUBCLASSE", "VL_QUOTA"}, c.extras...)
+			if !reflect.DeepEqual(tr.header, queriaHeader) {
+				t.Errorf("header = %q, queria %q", tr.header, queriaHeader)
+			}
+			if !reflect.DeepEqual(tr.extras, c.extras) || !reflect.DeepEqual(tr.duplicadas, c.duplicadas) {
+				t.Errorf("extras %q, duplicadas %q; queria %q, %q", tr.extras, tr.duplicadas, c.extras, c.duplicadas)
+			}
+			out, _, err := tr.linha(c.linha)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(out, c.saida) {
+				t.Errorf("linha(%q) = %q, queria %q", c.linha, out, c.saida)
+			}
+		})
+	}
+}
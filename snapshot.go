@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// periodoSnapshot é o tamanho do período de um snapshot
+type periodoSnapshot string
+
+const (
+	periodoSemana    periodoSnapshot = "week" // segunda a domingo
+	periodoMes       periodoSnapshot = "month"
+	periodoTrimestre periodoSnapshot = "quarter"
+	periodoAno       periodoSnapshot = "year"
+)
+
+// posicaoPeriodo diz qual dia do período entra no snapshot
+type posicaoPeriodo string
+
+const (
+	posicaoPrimeiro posicaoPeriodo = "first"
+	posicaoUltimo   posicaoPeriodo = "last"
+)
+
+// configSnapshot descreve um snapshot do inf_diario padronizado: para cada
+// (CNPJ_FUNDO_CLASSE, ID_SUBCLASSE) e período, a linha do primeiro ou do último
+// dia útil informado. Com anterior, cada linha leva também DT_COMPTC e as
+// colunasAnterior do snapshot do período imediatamente anterior.
+type configSnapshot struct {
+	periodo         periodoSnapshot
+	posicao         posicaoPeriodo
+	anterior        bool
+	colunasAnterior []string
+	entrada         string // diretório dos arquivos diários padronizados
+	saida           string // diretório dos snapshots
+}
+
+// snapshotUltimoDiaMes é o snapshot histórico do pipeline (pick-last-day)
+var snapshotUltimoDiaMes = configSnapshot{
+	periodo:         periodoMes,
+	posicao:         posicaoUltimo,
+	colunasAnterior: []string{"VL_QUOTA"},
+	entrada:         "csvs/inf_diario_padronized",
+	saida:           "csvs/inf_diario_ultimos_dias",
+}
+
+// novoConfigSnapshot valida --period e --position; só o snapshot mensal do
+// último dia sem --previous grava em csvs/inf_diario_ultimos_dias (que a carga
+// lê com layout fixo), os demais vão para
+// csvs/inf_diario_snapshots/<period>_<position>[_anterior]
+func novoConfigSnapshot(periodo, posicao string, anterior bool) (configSnapshot, error) {
+	cfg := snapshotUltimoDiaMes
+	cfg.periodo, cfg.posicao, cfg.anterior = periodoSnapshot(periodo), posicaoPeriodo(posicao), anterior
+	switch cfg.periodo {
+	case periodoSemana, periodoMes, periodoTrimestre, periodoAno:
+	default:
+		return cfg, fmt.Errorf("período inválido: %s (use week, month, quarter ou year)", periodo)
+	}
+	if cfg.posicao != posicaoPrimeiro && cfg.posicao != posicaoUltimo {
+		return cfg, fmt.Errorf("posição inválida: %s (use first ou last)", posicao)
+	}
+	if cfg.periodo != periodoMes || cfg.posicao != posicaoUltimo || cfg.anterior {
+		nome := periodo + "_" + posicao
+		if cfg.anterior {
+			nome += "_anterior"
+		}
+		cfg.saida = filepath.Join("csvs/inf_diario_snapshots", nome)
+	}
+	return cfg, nil
+}
+
+// inicio devolve o primeiro dia do período que contém t
+func (p periodoSnapshot) inicio(t time.Time) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch p {
+	case periodoSemana:
+		return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+	case periodoTrimestre:
+		return time.Date(t.Year(), (t.Month()-1)/3*3+1, 1, 0, 0, 0, 0, time.UTC)
+	case periodoAno:
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// fim devolve o último dia do período que começa em inicio
+func (p periodoSnapshot) fim(inicio time.Time) time.Time {
+	switch p {
+	case periodoSemana:
+		return inicio.AddDate(0, 0, 6)
+	case periodoTrimestre:
+		return inicio.AddDate(0, 3, -1)
+	case periodoAno:
+		return inicio.AddDate(1, 0, -1)
+	}
+	return inicio.AddDate(0, 1, -1)
+}
+
+// grupo é o sufixo do arquivo de saída do período: AAAAMM para mês e semana
+// (pelo mês em que a semana termina), AAAAT<n> para trimestre e AAAA para ano
+func (p periodoSnapshot) grupo(inicio time.Time) string {
+	switch p {
+	case periodoSemana:
+		return p.fim(inicio).Format("200601")
+	case periodoTrimestre:
+		return fmt.Sprintf("%dT%d", inicio.Year(), (int(inicio.Month())-1)/3+1)
+	case periodoAno:
+		return inicio.Format("2006")
+	}
+	return inicio.Format("200601")
+}
+
+// candidatoSnapshot é a melhor linha vista até agora de um fundo num período
+type candidatoSnapshot struct {
+	fundo  string // CNPJ_FUNDO_CLASSE|ID_SUBCLASSE
+	inicio time.Time
+	data   time.Time
+	util   bool
+	linha  []string // alinhada com motorSnapshot.header
+	origem string   // arquivo diário de onde veio a linha
+}
+
+// melhorQue diz se c deve substituir o candidato atual: dias úteis primeiro,
+// depois a data mais cedo (first) ou mais tarde (last); na mesma data vale a
+// linha que veio depois no arquivo
+func (c candidatoSnapshot) melhorQue(atual *candidatoSnapshot, posicao posicaoPeriodo) bool {
+	if c.util != atual.util {
+		return c.util
+	}
+	if posicao == posicaoPrimeiro {
+		return !c.data.After(atual.data)
+	}
+	return !c.data.Before(atual.data)
+}
+
+// motorSnapshot lê os arquivos diários em ordem cronológica e fecha cada
+// período assim que nenhum arquivo posterior pode mais alterá-lo
+type motorSnapshot struct {
+	cfg         configSnapshot
+	header      []string       // união dos cabeçalhos, na ordem em que apareceram
+	indice      map[string]int // coluna -> posição em header
+	abertos     map[string]*candidatoSnapshot
+	anteriores  map[string]*candidatoSnapshot // último snapshot fechado de cada fundo
+	fechadoAte  time.Time                     // períodos que terminam antes disso já foram gravados
+	grupos      map[string]bool               // arquivos de saída pedidos; os demais períodos só alimentam anteriores
+	descartadas int
+}
+
+// planejar devolve os meses a ler, em ordem, e os grupos de saída dos meses
+// pedidos. Os períodos são lidos inteiros (um trimestre pedido pelo mês 2 lê
+// os meses 1 a 3) e, com anterior, o período anterior também é lido.
+func (cfg configSnapshot) planejar(pedidos []time.Time) ([]time.Time, map[string]bool) {
+	grupos := map[string]bool{}
+	meses := map[time.Time]bool{}
+	for _, mes := range pedidos {
+		desde := cfg.periodo.inicio(mes)
+		ate := cfg.periodo.fim(desde)
+		if cfg.periodo == periodoSemana {
+			// semanas vão para o arquivo do mês em que terminam
+			grupos[mes.Format("200601")] = true
+			ate = mes
+		} else {
+			grupos[cfg.periodo.grupo(desde)] = true
+		}
+		if cfg.anterior {
+			desde = cfg.periodo.inicio(desde.AddDate(0, 0, -1))
+		}
+		for m := time.Date(desde.Year(), desde.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(ate); m = m.AddDate(0, 1, 0) {
+			meses[m] = true
+		}
+	}
+
+	leitura := make([]time.Time, 0, len(meses))
+	for m := range meses {
+		leitura = append(leitura, m)
+	}
+	sort.Slice(leitura, func(i, j int) bool { return leitura[i].Before(leitura[j]) })
+	return leitura, grupos
+}
+
+// gerarSnapshots gera os snapshots dos meses pedidos
+func gerarSnapshots(cfg configSnapshot, anos, meses []int) error {
+	var pedidos []time.Time
+	for _, ano := range anos {
+		for _, mes := range meses {
+			pedidos = append(pedidos, time.Date(ano, time.Month(mes), 1, 0, 0, 0, 0, time.UTC))
+		}
+	}
+	leitura, grupos := cfg.planejar(pedidos)
+
+	m := &motorSnapshot{cfg: cfg, indice: map[string]int{}, grupos: grupos,
+		abertos: map[string]*candidatoSnapshot{}, anteriores: map[string]*candidatoSnapshot{}}
+	for _, mes := range leitura {
+		arquivo := filepath.Join(cfg.entrada, fmt.Sprintf("inf_diario_fi_%s.csv", mes.Format("200601")))
+		if _, err := os.Stat(arquivo); err != nil {
+			continue
+		}
+		if err := m.ler(arquivo); err != nil {
+			return err
+		}
+		if err := m.fechar(mes.AddDate(0, 1, 0)); err != nil {
+			return err
+		}
+	}
+	if err := m.fechar(time.Time{}); err != nil {
+		return err
+	}
+
+	if m.descartadas > 0 {
+		fmt.Printf("%d linhas com DT_COMPTC inválida ou de período já fechado foram ignoradas\n", m.descartadas)
+	}
+	return nil
+}
+
+// ler acumula os candidatos de um arquivo diário
+func (m *motorSnapshot) ler(arquivo string) error {
+	f, err := os.Open(arquivo)
+	if err != nil {
+		return fmt.Errorf("erro ao abrir arquivo %s: %w", arquivo, err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("erro ao ler cabeçalho de %s: %w", arquivo, err)
+	}
+	destino := make([]int, len(header))
+	for i, h := range header {
+		j, ok := m.indice[h]
+		if !ok {
+			j = len(m.header)
+			m.header = append(m.header, h)
+			m.indice[h] = j
+		}
+		destino[i] = j
+	}
+	iData, okData := m.indice["DT_COMPTC"]
+	iCNPJ, okCNPJ := m.indice["CNPJ_FUNDO_CLASSE"]
+	if !okData || !okCNPJ {
+		return fmt.Errorf("%s sem DT_COMPTC ou CNPJ_FUNDO_CLASSE", arquivo)
+	}
+	iSub, okSub := m.indice["ID_SUBCLASSE"]
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("erro ao ler %s: %w", arquivo, err)
+		}
+		linha := make([]string, len(m.header))
+		for i, v := range record {
+			if i < len(destino) {
+				linha[destino[i]] = v
+			}
+		}
+
+		data, err := time.Parse(layoutData, strings.TrimSpace(linha[iData]))
+		inicio := m.cfg.periodo.inicio(data)
+		if err != nil || m.cfg.periodo.fim(inicio).Before(m.fechadoAte) {
+			m.descartadas++
+			continue
+		}
+		fundo := strings.TrimSpace(linha[iCNPJ])
+		if okSub {
+			fundo += "|" + strings.TrimSpace(linha[iSub])
+		} else {
+			fundo += "|"
+		}
+
+		c := &candidatoSnapshot{fundo: fundo, inicio: inicio, data: data, util: ehDiaUtil(data), linha: linha, origem: arquivo}
+		chave := fundo + "|" + inicio.Format(layoutData)
+		if atual, ok := m.abertos[chave]; !ok || c.melhorQue(atual, m.cfg.posicao) {
+			m.abertos[chave] = c
+		}
+	}
+	return nil
+}
+
+// fechar grava os períodos que terminam antes de limite (zero fecha todos)
+func (m *motorSnapshot) fechar(limite time.Time) error {
+	var fechados []*candidatoSnapshot
+	for chave, c := range m.abertos {
+		if limite.IsZero() || m.cfg.periodo.fim(c.inicio).Before(limite) {
+			fechados = append(fechados, c)
+			delete(m.abertos, chave)
+		}
+	}
+	if !limite.IsZero() {
+		m.fechadoAte = limite
+	}
+	// ordem determinística: período, CNPJ_FUNDO_CLASSE, ID_SUBCLASSE
+	sort.Slice(fechados, func(i, j int) bool {
+		if !fechados[i].inicio.Equal(fechados[j].inicio) {
+			return fechados[i].inicio.Before(fechados[j].inicio)
+		}
+		return fechados[i].fundo < fechados[j].fundo
+	})
+
+	porGrupo := map[string][][]string{}
+	origens := map[string][]string{} // arquivos diários de cada grupo, para a linhagem
+	var grupos []string
+	for _, c := range fechados {
+		linha := c.linha
+		if m.cfg.anterior {
+			linha = append(linha[:len(linha):len(linha)], m.valoresAnteriores(c)...)
+		}
+		m.anteriores[c.fundo] = c
+		g := m.cfg.periodo.grupo(c.inicio)
+		if !m.grupos[g] {
+			continue
+		}
+		if _, ok := porGrupo[g]; !ok {
+			grupos = append(grupos, g)
+		}
+		porGrupo[g] = append(porGrupo[g], linha)
+		if !slices.Contains(origens[g], c.origem) {
+			origens[g] = append(origens[g], c.origem)
+		}
+	}
+
+	for _, g := range grupos {
+		if err := m.gravar(g, porGrupo[g], origens[g]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// valoresAnteriores devolve DT_COMPTC e colunasAnterior do snapshot do período
+// imediatamente anterior do mesmo fundo; vazios se o fundo não tem esse snapshot
+func (m *motorSnapshot) valoresAnteriores(c *candidatoSnapshot) []string {
+	out := make([]string, 1+len(m.cfg.colunasAnterior))
+	ant, ok := m.anteriores[c.fundo]
+	if !ok || !ant.inicio.Equal(m.cfg.periodo.inicio(c.inicio.AddDate(0, 0, -1))) {
+		return out
+	}
+	out[0] = ant.linha[m.indice["DT_COMPTC"]]
+	for i, col := range m.cfg.colunasAnterior {
+		if j, ok := m.indice[col]; ok && j < len(ant.linha) {
+			out[i+1] = ant.linha[j]
+		}
+	}
+	return out
+}
+
+// gravar escreve um arquivo de saída, com as linhas completadas até o cabeçalho
+// atual, e a linhagem: os downloads e as linhas rejeitadas na padronização dos
+// arquivos diários de onde as linhas vieram
+func (m *motorSnapshot) gravar(grupo string, linhas [][]string, origens []string) error {
+	header := append([]string(nil), m.header...)
+	if m.cfg.anterior {
+		header = append(header, "DT_COMPTC_ANTERIOR")
+		for _, col := range m.cfg.colunasAnterior {
+			header = append(header, col+"_ANTERIOR")
+		}
+	}
+	base := len(m.header)
+
+	saida := filepath.Join(m.cfg.saida, fmt.Sprintf("inf_diario_fi_%s.csv", grupo))
+	err := writeAtomic(saida, 0644, func(w io.Writer) error {
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		for _, l := range linhas {
+			// linhas lidas antes de o cabeçalho crescer ficam com as colunas novas vazias
+			out := make([]string, len(header))
+			n := len(l)
+			if m.cfg.anterior {
+				n -= 1 + len(m.cfg.colunasAnterior)
+				copy(out[base:], l[n:])
+			}
+			copy(out, l[:n])
+			if err := cw.Write(out); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+	if err != nil {
+		return fmt.Errorf("erro ao escrever CSV em %s: %w", saida, err)
+	}
+
+	var lin linhagemArquivo
+	for _, o := range origens {
+		if l, ok := lerLinhagem(o); ok {
+			lin.juntar(l)
+		} else {
+			lin.juntar(linhagemArquivo{Origens: []origemArquivo{{Arquivo: o}}, Rejeitadas: linhasRejeitadas(o)})
+		}
+	}
+	if err := gravarLinhagem(saida, lin); err != nil {
+		return fmt.Errorf("erro ao gravar a linhagem de %s: %w", saida, err)
+	}
+	fmt.Printf("Arquivo %s gerado com sucesso!\n", saida)
+	return nil
+}
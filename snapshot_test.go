@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNovoConfigSnapshot(t *testing.T) {
+	casos := []struct {
+		periodo, posicao string
+		anterior         bool
+		saida            string
+		erro             bool
+	}{
+		{"month", "last", false, "csvs/inf_diario_ultimos_dias", false},
+		{"month", "last", true, "csvs/inf_diario_snapshots/month_last_anterior", false},
+		{"month", "first", false, "csvs/inf_diario_snapshots/month_first", false},
+		{"quarter", "last", true, "csvs/inf_diario_snapshots/quarter_last_anterior", false},
+		{"week", "first", false, "csvs/inf_diario_snapshots/week_first", false},
+		{"day", "last", false, "", true},
+		{"month", "middle", false, "", true},
+	}
+	for _, c := range casos {
+		cfg, err := novoConfigSnapshot(c.periodo, c.posicao, c.anterior)
+		if (err != nil) != c.erro {
+			t.Fatalf("novoConfigSnapshot(%s, %s, %v): erro %v, queria erro=%v", c.periodo, c.posicao, c.anterior, err, c.erro)
+		}
+		if err == nil && cfg.saida != c.saida {
+			t.Errorf("novoConfigSnapshot(%s, %s, %v).saida = %s, queria %s", c.periodo, c.posicao, c.anterior, cfg.saida, c.saida)
+		}
+	}
+}
+
+func TestPeriodoSnapshot(t *testing.T) {
+	casos := []struct {
+		periodo            periodoSnapshot
+		dia                string
+		inicio, fim, grupo string
+	}{
+		{periodoMes, "2025-02-14", "2025-02-01", "2025-02-28", "202502"},
+		{periodoMes, "2024-02-29", "2024-02-01", "2024-02-29", "202402"},
+		{periodoSemana, "2025-04-23", "2025-04-21", "2025-04-27", "202504"},
+		{periodoSemana, "2025-04-21", "2025-04-21", "2025-04-27", "202504"},
+		{periodoSemana, "2025-04-27", "2025-04-21", "2025-04-27", "202504"},
+		// semana que vira o mês vai para o mês em que termina
+		{periodoSemana, "2025-04-30", "2025-04-28", "2025-05-04", "202505"},
+		{periodoTrimestre, "2025-05-10", "2025-04-01", "2025-06-30", "2025T2"},
+		{periodoTrimestre, "2025-12-31", "2025-10-01", "2025-12-31", "2025T4"},
+		{periodoAno, "2025-07-01", "2025-01-01", "2025-12-31", "2025"},
+	}
+	for _, c := range casos {
+		inicio := c.periodo.inicio(dataTeste(t, c.dia))
+		fim := c.periodo.fim(inicio)
+		grupo := c.periodo.grupo(inicio)
+		if inicio.Format(layoutData) != c.inicio || fim.Format(layoutData) != c.fim || grupo != c.grupo {
+			t.Errorf("%s em %s: inicio %s, fim %s, grupo %s; queria %s, %s, %s", c.periodo, c.dia,
+				inicio.Format(layoutData), fim.Format(layoutData), grupo, c.inicio, c.fim, c.grupo)
+		}
+	}
+}
+
+func TestCandidatoSnapshotMelhorQue(t *testing.T) {
+	candidato := func(dia string, util bool) candidatoSnapshot {
+		return candidatoSnapshot{data: dataTeste(t, dia), util: util}
+	}
+	casos := []struct {
+		nome        string
+		novo, atual candidatoSnapshot
+		posicao     posicaoPeriodo
+		substitui   bool
+	}{
+		{"last: data mais tarde", candidato("2025-01-31", true), candidato("2025-01-30", true), posicaoUltimo, true},
+		{"last: data mais cedo", candidato("2025-01-29", true), candidato("2025-01-30", true), posicaoUltimo, false},
+		{"first: data mais cedo", candidato("2025-01-02", true), candidato("2025-01-03", true), posicaoPrimeiro, true},
+		{"first: data mais tarde", candidato("2025-01-06", true), candidato("2025-01-03", true), posicaoPrimeiro, false},
+		{"mesma data: vale a última lida", candidato("2025-01-31", true), candidato("2025-01-31", true), posicaoUltimo, true},
+		{"dia útil vence fim de semana", candidato("2025-01-30", true), candidato("2025-02-01", false), posicaoUltimo, true},
+		{"fim de semana não vence dia útil", candidato("2025-02-01", false), candidato("2025-01-31", true), posicaoUltimo, false},
+	}
+	for _, c := range casos {
+		if got := c.novo.melhorQue(&c.atual, c.posicao); got != c.substitui {
+			t.Errorf("%s: melhorQue = %v, queria %v", c.nome, got, c.substitui)
+		}
+	}
+}
+
+func TestConfigSnapshotPlanejar(t *testing.T) {
+	mes := func(ano int, m time.Month) time.Time { return time.Date(ano, m, 1, 0, 0, 0, 0, time.UTC) }
+	casos := []struct {
+		nome     string
+		periodo  periodoSnapshot
+		anterior bool
+		pedidos  []time.Time
+		leitura  []string
+		grupos   []string
+	}{
+		{"mês", periodoMes, false, []time.Time{mes(2025, 3)}, []string{"202503"}, []string{"202503"}},
+		{"mês com anterior", periodoMes, true, []time.Time{mes(2025, 1)}, []string{"202412", "202501"}, []string{"202501"}},
+		{"trimestre lê inteiro", periodoTrimestre, false, []time.Time{mes(2025, 5)}, []string{"202504", "202505", "202506"}, []string{"2025T2"}},
+		{"ano com anterior", periodoAno, true, []time.Time{mes(2025, 1)},
+			[]string{"202401", "202402", "202403", "202404", "202405", "202406", "202407", "202408", "202409", "202410", "202411", "202412",
+				"202501", "202502", "202503", "202504", "202505", "202506", "202507", "202508", "202509", "202510", "202511", "202512"}, []string{"2025"}},
+		{"semana começa no mês anterior", periodoSemana, false, []time.Time{mes(2025, 6)}, []string{"202505", "202506"}, []string{"202506"}},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			cfg := configSnapshot{periodo: c.periodo, posicao: posicaoUltimo, anterior: c.anterior}
+			leitura, grupos := cfg.planejar(c.pedidos)
+			var meses []string
+			for _, m := range leitura {
+				meses = append(meses, m.Format("200601"))
+			}
+			if !reflect.DeepEqual(meses, c.leitura) {
+				t.Errorf("leitura = %v, queria %v", meses, c.leitura)
+			}
+			if len(grupos) != len(c.grupos) {
+				t.Errorf("grupos = %v, queria %v", grupos, c.grupos)
+			}
+			for _, g := range c.grupos {
+				if !grupos[g] {
+					t.Errorf("grupos = %v, falta %s", grupos, g)
+				}
+			}
+		})
+	}
+}
+
+func TestGerarSnapshotsUltimoDiaComAnterior(t *testing.T) {
+	dir := t.TempDir()
+	escrever := func(nome, conteudo string) {
+		if err := os.WriteFile(filepath.Join(dir, nome), []byte(conteudo), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	escrever("inf_diario_fi_202501.csv", "CNPJ_FUNDO_CLASSE,ID_SUBCLASSE,DT_COMPTC,VL_QUOTA\n"+
+		"11.222.333/0001-81,,2025-01-30,1.10\n"+
+		"11.222.333/0001-81,,2025-01-31,1.11\n")
+	// 01/03/2025 é sábado: não vence o último dia útil de fevereiro
+	escrever("inf_diario_fi_202502.csv", "CNPJ_FUNDO_CLASSE,ID_SUBCLASSE,DT_COMPTC,VL_QUOTA\n"+
+		"11.222.333/0001-81,,2025-02-27,1.20\n"+
+		"11.222.333/0001-81,,2025-02-28,1.21\n"+
+		"00.000.000/0001-91,A,2025-02-27,5.00\n")
+
+	cfg := snapshotUltimoDiaMes
+	cfg.anterior = true
+	cfg.entrada, cfg.saida = dir, filepath.Join(dir, "saida")
+	if err := gerarSnapshots(cfg, []int{2025}, []int{2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.saida, "inf_diario_fi_202501.csv")); err == nil {
+		t.Errorf("o mês anterior só alimenta *_ANTERIOR e não deveria ser gravado")
+	}
+	f, err := os.Open(filepath.Join(cfg.saida, "inf_diario_fi_202502.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	registros, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queria := [][]string{
+		{"CNPJ_FUNDO_CLASSE", "ID_SUBCLASSE", "DT_COMPTC", "VL_QUOTA", "DT_COMPTC_ANTERIOR", "VL_QUOTA_ANTERIOR"},
+		{"00.000.000/0001-91", "A", "2025-02-27", "5.00", "", ""},
+		{"11.222.333/0001-81", "", "2025-02-28", "1.21", "2025-01-31", "1.11"},
+	}
+	if !reflect.DeepEqual(registros, queria) {
+		t.Errorf("snapshot =\n%s\nqueria\n%s", juntarRegistros(registros), juntarRegistros(queria))
+	}
+}
+
+func juntarRegistros(rs [][]string) string {
+	var linhas []string
+	for _, r := range rs {
+		linhas = append(linhas, strings.Join(r, ","))
+	}
+	return strings.Join(linhas, "\n")
+}